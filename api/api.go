@@ -18,6 +18,7 @@ type server struct {
 	docRoot        string
 	bpdbBackend    bpdb.Bpdb
 	configFilename string
+	auth           auth.Auth // nil when authentication is disabled
 }
 
 var (
@@ -63,7 +64,12 @@ func (s *server) Setup() error {
 	api.Use(jsonResponse)
 	api.Get("/schemas", s.allSchemas)
 	api.Get("/schema/:id", s.schema)
+	api.Get("/schema/:id/versions", s.schemaVersions)
+	api.Get("/schema/:id/version/:version", s.schemaAtVersion)
+	api.Get("/schema/:id/diff", s.schemaDiff)
+	api.Get("/schema/:id/ddl", s.schemaDDL)
 	api.Get("/migration/:schema", s.migration)
+	api.Get("/migration/:schema/ddl", s.migrationDDL)
 	api.Get("/types", s.types)
 	api.Get("/suggestions", s.listSuggestions)
 	api.Get("/suggestion/:id", s.suggestion)
@@ -82,6 +88,10 @@ func (s *server) Setup() error {
 		api.Post("/ingest", s.ingest)
 		api.Put("/schema", s.createSchema)
 		api.Post("/schema/:id", s.updateSchema)
+		api.Post("/schema/:id/revert", s.revertSchema)
+		api.Post("/schema/:id/rename", s.renameEvent)
+		api.Post("/schema/:id/state", s.setEventState)
+		api.Post("/schema/:id/expired", s.deleteExpiredColumns)
 		api.Post("/removesuggestion/:id", s.removeSuggestion)
 
 		goji.Handle("/ingest", api)
@@ -100,6 +110,7 @@ func (s *server) Setup() error {
 				loginURL)
 
 			api.Use(a.AuthorizeOrForbid)
+			s.auth = a
 
 			goji.Handle(loginURL, a.LoginHandler)
 			goji.Handle(logoutURL, a.LogoutHandler)
@@ -18,9 +18,11 @@ import (
 
 	"github.com/twitchscience/aws_utils/logger"
 	"github.com/twitchscience/blueprint/auth"
+	"github.com/twitchscience/blueprint/bpdb"
 	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/redshift"
+	"github.com/twitchscience/blueprint/transformers"
 	"github.com/twitchscience/scoop_protocol/scoop_protocol"
-	"github.com/twitchscience/scoop_protocol/transformer"
 
 	"github.com/zenazn/goji/web"
 )
@@ -134,34 +136,37 @@ func (s *server) createSchema(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var cfg scoop_protocol.Config
-	err = json.Unmarshal(b, &cfg)
+	var req core.ClientCreateSchemaRequest
+	err = json.Unmarshal(b, &req)
 	if err != nil {
 		log.Printf("Error getting marshalling config to json: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
 
-	blacklisted, err := s.isBlacklisted(cfg.EventName)
+	blacklisted, err := s.isBlacklisted(req.EventName)
 	if err != nil {
 		logger.WithError(err).
-			WithField("event_name", cfg.EventName).
+			WithField("event_name", req.EventName).
 			Error("Failed to test event in the blacklist")
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	if blacklisted {
-		http.Error(w, fmt.Sprintf("%v is blacklisted", cfg.EventName), http.StatusForbidden)
+		http.Error(w, fmt.Sprintf("%v is blacklisted", req.EventName), http.StatusForbidden)
 		return
 	}
 
-	err = s.bpdbBackend.CreateSchema(&cfg)
+	warnings, err := s.bpdbBackend.CreateSchema(&req)
 	if err != nil {
 		logger.WithError(err).Error("Error creating schema.")
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		respondWithWriteError(w, err)
 		return
 	}
+	writeEvent(w, writeResponse{Warnings: warnings})
 }
 
 var (
@@ -243,22 +248,298 @@ func (s *server) updateSchema(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	req.EventName = eventName
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
+	req.BaseVersion, err = baseVersion(r)
+	if err != nil {
+		respondWithJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
-	err = s.bpdbBackend.UpdateSchema(&req)
+	warnings, err := s.bpdbBackend.UpdateSchema(&req)
 	if err != nil {
 		logger.WithError(err).Error("Error updating schema.")
+		respondWithWriteError(w, err)
+		return
+	}
+	writeEvent(w, writeResponse{Warnings: warnings})
+}
+
+// revertSchema restores a schema to an earlier version by applying the inverse
+// of every later change, and responds with the operations applied and the
+// lint warnings about the reverted schema. With ?dry_run=true the operations
+// are returned without being applied.
+func (s *server) revertSchema(c web.C, w http.ResponseWriter, r *http.Request) {
+	eventName := c.URLParams["id"]
+
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close request body")
+		}
+	}()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body in revertSchema: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+
+	var req core.ClientRevertSchemaRequest
+	err = json.Unmarshal(b, &req)
+	if err != nil {
+		log.Printf("Error unmarshalling request body in revertSchema: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.EventName = eventName
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
+	if r.URL.Query().Get("dry_run") == "true" {
+		req.DryRun = true
+	}
+	req.BaseVersion, err = baseVersion(r)
+	if err != nil {
+		respondWithJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ops, warnings, err := s.bpdbBackend.RevertSchema(&req)
+	if err != nil {
+		logger.WithError(err).Error("Error reverting schema.")
+		respondWithWriteError(w, err)
+		return
+	}
+	writeEvent(w, revertResponse{Operations: ops, Warnings: warnings})
 }
 
+// renameEvent renames the event to the NewName in the body, which must not be
+// blacklisted. The rename is stored as a version of its own, and the versions
+// before it keep the old name, so replaying the migration DDL renames the
+// table. The old name stays an alias that ingesters can follow.
+func (s *server) renameEvent(c web.C, w http.ResponseWriter, r *http.Request) {
+	eventName := c.URLParams["id"]
+
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close request body")
+		}
+	}()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body in renameEvent: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req core.ClientRenameEventRequest
+	err = json.Unmarshal(b, &req)
+	if err != nil {
+		log.Printf("Error unmarshalling request body in renameEvent: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.EventName = eventName
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
+	req.BaseVersion, err = baseVersion(r)
+	if err != nil {
+		respondWithJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	blacklisted, err := s.isBlacklisted(req.NewName)
+	if err != nil {
+		logger.WithError(err).
+			WithField("event_name", req.NewName).
+			Error("Failed to test event in the blacklist")
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if blacklisted {
+		http.Error(w, fmt.Sprintf("%v is blacklisted", req.NewName), http.StatusForbidden)
+		return
+	}
+
+	err = s.bpdbBackend.RenameEvent(&req)
+	if err != nil {
+		logger.WithError(err).Error("Error renaming event.")
+		respondWithWriteError(w, err)
+		return
+	}
+}
+
+// setEventState moves an event to another lifecycle state: publishing a
+// draft makes it visible to ingesters, and retiring an event hides it from
+// them while keeping its history.
+func (s *server) setEventState(c web.C, w http.ResponseWriter, r *http.Request) {
+	eventName := c.URLParams["id"]
+
+	defer func() {
+		err := r.Body.Close()
+		if err != nil {
+			logger.WithError(err).Error("Failed to close request body")
+		}
+	}()
+
+	b, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		log.Printf("Error reading request body in setEventState: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var req core.ClientSetEventStateRequest
+	err = json.Unmarshal(b, &req)
+	if err != nil {
+		log.Printf("Error unmarshalling request body in setEventState: %v", err)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	req.EventName = eventName
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
+	req.BaseVersion, err = baseVersion(r)
+	if err != nil {
+		respondWithJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	err = s.bpdbBackend.SetEventState(&req)
+	if err != nil {
+		logger.WithError(err).Error("Error setting event state.")
+		respondWithWriteError(w, err)
+		return
+	}
+}
+
+// deleteExpiredColumns deletes every deprecated column of a schema whose grace
+// period has ended as a single update, and responds with their names. The
+// update is rejected if the schema changes in the meantime.
+func (s *server) deleteExpiredColumns(c web.C, w http.ResponseWriter, r *http.Request) {
+	eventName := c.URLParams["id"]
+	// the update is based on these reads, so they must not lag behind it
+	primary := bpdb.Primary(s.bpdbBackend)
+	cfg, err := primary.Schema(eventName)
+	if err != nil {
+		logger.WithError(err).WithField("schema", eventName).Error("Failed to get schema")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	deprecations, err := bpdb.DeprecatedColumnsAtVersion(primary, eventName, cfg.Version)
+	if err != nil {
+		logger.WithError(err).WithField("schema", eventName).Error("Failed to get deprecated columns")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	expired := bpdb.ExpiredColumns(deprecations, time.Now())
+	if len(expired) == 0 {
+		respondWithJSONError(w, fmt.Sprintf("No deprecated columns of '%s' are past their grace period.", eventName), http.StatusBadRequest)
+		return
+	}
+
+	req := core.ClientUpdateSchemaRequest{
+		EventName: eventName,
+		Deletes:   expired,
+	}
+	req.IdempotencyKey = r.Header.Get("Idempotency-Key")
+	req.Author = s.author(r)
+	req.Reason = "grace period of deprecated columns ended"
+	req.BaseVersion, err = baseVersion(r)
+	if err != nil {
+		respondWithJSONError(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if req.BaseVersion == nil {
+		req.BaseVersion = &cfg.Version
+	}
+
+	_, err = s.bpdbBackend.UpdateSchema(&req)
+	if err != nil {
+		logger.WithError(err).Error("Error deleting expired columns.")
+		respondWithWriteError(w, err)
+		return
+	}
+	writeEvent(w, expired)
+}
+
+// allSchemas responds with the schemas ingesters see. With ?state= set to a
+// comma-separated list of lifecycle states, or "all", it responds with the
+// schemas of the events in those states along with their state instead. With
+// ?as_of= set to a global sequence number or an RFC3339 timestamp, it responds
+// with the schemas ingesters saw at that point.
 func (s *server) allSchemas(w http.ResponseWriter, r *http.Request) {
-	cfgs, err := s.bpdbBackend.AllSchemas()
+	states := r.URL.Query().Get("state")
+	if asOf := r.URL.Query().Get("as_of"); asOf != "" {
+		if states != "" {
+			respondWithJSONError(w, "Error, 'as_of' can't be combined with 'state'.", http.StatusBadRequest)
+			return
+		}
+		s.schemasAsOf(w, asOf)
+		return
+	}
+	if states == "" {
+		cfgs, err := s.bpdbBackend.AllSchemas()
+		if err != nil {
+			log.Printf("Error retrieving allSchemas: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeEvent(w, cfgs)
+		return
+	}
+
+	var filter []string
+	if states != "all" {
+		filter = strings.Split(states, ",")
+		for _, state := range filter {
+			if !core.IsState(state) {
+				respondWithJSONError(w, fmt.Sprintf("Error, unknown state '%s'.", state), http.StatusBadRequest)
+				return
+			}
+		}
+	}
+	schemas, err := s.bpdbBackend.EventSchemas(filter...)
 	if err != nil {
-		log.Printf("Error retrieving allSchemas: %v", err)
+		log.Printf("Error retrieving schemas in states %s: %v", states, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	writeEvent(w, schemas)
+}
+
+// schemasAsOf responds with the schemas ingesters saw once the operation with
+// the global sequence number `asOf` was committed, or at the time `asOf`. The
+// sequence number the snapshot is at is returned in the X-Sequence header.
+func (s *server) schemasAsOf(w http.ResponseWriter, asOf string) {
+	sequence, err := strconv.ParseInt(asOf, 10, 64)
+	if err != nil {
+		t, timeErr := time.Parse(time.RFC3339, asOf)
+		if timeErr != nil {
+			respondWithJSONError(w, "Error, 'as_of' must be a sequence number or an RFC3339 timestamp.", http.StatusBadRequest)
+			return
+		}
+		sequence, err = s.bpdbBackend.SequenceAt(t)
+		if err != nil {
+			logger.WithError(err).WithField("as_of", asOf).Error("Failed to get sequence number")
+			respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+			return
+		}
+	}
+	if sequence < 0 {
+		respondWithJSONError(w, "Error, 'as_of' sequence number must be non-negative.", http.StatusBadRequest)
+		return
+	}
+	cfgs, err := s.bpdbBackend.SchemasAsOf(sequence)
+	if err != nil {
+		logger.WithError(err).WithField("as_of", asOf).Error("Failed to get schemas as of sequence number")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("X-Sequence", strconv.FormatInt(sequence, 10))
 	writeEvent(w, cfgs)
 }
 
@@ -273,7 +554,166 @@ func (s *server) schema(c web.C, w http.ResponseWriter, r *http.Request) {
 		fourOhFour(w, r)
 		return
 	}
-	writeEvent(w, []scoop_protocol.Config{*cfg})
+	resp := s.newSchemaResponse(cfg)
+	w.Header().Set("ETag", versionETag(cfg.Version))
+	warnIfDeprecated(w, cfg.EventName, resp.State)
+	writeEvent(w, []schemaResponse{resp})
+}
+
+func (s *server) schemaVersions(c web.C, w http.ResponseWriter, r *http.Request) {
+	versions, err := s.bpdbBackend.Versions(c.URLParams["id"])
+	if err != nil {
+		logger.WithError(err).WithField("schema", c.URLParams["id"]).Error("Failed to get schema versions")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	if len(versions) == 0 {
+		fourOhFour(w, r)
+		return
+	}
+	writeEvent(w, versions)
+}
+
+func (s *server) schemaAtVersion(c web.C, w http.ResponseWriter, r *http.Request) {
+	version, err := strconv.Atoi(c.URLParams["version"])
+	if err != nil || version < 0 {
+		respondWithJSONError(w, "Error, version must be non-negative integer.", http.StatusBadRequest)
+		return
+	}
+	cfg, err := s.bpdbBackend.SchemaAtVersion(c.URLParams["id"], version)
+	if err != nil {
+		logger.WithError(err).
+			WithField("schema", c.URLParams["id"]).
+			WithField("version", version).
+			Error("Failed to get schema at version")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	if cfg == nil {
+		fourOhFour(w, r)
+		return
+	}
+	w.Header().Set("ETag", versionETag(cfg.Version))
+	writeEvent(w, []schemaResponse{s.newSchemaResponse(cfg)})
+}
+
+// schemaDiff responds with the difference between versions `from` and `to` of
+// a schema, or between the schema and the schema `to_event` if given. Missing
+// versions default to the current version of their schema.
+func (s *server) schemaDiff(c web.C, w http.ResponseWriter, r *http.Request) {
+	args := r.URL.Query()
+	fromEvent := c.URLParams["id"]
+	toEvent := args.Get("to_event")
+	if toEvent == "" {
+		toEvent = fromEvent
+	}
+	from, err := diffVersion(args.Get("from"))
+	if err != nil {
+		respondWithJSONError(w, "Error, 'from' argument must be non-negative integer.", http.StatusBadRequest)
+		return
+	}
+	to, err := diffVersion(args.Get("to"))
+	if err != nil {
+		respondWithJSONError(w, "Error, 'to' argument must be non-negative integer.", http.StatusBadRequest)
+		return
+	}
+
+	var diff *bpdb.SchemaDiff
+	if toEvent == fromEvent {
+		diff, err = bpdb.DiffVersions(s.bpdbBackend, fromEvent, from, to)
+	} else {
+		diff, err = bpdb.DiffEvents(s.bpdbBackend, fromEvent, from, toEvent, to)
+	}
+	if err != nil {
+		logger.WithError(err).
+			WithField("schema", fromEvent).
+			WithField("to_event", toEvent).
+			Error("Failed to diff schemas")
+		respondWithJSONError(w, "Internal Service Error", http.StatusInternalServerError)
+		return
+	}
+	if diff == nil {
+		fourOhFour(w, r)
+		return
+	}
+	writeEvent(w, diff)
+}
+
+// schemaDDL responds with the Redshift statement creating the table for the
+// current version of a schema
+func (s *server) schemaDDL(c web.C, w http.ResponseWriter, r *http.Request) {
+	cfg, err := s.bpdbBackend.Schema(c.URLParams["id"])
+	if err != nil {
+		log.Printf("Error retrieving schemas %s: %v", c.URLParams["id"], err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if cfg == nil {
+		fourOhFour(w, r)
+		return
+	}
+	tableOpts, err := bpdb.TableOptionsAtVersion(s.bpdbBackend, cfg.EventName, cfg.Version)
+	var ddl string
+	if err == nil {
+		ddl, err = redshift.CreateTable(cfg, tableOpts)
+	}
+	if err != nil {
+		logger.WithError(err).WithField("schema", cfg.EventName).Error("Failed to generate DDL")
+		respondWithJSONError(w, "Error generating DDL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", versionETag(cfg.Version))
+	writeEvent(w, []string{ddl})
+}
+
+// migrationDDL responds with the Redshift statements migrating a table to
+// version `to_version`, which create the table for version 0
+func (s *server) migrationDDL(c web.C, w http.ResponseWriter, r *http.Request) {
+	table := c.URLParams["schema"]
+	to, err := strconv.Atoi(r.URL.Query().Get("to_version"))
+	if err != nil || to < 0 {
+		respondWithJSONError(w, "Error, 'to_version' argument must be non-negative integer.", http.StatusBadRequest)
+		return
+	}
+
+	cfg, err := s.bpdbBackend.SchemaAtVersion(table, to)
+	if err == nil && cfg == nil {
+		respondWithJSONError(w, fmt.Sprintf("No migration for table '%s' to v%d.", table, to), http.StatusBadRequest)
+		return
+	}
+	var statements []string
+	if err == nil && to == 0 {
+		var tableOpts *core.TableOptions
+		tableOpts, err = bpdb.TableOptionsAtVersion(s.bpdbBackend, table, 0)
+		if err == nil {
+			var ddl string
+			ddl, err = redshift.CreateTable(cfg, tableOpts)
+			statements = []string{ddl}
+		}
+	} else if err == nil {
+		var before *scoop_protocol.Config
+		var beforeOpts *core.TableOptions
+		var operations []*scoop_protocol.Operation
+		before, err = s.bpdbBackend.SchemaAtVersion(table, to-1)
+		if err == nil {
+			beforeOpts, err = bpdb.TableOptionsAtVersion(s.bpdbBackend, table, to-1)
+		}
+		if err == nil {
+			operations, err = bpdb.OperationsAtVersion(s.bpdbBackend, table, to)
+		}
+		if err == nil {
+			statements, err = redshift.AlterTable(before, beforeOpts, cfg, operations)
+		}
+	}
+	if err != nil {
+		logger.WithError(err).
+			WithField("schema", table).
+			WithField("to_version", to).
+			Error("Failed to generate migration DDL")
+		respondWithJSONError(w, "Error generating DDL: "+err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeEvent(w, statements)
 }
 
 func (s *server) migration(c web.C, w http.ResponseWriter, r *http.Request) {
@@ -296,9 +736,19 @@ func (s *server) migration(c web.C, w http.ResponseWriter, r *http.Request) {
 		return
 	}
 	if len(operations) == 0 {
-		respondWithJSONError(w, fmt.Sprintf("No migration for table '%s' to v%d.", c.URLParams["schema"], to), http.StatusBadRequest)
-		return
+		// a version changing nothing but the event's state, table options or
+		// deprecations has no operations to migrate
+		state, err := bpdb.StateAtVersion(s.bpdbBackend, c.URLParams["schema"], to)
+		if err != nil || state == core.StateDraft {
+			respondWithJSONError(w, fmt.Sprintf("No migration for table '%s' to v%d.", c.URLParams["schema"], to), http.StatusBadRequest)
+			return
+		}
 	}
+	state, err := bpdb.StateAtVersion(s.bpdbBackend, c.URLParams["schema"], bpdb.CurrentVersion)
+	if err != nil {
+		logger.WithError(err).WithField("schema", c.URLParams["schema"]).Warn("Failed to get state")
+	}
+	warnIfDeprecated(w, c.URLParams["schema"], state)
 	b, err := json.Marshal(operations)
 	if err != nil {
 		log.Printf("Error getting marshalling operations to json: %v", err)
@@ -329,9 +779,14 @@ func (s *server) fileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// types returns the names of the transformers new columns can use in
+// "result", and the metadata of every transformer in "types"
 func (s *server) types(w http.ResponseWriter, r *http.Request) {
-	data := make(map[string][]string)
-	data["result"] = transformer.ValidTransforms
+	registry := transformers.Current()
+	data := map[string]interface{}{
+		"result": registry.Names(),
+		"types":  registry.All(),
+	}
 	b, err := json.Marshal(data)
 	if err != nil {
 		log.Printf("Error getting marshalling data to json: %v", err)
@@ -1,10 +1,22 @@
 package api
 
 import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/twitchscience/blueprint/bpdb"
+	"github.com/twitchscience/blueprint/compatibility"
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
 	"github.com/zenazn/goji/web"
 )
 
@@ -19,3 +31,370 @@ func TestMigrationNegativeTo(t *testing.T) {
 			status, http.StatusBadRequest)
 	}
 }
+
+func TestUpdateAndGetSchema(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: ""},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	s := New("", backend, "").(*server)
+
+	recorder := httptest.NewRecorder()
+	body := `{"Additions": [{"InboundName": "os", "OutboundName": "os", "Transformer": "varchar", "ColumnCreationOptions": "(16)"}]}`
+	req, _ := http.NewRequest("POST", "/schema/testerino", strings.NewReader(body))
+	s.updateSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("updateSchema returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/schema/testerino", nil)
+	s.schema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	var cfgs []scoop_protocol.Config
+	err = json.Unmarshal(recorder.Body.Bytes(), &cfgs)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal schema response: %v", err)
+	}
+	if len(cfgs) != 1 || cfgs[0].Version != 1 || len(cfgs[0].Columns) != 2 {
+		t.Errorf("Unexpected schema response: %v", cfgs)
+	}
+	if etag := recorder.Header().Get("ETag"); etag != `"1"` {
+		t.Errorf("schema returned wrong ETag: got %v want %v", etag, `"1"`)
+	}
+
+	// an update against the old version conflicts
+	recorder = httptest.NewRecorder()
+	body = `{"Deletes": ["os"]}`
+	req, _ = http.NewRequest("POST", "/schema/testerino", strings.NewReader(body))
+	req.Header.Set("If-Match", `"0"`)
+	s.updateSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusConflict {
+		t.Errorf("updateSchema returned wrong status code: got %v want %v", status, http.StatusConflict)
+	}
+}
+
+func TestSchemaVersions(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: ""},
+			{InboundName: "os", OutboundName: "os", Transformer: "varchar", ColumnCreationOptions: "(16)"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	_, err = backend.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "testerino", Deletes: []string{"os"}})
+	if err != nil {
+		t.Fatalf("Failed to update schema: %v", err)
+	}
+	s := New("", backend, "").(*server)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/schema/testerino/versions", nil)
+	s.schemaVersions(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	var versions []bpdb.SchemaVersion
+	err = json.Unmarshal(recorder.Body.Bytes(), &versions)
+	if err != nil || len(versions) != 2 {
+		t.Errorf("Unexpected versions response: %v (err %v)", versions, err)
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/schema/testerino/version/0", nil)
+	s.schemaAtVersion(web.C{URLParams: map[string]string{"id": "testerino", "version": "0"}}, recorder, req)
+	var cfgs []scoop_protocol.Config
+	err = json.Unmarshal(recorder.Body.Bytes(), &cfgs)
+	if err != nil || len(cfgs) != 1 || len(cfgs[0].Columns) != 2 {
+		t.Errorf("Unexpected schema at version 0 response: %v (err %v)", cfgs, err)
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("GET", "/schema/testerino/version/5", nil)
+	s.schemaAtVersion(web.C{URLParams: map[string]string{"id": "testerino", "version": "5"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusNotFound {
+		t.Errorf("schemaAtVersion returned wrong status code: got %v want %v", status, http.StatusNotFound)
+	}
+}
+
+func TestRevertSchemaKeyColumn(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: ""},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	_, err = backend.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "testerino",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16) distkey"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update schema: %v", err)
+	}
+	s := New("", backend, "").(*server)
+
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("POST", "/schema/testerino/revert", strings.NewReader(`{"ToVersion": 0}`))
+	s.revertSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusBadRequest {
+		t.Errorf("revertSchema returned wrong status code: got %v want %v", status, http.StatusBadRequest)
+	}
+	if body := recorder.Body.String(); !strings.Contains(body, "os") || !strings.Contains(body, "distkey") {
+		t.Errorf("revertSchema didn't explain the key column: %v", body)
+	}
+}
+
+func TestWriteValidationErrors(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: ""},
+			{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	s := New("", backend, "").(*server)
+	previous := compatibility.Current()
+	compatibility.Use(&compatibility.Policy{Events: map[string]compatibility.Mode{"testerino": compatibility.Backward}})
+	defer compatibility.Use(previous)
+
+	tests := []struct {
+		handler func(web.C, http.ResponseWriter, *http.Request)
+		body    string
+	}{
+		// not backward compatible
+		{s.updateSchema, `{"Deletes": ["channel"]}`},
+		// narrows the column without Force
+		{s.updateSchema, `{"TypeChanges": [{"OutboundName": "channel", "Transformer": "varchar", "ColumnCreationOptions": "(16)"}]}`},
+		// not allowed as an operation
+		{s.updateSchema, `{"Operations": [{"action": "set_state", "name": "", "action_metadata": {"state": "retired"}}]}`},
+		// published events can't become drafts
+		{s.setEventState, `{"State": "draft"}`},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("POST", "/schema/testerino", strings.NewReader(test.body))
+		test.handler(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+		if status := recorder.Code; status != http.StatusBadRequest {
+			t.Errorf("Write %s returned wrong status code: got %v want %v (%s)", test.body, status, http.StatusBadRequest, recorder.Body.String())
+		}
+	}
+}
+
+// lintWarning returns whether the response body has a warning from the rule
+// about the column
+func lintWarning(t *testing.T, body []byte, rule string, column string) bool {
+	var resp writeResponse
+	err := json.Unmarshal(body, &resp)
+	if err != nil {
+		t.Fatalf("Failed to unmarshal write response %s: %v", body, err)
+	}
+	for _, w := range resp.Warnings {
+		if w.Rule == rule && w.Column == column {
+			return true
+		}
+	}
+	return false
+}
+
+func TestWriteLintWarnings(t *testing.T) {
+	config, err := ioutil.TempFile("", "blueprint")
+	if err != nil {
+		t.Fatalf("Failed to create config: %v", err)
+	}
+	defer func() { _ = os.Remove(config.Name()) }()
+	_, err = config.WriteString(`{"blacklist": []}`)
+	if err == nil {
+		err = config.Close()
+	}
+	if err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	s := New("", bpdb.NewMemoryBackend(), config.Name()).(*server)
+
+	recorder := httptest.NewRecorder()
+	body := `{"EventName": "testerino", "Columns": [
+		{"InboundName": "time", "OutboundName": "time", "Transformer": "f@timestamp@unix", "ColumnCreationOptions": " sortkey"},
+		{"InboundName": "channel", "OutboundName": "channelName", "Transformer": "varchar", "ColumnCreationOptions": "(32)"}]}`
+	req, _ := http.NewRequest("PUT", "/schema", strings.NewReader(body))
+	s.createSchema(web.C{}, recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("createSchema returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	if !lintWarning(t, recorder.Body.Bytes(), lint.RuleSnakeCase, "channelName") {
+		t.Errorf("createSchema didn't return the snake case warning: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	body = `{"Additions": [{"InboundName": "os", "OutboundName": "osName", "Transformer": "varchar", "ColumnCreationOptions": "(16)"}]}`
+	req, _ = http.NewRequest("POST", "/schema/testerino", strings.NewReader(body))
+	s.updateSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("updateSchema returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	// only warnings the update introduces are returned
+	if !lintWarning(t, recorder.Body.Bytes(), lint.RuleSnakeCase, "osName") ||
+		lintWarning(t, recorder.Body.Bytes(), lint.RuleSnakeCase, "channelName") {
+		t.Errorf("updateSchema didn't return only the new snake case warning: %s", recorder.Body.String())
+	}
+
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/schema/testerino", strings.NewReader(`{"Deletes": ["osName"]}`))
+	s.updateSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("updateSchema returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	recorder = httptest.NewRecorder()
+	req, _ = http.NewRequest("POST", "/schema/testerino/revert?dry_run=true", strings.NewReader(`{"ToVersion": 1}`))
+	s.revertSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != http.StatusOK {
+		t.Fatalf("revertSchema returned wrong status code: got %v want %v", status, http.StatusOK)
+	}
+	var resp revertResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &resp)
+	if err != nil || len(resp.Operations) != 1 || len(resp.Warnings) != 1 || resp.Warnings[0].Column != "osName" {
+		t.Errorf("Unexpected dry run revert response %s (err %v).", recorder.Body.String(), err)
+	}
+
+	recorder = httptest.NewRecorder()
+	body = `{"Additions": [{"InboundName": "group", "OutboundName": "group", "Transformer": "varchar", "ColumnCreationOptions": "(16)"}]}`
+	req, _ = http.NewRequest("POST", "/schema/testerino", strings.NewReader(body))
+	s.updateSchema(web.C{URLParams: map[string]string{"id": "testerino"}}, recorder, req)
+	if status := recorder.Code; status != statusUnprocessableEntity {
+		t.Fatalf("updateSchema returned wrong status code: got %v want %v", status, statusUnprocessableEntity)
+	}
+	var lintResp lintErrorResponse
+	err = json.Unmarshal(recorder.Body.Bytes(), &lintResp)
+	if err != nil || len(lintResp.Findings) != 1 || lintResp.Findings[0].Rule != lint.RuleReservedWord || lintResp.Findings[0].Column != "group" {
+		t.Errorf("updateSchema didn't return the reserved word finding: %s (err %v)", recorder.Body.String(), err)
+	}
+}
+
+func TestMigrationDDLAcrossRename(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+		},
+	}})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	add := func(name string) {
+		_, err := backend.UpdateSchema(&core.ClientUpdateSchemaRequest{
+			EventName: name,
+			Additions: []core.Column{{InboundName: name, OutboundName: name, Transformer: "bigint"}},
+		})
+		if err != nil {
+			t.Fatalf("Failed to update schema: %v", err)
+		}
+	}
+	add("testerino")
+	err = backend.RenameEvent(&core.ClientRenameEventRequest{EventName: "testerino", NewName: "playback"})
+	if err != nil {
+		t.Fatalf("Failed to rename event: %v", err)
+	}
+	add("playback")
+	s := New("", backend, "").(*server)
+
+	// replaying every migration from scratch must rename the table it created
+	expected := [][]string{
+		{"CREATE TABLE \"testerino\" (\n    \"time\" TIMESTAMP WITHOUT TIME ZONE SORTKEY\n);"},
+		{`ALTER TABLE "testerino" ADD COLUMN "testerino" BIGINT;`},
+		{`ALTER TABLE "testerino" RENAME TO "playback";`},
+		{`ALTER TABLE "playback" ADD COLUMN "playback" BIGINT;`},
+	}
+	for to, statements := range expected {
+		for _, name := range []string{"playback", "testerino"} {
+			recorder := httptest.NewRecorder()
+			req, _ := http.NewRequest("GET", fmt.Sprintf("/migration/%s/ddl?to_version=%d", name, to), nil)
+			s.migrationDDL(web.C{URLParams: map[string]string{"schema": name}}, recorder, req)
+			var ddl []string
+			err = json.Unmarshal(recorder.Body.Bytes(), &ddl)
+			if recorder.Code != http.StatusOK || err != nil || !reflect.DeepEqual(ddl, statements) {
+				t.Errorf("Migration DDL of %s to v%d differs from expected (status %d, err %v):\n%s\nvs\n%v",
+					name, to, recorder.Code, err, recorder.Body.String(), statements)
+			}
+		}
+	}
+}
+
+func TestMigrationLeavesOutNonColumnActions(t *testing.T) {
+	backend := bpdb.NewMemoryBackend()
+	_, err := backend.CreateSchema(&core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "testerino",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+		},
+	}, Draft: true})
+	if err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	err = backend.SetEventState(&core.ClientSetEventStateRequest{EventName: "testerino", State: core.StatePublished})
+	if err != nil {
+		t.Fatalf("Failed to publish event: %v", err)
+	}
+	until := time.Now().Add(time.Hour)
+	_, err = backend.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "testerino",
+		Deprecations: []core.Deprecation{{OutboundName: "channel", Until: &until}},
+		TableOptions: &core.TableOptions{DistStyle: core.DistStyleEven, SortKeys: []string{"time"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update schema: %v", err)
+	}
+	_, err = backend.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "testerino",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}},
+	})
+	if err != nil {
+		t.Fatalf("Failed to update schema: %v", err)
+	}
+	s := New("", backend, "").(*server)
+
+	// publishing, deprecations and table options have nothing for ingesters to migrate
+	for to, expected := range []int{-1, 0, 0, 1, -1} {
+		recorder := httptest.NewRecorder()
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/migration/testerino?to_version=%d", to), nil)
+		s.migration(web.C{URLParams: map[string]string{"schema": "testerino"}}, recorder, req)
+		if expected < 0 {
+			if recorder.Code != http.StatusBadRequest {
+				t.Errorf("Expected no migration to v%d, got status %d: %s", to, recorder.Code, recorder.Body.String())
+			}
+			continue
+		}
+		var ops []scoop_protocol.Operation
+		err = json.Unmarshal(recorder.Body.Bytes(), &ops)
+		if recorder.Code != http.StatusOK || err != nil || len(ops) != expected {
+			t.Errorf("Expected %d operations migrating to v%d, got status %d: %s (err %v)", expected, to, recorder.Code, recorder.Body.String(), err)
+		}
+		for _, op := range ops {
+			if op.Action != scoop_protocol.ADD {
+				t.Errorf("Expected only column operations migrating to v%d, got %v", to, op)
+			}
+		}
+	}
+
+	// the DDL still changes the table options
+	recorder := httptest.NewRecorder()
+	req, _ := http.NewRequest("GET", "/migration/testerino/ddl?to_version=2", nil)
+	s.migrationDDL(web.C{URLParams: map[string]string{"schema": "testerino"}}, recorder, req)
+	if recorder.Code != http.StatusOK || !strings.Contains(recorder.Body.String(), "DISTSTYLE EVEN") {
+		t.Errorf("Expected migration DDL to change the dist style, got status %d: %s", recorder.Code, recorder.Body.String())
+	}
+}
@@ -2,15 +2,57 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
 	"path"
+	"reflect"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/twitchscience/aws_utils/logger"
+	"github.com/twitchscience/blueprint/bpdb"
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
 )
 
+// schemaResponse is a schema along with the audit record, table options,
+// lifecycle state and deprecated columns of the version it is at, and the
+// lint warnings about it
+type schemaResponse struct {
+	scoop_protocol.Config
+	LastChange        *bpdb.Audit                       `json:",omitempty"`
+	TableOptions      *core.TableOptions                `json:",omitempty"`
+	State             string                            `json:",omitempty"`
+	DeprecatedColumns map[string]core.ColumnDeprecation `json:",omitempty"`
+	Warnings          lint.Findings                     `json:",omitempty"`
+}
+
+// writeResponse is the response to creating or updating a schema, with the
+// lint warnings about the schema after the change
+type writeResponse struct {
+	Warnings lint.Findings `json:",omitempty"`
+}
+
+// lintErrorResponse is the response to a write that would make the schema
+// fail lint, with the findings at error severity
+type lintErrorResponse struct {
+	Error    string
+	Findings lint.Findings
+}
+
+// revertResponse is the response to reverting a schema, with the operations
+// applied, or that would be on a dry run, and the lint warnings about the
+// reverted schema
+type revertResponse struct {
+	Operations []scoop_protocol.Operation
+	Warnings   lint.Findings `json:",omitempty"`
+}
+
 // SchemaSuggestion indicates a schema for an event that has occurred a certain number of times.
 type SchemaSuggestion struct {
 	EventName string
@@ -43,6 +85,113 @@ func writeEvent(w http.ResponseWriter, events interface{}) {
 	}
 }
 
+// author returns the name of the user making the request, or empty if
+// authentication is disabled
+func (s *server) author(r *http.Request) string {
+	if s.auth == nil {
+		return ""
+	}
+	user := s.auth.User(r)
+	if user == nil {
+		return ""
+	}
+	return user.Name
+}
+
+// newSchemaResponse returns the response for a schema
+func (s *server) newSchemaResponse(cfg *scoop_protocol.Config) schemaResponse {
+	resp := schemaResponse{Config: *cfg}
+	var tableOpts *core.TableOptions
+	var deprecations map[string]core.ColumnDeprecation
+	details, err := bpdb.DetailsAtVersion(s.bpdbBackend, cfg.EventName, cfg.Version)
+	if err != nil {
+		logger.WithError(err).WithField("schema", cfg.EventName).Warn("Failed to get schema details")
+	} else if details != nil {
+		resp.LastChange = details.Audit
+		resp.State = details.State
+		tableOpts = &details.TableOptions
+		if !reflect.DeepEqual(*tableOpts, core.TableOptions{}) {
+			resp.TableOptions = tableOpts
+		}
+		deprecations = details.DeprecatedColumns
+		if len(deprecations) > 0 {
+			resp.DeprecatedColumns = deprecations
+		}
+	}
+	resp.Warnings = append(lint.Current().Lint(cfg, tableOpts).Warnings(),
+		lint.Current().LintDeprecations(deprecations, time.Now()).Warnings()...)
+	return resp
+}
+
+// warnIfDeprecated sets a Warning header on the response if the event is deprecated
+func warnIfDeprecated(w http.ResponseWriter, eventName string, state string) {
+	if state == core.StateDeprecated {
+		w.Header().Set("Warning", fmt.Sprintf(`299 - "event %s is deprecated"`, eventName))
+	}
+}
+
+// statusUnprocessableEntity is the status of a well-formed request that can't
+// be applied, which net/http doesn't name before go1.7
+const statusUnprocessableEntity = 422
+
+// writeErrorStatus returns the HTTP status code for an error from a bpdb write
+func writeErrorStatus(err error) int {
+	switch err {
+	case bpdb.ErrSchemaExists, bpdb.ErrVersionConflict:
+		return http.StatusConflict
+	case bpdb.ErrIdempotencyKeyReused:
+		return statusUnprocessableEntity
+	}
+	switch err.(type) {
+	case *bpdb.LintError:
+		return statusUnprocessableEntity
+	case *bpdb.ValidationError, *bpdb.RevertKeyColumnError:
+		return http.StatusBadRequest
+	}
+	return http.StatusInternalServerError
+}
+
+// respondWithWriteError responds with an error from a bpdb write and its
+// status. A lint failure is responded to as JSON with the findings.
+func respondWithWriteError(w http.ResponseWriter, err error) {
+	lintErr, ok := err.(*bpdb.LintError)
+	if !ok {
+		http.Error(w, err.Error(), writeErrorStatus(err))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(writeErrorStatus(err))
+	writeEvent(w, lintErrorResponse{Error: err.Error(), Findings: lintErr.Findings})
+}
+
+// versionETag returns the ETag for a schema at the given version
+func versionETag(version int) string {
+	return strconv.Quote(strconv.Itoa(version))
+}
+
+// parseETag returns the schema version from an ETag returned by versionETag
+func parseETag(etag string) (int, error) {
+	unquoted, err := strconv.Unquote(strings.TrimPrefix(etag, "W/"))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(unquoted)
+}
+
+// baseVersion returns the schema version of the ETag in the request's
+// If-Match header, or nil if it has none
+func baseVersion(r *http.Request) (*int, error) {
+	ifMatch := r.Header.Get("If-Match")
+	if ifMatch == "" {
+		return nil, nil
+	}
+	version, err := parseETag(ifMatch)
+	if err != nil {
+		return nil, errors.New("Error, 'If-Match' header must be a schema version ETag.")
+	}
+	return &version, nil
+}
+
 func jsonResponse(h http.Handler) http.Handler {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "application/json")
@@ -102,3 +251,16 @@ func validSuggestion(suggestion, docRoot string) bool {
 	}
 	return false
 }
+
+// diffVersion parses a version argument of a diff. An empty argument is
+// bpdb.CurrentVersion.
+func diffVersion(arg string) (int, error) {
+	if arg == "" {
+		return bpdb.CurrentVersion, nil
+	}
+	version, err := strconv.Atoi(arg)
+	if err != nil || version < 0 {
+		return 0, fmt.Errorf("invalid version %q", arg)
+	}
+	return version, nil
+}
@@ -1,36 +1,155 @@
 package bpdb
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"regexp"
+	"sort"
 	"strings"
+	"time"
 
+	"github.com/twitchscience/aws_utils/logger"
 	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/blueprint/redshift"
+	"github.com/twitchscience/blueprint/transformers"
 	"github.com/twitchscience/scoop_protocol/scoop_protocol"
-	"github.com/twitchscience/scoop_protocol/transformer"
 )
 
 var (
 	maxColumns = 300
-	keyNames   = []string{"distkey", "sortkey"}
+
+	// ErrSchemaExists is returned when creating a schema for an event that already has one.
+	ErrSchemaExists = errors.New("schema already exists")
+
+	// ErrVersionConflict is returned when a schema update was made against a
+	// version that is no longer current.
+	ErrVersionConflict = errors.New("schema was changed by another request")
+
+	// ErrIdempotencyKeyReused is returned when an idempotency key is used for a
+	// different request than the one it was first used for.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was already used for another request")
 )
 
+// ValidationError is returned when a write is rejected because the change it
+// requests isn't valid for the event, e.g. it isn't compatible with the
+// event's compatibility mode or narrows a column without Force, rather than
+// because it couldn't be checked or stored
+type ValidationError struct {
+	Err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.Err.Error()
+}
+
+// LintError is returned when a write would make the schema fail lint rules
+// at error severity, which are its Findings
+type LintError struct {
+	Findings lint.Findings
+}
+
+func (e *LintError) Error() string {
+	return fmt.Sprintf("schema fails lint: %v", e.Findings)
+}
+
+// invalidf returns a ValidationError formatted like fmt.Errorf
+func invalidf(format string, args ...interface{}) error {
+	return &ValidationError{Err: fmt.Errorf(format, args...)}
+}
+
+// invalidRequest prefixes an error validating the request, keeping it a
+// ValidationError, or keeping it as is if it is a RevertKeyColumnError or
+// LintError
+func invalidRequest(request string, err error) error {
+	switch err.(type) {
+	case *ValidationError:
+		return invalidf("Invalid %s request: %v", request, err)
+	case *RevertKeyColumnError, *LintError:
+		return err
+	}
+	return fmt.Errorf("Invalid %s request: %v", request, err)
+}
+
+// idempotency identifies a write by its idempotency key and a hash of its
+// request, so that a retry isn't applied twice and a key reused for another
+// request is rejected
+type idempotency struct {
+	key         string
+	requestHash string
+}
+
+// newIdempotency hashes the request to eventName made with the idempotency
+// key, before it is validated and resolved. The author isn't hashed.
+func newIdempotency(key string, eventName string, req interface{}) idempotency {
+	if key == "" {
+		return idempotency{}
+	}
+	// requests only hold strings, numbers and maps of them, so always marshal
+	b, _ := json.Marshal(req)
+	h := sha256.Sum256([]byte(fmt.Sprintf("%T\n%s\n%s", req, eventName, b)))
+	return idempotency{key: key, requestHash: hex.EncodeToString(h[:])}
+}
+
+// reused reports whether the key, stored for a write to usedFor with
+// requestHash, is used by a different request to eventName. Keys stored
+// before requests were hashed have no hash, so only their event is compared.
+func (i idempotency) reused(usedFor string, requestHash string, eventName string) bool {
+	return usedFor != eventName || (requestHash != "" && requestHash != i.requestHash)
+}
+
 // Bpdb is the interface of the blueprint db backend that stores schema state
 type Bpdb interface {
 	AllSchemas() ([]scoop_protocol.Config, error)
+	EventSchemas(states ...string) ([]EventSchema, error)
+	SchemasAsOf(sequence int64) ([]scoop_protocol.Config, error)
+	SequenceAt(t time.Time) (int64, error)
 	Schema(name string) (*scoop_protocol.Config, error)
-	UpdateSchema(*core.ClientUpdateSchemaRequest) error
-	CreateSchema(*scoop_protocol.Config) error
+	UpdateSchema(*core.ClientUpdateSchemaRequest) (lint.Findings, error)
+	CreateSchema(*core.ClientCreateSchemaRequest) (lint.Findings, error)
 	Migration(table string, to int) ([]*scoop_protocol.Operation, error)
+	Versions(name string) ([]SchemaVersion, error)
+	SchemaAtVersion(name string, version int) (*scoop_protocol.Config, error)
+	RevertSchema(*core.ClientRevertSchemaRequest) ([]scoop_protocol.Operation, lint.Findings, error)
+	RenameEvent(*core.ClientRenameEventRequest) error
+	SetEventState(*core.ClientSetEventStateRequest) error
 }
 
-func validateType(t string) error {
-	for _, validType := range transformer.ValidTransforms {
-		if validType == t {
-			return nil
-		}
+// replicatedBpdb is implemented by backends that may serve reads from a
+// replica lagging behind writes
+type replicatedBpdb interface {
+	primary() Bpdb
+}
+
+// Primary returns a view of the backend that reads from the primary, for
+// reads that must see the latest writes, e.g. a read following a write in the
+// same request or one a write is validated against. Other backends are
+// returned as they are.
+func Primary(b Bpdb) Bpdb {
+	if r, ok := b.(replicatedBpdb); ok {
+		return r.primary()
+	}
+	return b
+}
+
+// validateType validates that the transformer is in the registry and can be
+// used for new columns, and takes the arguments given in the options
+func validateType(t string, options string) error {
+	tr, ok := transformers.Current().Get(t)
+	if !ok {
+		return fmt.Errorf("type not found")
+	}
+	if tr.Deprecated {
+		return fmt.Errorf("type %s is deprecated", t)
+	}
+	opts, err := core.ParseColumnOptions(options)
+	if err != nil {
+		return fmt.Errorf("column options can't be parsed: %v", err)
 	}
-	return fmt.Errorf("type not found")
+	return tr.ValidateArguments(opts)
 }
 
 func validateIdentifier(name string) error {
@@ -44,115 +163,507 @@ func validateIdentifier(name string) error {
 	return nil
 }
 
+// validateIsNotKey validates that an existing column isn't a key. Its options
+// aren't parsed, since columns created before they were validated may have
+// options that no longer parse.
 func validateIsNotKey(options string) error {
-	for _, keyName := range keyNames {
-		if strings.Contains(options, keyName) {
-			return fmt.Errorf("this column is %s", keyName)
-		}
+	distKey, sortKey := core.ColumnKeys(options)
+	if distKey {
+		return fmt.Errorf("this column is distkey")
+	}
+	if sortKey {
+		return fmt.Errorf("this column is sortkey")
+	}
+	return nil
+}
+
+func validateOptions(options string) error {
+	_, err := core.ParseColumnOptions(options)
+	return err
+}
+
+// validateAddedOptions validates the options of a column added to an existing
+// table, which Redshift can't add as not null without a default.
+func validateAddedOptions(options string) error {
+	opts, err := core.ParseColumnOptions(options)
+	if err != nil {
+		return err
+	}
+	if opts.NotNull {
+		return fmt.Errorf("a column added to an existing table must be nullable")
+	}
+	return nil
+}
+
+// validateTypeChange validates changing the column to the transformer and
+// options. Unless forced, only widenings that keep every existing value are
+// allowed: a longer varchar, or an int to a bigint.
+func validateTypeChange(col scoop_protocol.ColumnDefinition, transformer string, options string, force bool) error {
+	oldOpts, err := core.ParseColumnOptions(col.ColumnCreationOptions)
+	if err != nil {
+		return fmt.Errorf("current column options can't be parsed: %v", err)
+	}
+	newOpts, err := core.ParseColumnOptions(options)
+	if err != nil {
+		return fmt.Errorf("column options invalid: %v", err)
+	}
+	if oldOpts.DistKey != newOpts.DistKey || oldOpts.SortKey != newOpts.SortKey {
+		return fmt.Errorf("whether the column is a distkey or sortkey can't be changed")
+	}
+	if newOpts.NotNull && !oldOpts.NotNull {
+		return fmt.Errorf("a nullable column can't be made not null")
+	}
+	widened := oldOpts
+	widened.Length = newOpts.Length
+	unchanged := col.Transformer == transformer && oldOpts == newOpts
+	varcharGrowth := col.Transformer == "varchar" && transformer == "varchar" && widened == newOpts && newOpts.Length > oldOpts.Length
+	// other changes replace the column, which Redshift can't do for these
+	if (oldOpts.DistKey || oldOpts.SortKey || oldOpts.NotNull) && !unchanged && !varcharGrowth {
+		return fmt.Errorf("the type of a distkey, sortkey or not null column can only be changed by growing its varchar length")
+	}
+	if force {
+		return nil
+	}
+	switch {
+	case unchanged, varcharGrowth:
+		return nil
+	case col.Transformer == "int" && transformer == "bigint" && oldOpts == newOpts:
+		return nil
+	}
+	return fmt.Errorf("%s%s to %s%s is not a safe widening, use Force to change it anyway",
+		col.Transformer, strings.TrimSpace(col.ColumnCreationOptions), transformer, strings.TrimSpace(options))
+}
+
+// validateRemapType validates populating the column with a different
+// transformer, which must output the same SQL type so the column is untouched
+func validateRemapType(col scoop_protocol.ColumnDefinition, transformer string) error {
+	oldType, err := redshift.ColumnType(col.Transformer, col.ColumnCreationOptions)
+	if err != nil {
+		return err
+	}
+	newType, err := redshift.ColumnType(transformer, col.ColumnCreationOptions)
+	if err != nil {
+		return err
+	}
+	if oldType != newType {
+		return fmt.Errorf("transformer %s outputs %s, but the column is %s", transformer, newType, oldType)
 	}
 	return nil
 }
 
-func preValidateSchema(cfg *scoop_protocol.Config) error {
+func preValidateSchema(cfg *scoop_protocol.Config, tableOpts *core.TableOptions) (lint.Findings, error) {
 	err := validateIdentifier(cfg.EventName)
 	if err != nil {
-		return fmt.Errorf("event name invalid: %v", err)
+		return nil, invalidf("event name invalid: %v", err)
 	}
 	for _, col := range cfg.Columns {
 		err = validateIdentifier(col.OutboundName)
 		if err != nil {
-			return fmt.Errorf("column outbound name invalid: %v", err)
+			return nil, invalidf("column outbound name invalid: %v", err)
 		}
-		err := validateType(col.Transformer)
+		err = validateOptions(col.ColumnCreationOptions)
 		if err != nil {
-			return fmt.Errorf("column transformer invalid: %v", err)
+			return nil, invalidf("column %s options invalid: %v", col.OutboundName, err)
+		}
+		err = validateType(col.Transformer, col.ColumnCreationOptions)
+		if err != nil {
+			return nil, invalidf("column transformer invalid: %v", err)
 		}
 	}
+	if len(cfg.Columns) == 0 {
+		return nil, invalidf("schema must have at least one column")
+	}
 	if len(cfg.Columns) >= maxColumns {
-		return fmt.Errorf("too many columns, max is %d, given %d", maxColumns, len(cfg.Columns))
+		return nil, invalidf("too many columns, max is %d, given %d", maxColumns, len(cfg.Columns))
+	}
+	if tableOpts == nil {
+		tableOpts = &core.TableOptions{}
+	}
+	err = validateTableOptions(cfg, *tableOpts)
+	if err != nil {
+		return nil, invalidf("table options invalid: %v", err)
+	}
+	return lintSchema(cfg, tableOpts, nil)
+}
+
+// lintSchema lints the schema as it would be after a change, returning the
+// warnings and the errors that weren't already found `before`
+func lintSchema(cfg *scoop_protocol.Config, tableOpts *core.TableOptions, before lint.Findings) (lint.Findings, error) {
+	return reportFindings(cfg.EventName, lint.Current().Lint(cfg, tableOpts).Since(before))
+}
+
+// reportFindings logs the warnings found in the schema of the event, and
+// returns them, or a LintError if there are any errors
+func reportFindings(eventName string, findings lint.Findings) (lint.Findings, error) {
+	warnings := findings.Warnings()
+	for _, f := range warnings {
+		logger.WithField("event_name", eventName).
+			WithField("rule", f.Rule).
+			Warn(f.String())
+	}
+	if errs := findings.Errors(); len(errs) > 0 {
+		return nil, &LintError{Findings: errs}
+	}
+	return warnings, nil
+}
+
+// resolveCreateOptions sets the ColumnCreationOptions of each column given
+// structured options in the request
+func resolveCreateOptions(req *core.ClientCreateSchemaRequest) error {
+	for name := range req.ColumnOptions {
+		found := false
+		for _, col := range req.Columns {
+			found = found || col.OutboundName == name
+		}
+		if !found {
+			return invalidf("options given for unknown column %s", name)
+		}
+	}
+	for i, col := range req.Columns {
+		var options *core.ColumnOptions
+		if opts, ok := req.ColumnOptions[col.OutboundName]; ok {
+			options = &opts
+		}
+		resolved, err := core.ResolveColumnOptions(col.ColumnCreationOptions, options)
+		if err != nil {
+			return invalidf("column %s options invalid: %v", col.OutboundName, err)
+		}
+		req.Columns[i].ColumnCreationOptions = resolved
+	}
+	return nil
+}
+
+// resolveUpdateOptions sets the legacy options string of each added or
+// changed column given structured options in the request
+func resolveUpdateOptions(req *core.ClientUpdateSchemaRequest) error {
+	for i, col := range req.Additions {
+		resolved, err := core.ResolveColumnOptions(col.Length, col.Options)
+		if err != nil {
+			return invalidf("column %s options invalid: %v", col.OutboundName, err)
+		}
+		req.Additions[i].Length = resolved
+	}
+	for i, change := range req.TypeChanges {
+		resolved, err := core.ResolveColumnOptions(change.Length, change.Options)
+		if err != nil {
+			return invalidf("column %s options invalid: %v", change.OutboundName, err)
+		}
+		req.TypeChanges[i].Length = resolved
 	}
 	return nil
 }
 
-// schemaCreateRequestToOps converts a schema update request into a list of add operations
-func schemaCreateRequestToOps(req *scoop_protocol.Config) []scoop_protocol.Operation {
-	ops := make([]scoop_protocol.Operation, 0, len(req.Columns))
+// schemaCreateRequestToOps converts a schema create request into a list of add
+// operations, followed by setting the table options if given, and making the
+// event a draft if requested
+func schemaCreateRequestToOps(req *core.ClientCreateSchemaRequest) []scoop_protocol.Operation {
+	ops := make([]scoop_protocol.Operation, 0, len(req.Columns)+2)
 	for _, col := range req.Columns {
 		ops = append(ops, scoop_protocol.NewAddOperation(col.OutboundName, col.InboundName, col.Transformer, col.ColumnCreationOptions))
 	}
+	if req.TableOptions != nil {
+		ops = append(ops, core.NewTableOptionsOperation(*req.TableOptions))
+	}
+	if req.Draft {
+		ops = append(ops, core.NewSetStateOperation(core.StateDraft))
+	}
 	return ops
 }
 
-// schemaUpdateRequestToOps converts a schema update request into a list of operations
+// schemaUpdateRequestToOps converts a schema update request into a list of
+// operations, which are the request's own if it gives them in order
 func schemaUpdateRequestToOps(req *core.ClientUpdateSchemaRequest) []scoop_protocol.Operation {
-	ops := make([]scoop_protocol.Operation, 0, len(req.Additions)+len(req.Deletes)+len(req.Renames))
+	if len(req.Operations) > 0 {
+		ops := make([]scoop_protocol.Operation, 0, len(req.Operations))
+		for _, op := range req.Operations {
+			op.ActionMetadata = copyMetadata(op.ActionMetadata)
+			ops = append(ops, op)
+		}
+		return ops
+	}
+	ops := make([]scoop_protocol.Operation, 0, len(req.Deprecations)+len(req.Additions)+len(req.Deletes)+len(req.Renames)+len(req.TypeChanges)+len(req.Remaps))
+	for _, d := range req.Deprecations {
+		ops = append(ops, core.NewDeprecateColumnOperation(d.OutboundName, d.Until))
+	}
 	for _, colName := range req.Deletes {
 		ops = append(ops, scoop_protocol.NewDeleteOperation(colName))
 	}
+	for _, change := range req.TypeChanges {
+		ops = append(ops, core.NewChangeTypeOperation(change.OutboundName, change.Transformer, change.Length))
+	}
+	for _, remap := range req.Remaps {
+		ops = append(ops, core.NewRemapOperation(remap.OutboundName, remap.InboundName, remap.Transformer))
+	}
 	for _, col := range req.Additions {
 		ops = append(ops, scoop_protocol.NewAddOperation(col.OutboundName, col.InboundName, col.Transformer, col.Length))
 	}
 	for oldName, newName := range req.Renames {
 		ops = append(ops, scoop_protocol.NewRenameOperation(oldName, newName))
 	}
+	if req.TableOptions != nil {
+		ops = append(ops, core.NewTableOptionsOperation(*req.TableOptions))
+	}
 	return ops
 }
 
-func preValidateUpdate(req *core.ClientUpdateSchemaRequest, bpdb Bpdb) error {
-	schema, err := bpdb.Schema(req.EventName)
-	if err != nil {
-		return fmt.Errorf("error getting schema to validate schema update: %v", err)
-	}
-
-	// Validate schema "delete"s
-	for _, columnName := range req.Deletes {
+// preValidateOperation validates a single operation against the schema and
+// table options, and applies it to them
+func preValidateOperation(schema *scoop_protocol.Config, tableOpts *core.TableOptions, op scoop_protocol.Operation, force bool) error {
+	switch op.Action {
+	case scoop_protocol.DELETE:
 		for _, existingCol := range schema.Columns {
-			if existingCol.OutboundName == columnName {
-				err = validateIsNotKey(existingCol.ColumnCreationOptions)
+			if existingCol.OutboundName == op.Name {
+				err := validateIsNotKey(existingCol.ColumnCreationOptions)
 				if err != nil {
 					return fmt.Errorf("column is a key and cannot be dropped: %v", err)
 				}
-				break // move on to next deleted column
+				break
 			}
 		}
-	}
-
-	// Validate schema "add"s
-	for _, col := range req.Additions {
-		err = validateIdentifier(col.OutboundName)
+	case scoop_protocol.ADD:
+		err := validateIdentifier(op.Name)
 		if err != nil {
 			return fmt.Errorf("column outbound name invalid: %v", err)
 		}
-		err = validateType(col.Transformer)
+		err = validateAddedOptions(op.ActionMetadata["column_options"])
+		if err != nil {
+			return fmt.Errorf("column %s options invalid: %v", op.Name, err)
+		}
+		err = validateType(op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
+		if err != nil {
+			return fmt.Errorf("column transformer invalid: %v", err)
+		}
+	case scoop_protocol.RENAME:
+		err := validateIdentifier(op.ActionMetadata["new_outbound"])
+		if err != nil {
+			return fmt.Errorf("New name for column is invalid: %v", err)
+		}
+		for _, existingCol := range schema.Columns {
+			if existingCol.OutboundName == op.ActionMetadata["new_outbound"] {
+				return fmt.Errorf("column %s already exists, cannot rename to it", existingCol.OutboundName)
+			}
+		}
+	case core.ChangeType:
+		err := validateType(op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
 		if err != nil {
 			return fmt.Errorf("column transformer invalid: %v", err)
 		}
+		for _, existingCol := range schema.Columns {
+			if existingCol.OutboundName == op.Name {
+				err = validateTypeChange(existingCol, op.ActionMetadata["column_type"], op.ActionMetadata["column_options"], force)
+				if err != nil {
+					return fmt.Errorf("cannot change type of column %s: %v", op.Name, err)
+				}
+				break
+			}
+		}
+	case core.Remap:
+		if op.ActionMetadata["inbound"] == "" {
+			return fmt.Errorf("column %s must be remapped to an inbound name", op.Name)
+		}
+		newType := op.ActionMetadata["column_type"]
+		if newType == "" {
+			break
+		}
+		for _, existingCol := range schema.Columns {
+			if existingCol.OutboundName == op.Name {
+				err := validateType(newType, existingCol.ColumnCreationOptions)
+				if err != nil {
+					return fmt.Errorf("column transformer invalid: %v", err)
+				}
+				err = validateRemapType(existingCol, newType)
+				if err != nil {
+					return fmt.Errorf("cannot remap column %s: %v", op.Name, err)
+				}
+				break
+			}
+		}
+	case core.DeprecateColumn:
+		until, err := core.OperationDeprecationUntil(op)
+		if err != nil {
+			return fmt.Errorf("column %s deprecation invalid: %v", op.Name, err)
+		}
+		for _, existingCol := range schema.Columns {
+			if existingCol.OutboundName == op.Name && until != nil {
+				err = validateIsNotKey(existingCol.ColumnCreationOptions)
+				if err != nil {
+					return fmt.Errorf("column is a key and cannot be deprecated: %v", err)
+				}
+				break
+			}
+		}
+	case core.RenameEvent:
+		err := validateIdentifier(op.ActionMetadata["new_name"])
+		if err != nil {
+			return fmt.Errorf("new event name invalid: %v", err)
+		}
+	case core.SetTableOptions:
+		newOpts, err := core.OperationTableOptions(op)
+		if err != nil {
+			return fmt.Errorf("table options invalid: %v", err)
+		}
+		err = validateTableOptionsChange(*tableOpts, newOpts)
+		if err != nil {
+			return fmt.Errorf("table options invalid: %v", err)
+		}
+	}
+	err := ApplyOperation(schema, op)
+	if err != nil {
+		return err
+	}
+	return applyTableOperation(tableOpts, op)
+}
+
+// preValidateOperations validates each operation against the schema and
+// table options as they are after the operations before it, migrating them as
+// it goes. Errors name the index of the failing operation. Type changes must
+// be safe widenings unless `force` is set, and the result must not fail any
+// lint rule the schema didn't already fail. The new lint warnings are returned.
+func preValidateOperations(schema *scoop_protocol.Config, tableOpts *core.TableOptions, ops []scoop_protocol.Operation, force bool) (lint.Findings, error) {
+	if len(ops) == 0 {
+		return nil, invalidf("no changes given")
+	}
+	before := lint.Current().Lint(schema, tableOpts)
+	for i, op := range ops {
+		err := preValidateOperation(schema, tableOpts, op, force)
+		if err != nil {
+			return nil, invalidf("operation %d (%s %s): %v", i, op.Action, op.Name, err)
+		}
+	}
+
+	if len(schema.Columns) > maxColumns {
+		return nil, invalidf("too many columns, max is %d, given %d operations, which would result in %d total", maxColumns, len(ops), len(schema.Columns))
+	}
+	err := validateTableOptions(schema, *tableOpts)
+	if err != nil {
+		return nil, invalidf("table options invalid: %v", err)
+	}
+	return lintSchema(schema, tableOpts, before)
+}
+
+// rawUpdateActions are the actions allowed in the Operations of an update.
+// Others are validated and built from their own fields of the request, or
+// have their own endpoint.
+var rawUpdateActions = map[scoop_protocol.Action]bool{
+	scoop_protocol.ADD:    true,
+	scoop_protocol.DELETE: true,
+	scoop_protocol.RENAME: true,
+	core.ChangeType:       true,
+	core.Remap:            true,
+}
+
+// migrationActions are the actions Migration returns: changes to columns, and
+// renames of the event so ingesters can follow them. Changes to the event's
+// state, table options and column deprecations are left out, since ingesters
+// don't know them and don't need them to migrate the table.
+var migrationActions = map[scoop_protocol.Action]bool{
+	scoop_protocol.ADD:    true,
+	scoop_protocol.DELETE: true,
+	scoop_protocol.RENAME: true,
+	core.ChangeType:       true,
+	core.Remap:            true,
+	core.RenameEvent:      true,
+}
+
+// migrationOperations returns the operations of a migration with an action
+// in migrationActions
+func migrationOperations(ops []*scoop_protocol.Operation) []*scoop_protocol.Operation {
+	filtered := make([]*scoop_protocol.Operation, 0, len(ops))
+	for _, op := range ops {
+		if migrationActions[op.Action] {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// rawUpdateActionNames returns the sorted actions allowed in the Operations of an update
+func rawUpdateActionNames() []string {
+	names := make([]string, 0, len(rawUpdateActions))
+	for action := range rawUpdateActions {
+		names = append(names, string(action))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// preValidateUpdate resolves the structured column options of the update and
+// validates it against the current schema and the event's compatibility mode,
+// returning the version of the schema it was validated against. Drafts can be
+// changed freely: type changes and deletes of deprecated columns are forced
+// and compatibility isn't checked. The lint warnings about the schema after
+// the update are returned as well.
+func preValidateUpdate(req *core.ClientUpdateSchemaRequest, bpdb Bpdb) (int, lint.Findings, error) {
+	err := resolveUpdateOptions(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	schema, err := bpdb.Schema(req.EventName)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error getting schema to validate schema update: %v", err)
+	}
+	if req.BaseVersion != nil && *req.BaseVersion != schema.Version {
+		return 0, nil, ErrVersionConflict
+	}
+	state, err := preValidateState(bpdb, req.EventName)
+	if err != nil {
+		return 0, nil, err
 	}
 
-	// Validate schema "rename"s
+	if len(req.Operations) > 0 && (len(req.Additions) > 0 || len(req.Deletes) > 0 || len(req.Renames) > 0 ||
+		len(req.TypeChanges) > 0 || len(req.Remaps) > 0 || req.TableOptions != nil || len(req.Deprecations) > 0) {
+		return 0, nil, invalidf("Operations can't be combined with other changes in an update")
+	}
+
+	// Renames are unordered, so a column can only be part of one
 	nameSet := make(map[string]bool)
 	for oldName, newName := range req.Renames {
-		err = validateIdentifier(newName)
-		if err != nil {
-			return fmt.Errorf("New name for column is invalid: %v", err)
-		}
 		for _, name := range []string{oldName, newName} {
 			_, found := nameSet[name]
 			if found {
-				return fmt.Errorf("Cannot rename from or to a column that was already renamed from or to. Offending name: %v", name)
+				return 0, nil, invalidf("Cannot rename from or to a column that was already renamed from or to. Offending name: %v", name)
 			}
 			nameSet[name] = true
 		}
 	}
 
+	version := schema.Version
+	tableOpts, err := TableOptionsAtVersion(bpdb, req.EventName, version)
+	if err != nil || tableOpts == nil {
+		return 0, nil, fmt.Errorf("error getting table options to validate schema update: %v", err)
+	}
 	ops := schemaUpdateRequestToOps(req)
-	err = ApplyOperations(schema, ops)
+	for i, op := range req.Operations {
+		if !rawUpdateActions[op.Action] {
+			return 0, nil, invalidf("operation %d (%s %s): only %v can be given as operations", i, op.Action, op.Name, rawUpdateActionNames())
+		}
+	}
+	now := time.Now()
+	for _, d := range req.Deprecations {
+		if d.Until != nil && !d.Until.After(now) {
+			return 0, nil, invalidf("Deprecation of %s must end in the future, not at %s", d.OutboundName, d.Until.Format(time.RFC3339))
+		}
+	}
+	draft := state == core.StateDraft
+	warnings, err := preValidateOperations(schema, tableOpts, ops, req.Force || draft)
 	if err != nil {
-		return err
+		return 0, nil, err
 	}
-
-	if len(schema.Columns) > maxColumns {
-		return fmt.Errorf("too many columns, max is %d, given %d adds and %d deletes, which would result in %d total", maxColumns, len(req.Additions), len(req.Deletes), len(schema.Columns))
+	deprecations, err := DeprecatedColumnsAtVersion(bpdb, req.EventName, version)
+	if err != nil {
+		return 0, nil, fmt.Errorf("error getting deprecated columns to validate schema update: %v", err)
 	}
-	return nil
+	deprecationWarnings, err := preValidateDeprecations(req.EventName, deprecations, ops, req.Force || draft, now)
+	if err != nil {
+		return 0, nil, err
+	}
+	warnings = append(warnings, deprecationWarnings...)
+	if draft {
+		return version, warnings, nil
+	}
+	err = preValidateCompatibility(bpdb, req.EventName, ops)
+	if err != nil {
+		return 0, nil, err
+	}
+	return version, warnings, nil
 }
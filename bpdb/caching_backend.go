@@ -0,0 +1,299 @@
+package bpdb
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	"github.com/twitchscience/aws_utils/logger"
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// schemaChangeChannel is the postgres notification channel written to with
+// the event name whenever operations are inserted
+const schemaChangeChannel = "bpdb_operation"
+
+// cachingBackend keeps the materialized schemas of the wrapped backend in
+// memory. Entries are invalidated per event, and the whole cache is bypassed
+// while invalidations can't be received. The whole cache is loaded from the
+// wrapped backend, which may read from a replica, and the events it lags
+// behind on and invalidated events are reloaded from the primary.
+type cachingBackend struct {
+	Bpdb
+
+	lock      sync.RWMutex
+	listening bool
+	schemas   map[string]EventSchema // nil if everything must be reloaded
+	stale     map[string]bool
+}
+
+// NewCachingBackend wraps a postgres bpdb backend with a schema cache. The
+// cache listens for notifications postgres sends on every write so that
+// writes from any blueprint process are seen without polling.
+func NewCachingBackend(backend Bpdb, dbConnection string) (Bpdb, error) {
+	c := newCachingBackend(backend)
+	listener := pq.NewListener(dbConnection, time.Second, time.Minute, c.listenerEvent)
+	err := listener.Listen(schemaChangeChannel)
+	if err != nil {
+		return nil, fmt.Errorf("Error listening for schema changes: %v", err)
+	}
+	c.setListening(true)
+	go c.listen(listener.Notify)
+	return c, nil
+}
+
+func newCachingBackend(backend Bpdb) *cachingBackend {
+	return &cachingBackend{
+		Bpdb:  backend,
+		stale: make(map[string]bool),
+	}
+}
+
+// listenerEvent stops using the cache while disconnected from postgres, since
+// notifications sent in the meantime are lost.
+func (c *cachingBackend) listenerEvent(event pq.ListenerEventType, err error) {
+	switch event {
+	case pq.ListenerEventDisconnected:
+		logger.WithError(err).Warn("Lost connection for schema change notifications, bypassing schema cache")
+		c.setListening(false)
+	case pq.ListenerEventConnectionAttemptFailed:
+		logger.WithError(err).Warn("Failed to reconnect for schema change notifications")
+	case pq.ListenerEventReconnected:
+		logger.Info("Reconnected for schema change notifications, using schema cache")
+		c.setListening(true)
+	}
+}
+
+// listen invalidates the schema named in each notification
+func (c *cachingBackend) listen(notifications <-chan *pq.Notification) {
+	for n := range notifications {
+		if n == nil {
+			// the connection was re-established and notifications may have been missed
+			c.invalidate("")
+			continue
+		}
+		c.invalidate(n.Extra)
+	}
+}
+
+func (c *cachingBackend) setListening(listening bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.listening = listening
+	c.schemas = nil
+}
+
+// invalidate marks the schema for `event` stale, or every schema if `event` is empty
+func (c *cachingBackend) invalidate(event string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if event == "" {
+		c.schemas = nil
+		return
+	}
+	c.stale[event] = true
+}
+
+// versionedBpdb is implemented by backends that can list the current version
+// of every event without reading their schemas
+type versionedBpdb interface {
+	currentVersions() (map[string]int, error)
+}
+
+// primary bypasses the cache for reads that must see the latest writes
+func (c *cachingBackend) primary() Bpdb {
+	return Primary(c.Bpdb)
+}
+
+// copyConfig deep copies a schema so callers can't mutate the cache
+func copyConfig(cfg scoop_protocol.Config) scoop_protocol.Config {
+	cfg.Columns = append([]scoop_protocol.ColumnDefinition(nil), cfg.Columns...)
+	return cfg
+}
+
+// cached returns the cached schemas, reloading any that are stale. It returns
+// nil if the cache can't be used.
+func (c *cachingBackend) cached() (map[string]EventSchema, error) {
+	c.lock.RLock()
+	if !c.listening {
+		c.lock.RUnlock()
+		return nil, nil
+	}
+	if c.schemas != nil && len(c.stale) == 0 {
+		defer c.lock.RUnlock()
+		return c.schemas, nil
+	}
+	c.lock.RUnlock()
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	// the event may not exist, e.g. after a failed create, so reload everything
+	if c.schemas != nil && c.reloadStale() != nil {
+		c.schemas = nil
+	}
+	if c.schemas == nil {
+		schemas, err := c.Bpdb.EventSchemas()
+		if err != nil {
+			return nil, err
+		}
+		c.schemas = make(map[string]EventSchema, len(schemas))
+		for _, schema := range schemas {
+			c.schemas[schema.EventName] = schema
+		}
+		c.stale = make(map[string]bool)
+		err = c.markLagging()
+		if err == nil {
+			err = c.reloadStale()
+		}
+		if err != nil {
+			c.schemas = nil
+			return nil, err
+		}
+	}
+	return c.schemas, nil
+}
+
+// reloadStale reloads the stale schemas from the primary, since changes are
+// notified by the primary, which a replica may lag behind. The caller must
+// hold the write lock.
+func (c *cachingBackend) reloadStale() error {
+	for event := range c.stale {
+		cfg, err := Primary(c.Bpdb).Schema(event)
+		var state string
+		if err == nil {
+			state, err = StateAtVersion(Primary(c.Bpdb), event, CurrentVersion)
+		}
+		if err != nil {
+			return err
+		}
+		c.schemas[event] = EventSchema{Config: *cfg, State: state}
+		delete(c.stale, event)
+	}
+	return nil
+}
+
+// markLagging marks the loaded schemas that aren't at the current version of
+// the primary stale, and drops the ones the primary doesn't have. The caller
+// must hold the write lock.
+func (c *cachingBackend) markLagging() error {
+	v, ok := Primary(c.Bpdb).(versionedBpdb)
+	if !ok {
+		return nil
+	}
+	versions, err := v.currentVersions()
+	if err != nil {
+		return err
+	}
+	for event := range c.schemas {
+		if _, ok := versions[event]; !ok {
+			delete(c.schemas, event)
+		}
+	}
+	for event, version := range versions {
+		if schema, ok := c.schemas[event]; !ok || schema.Version != version {
+			c.stale[event] = true
+		}
+	}
+	return nil
+}
+
+// AllSchemas returns the current schemas of the published and deprecated
+// events from the cache
+func (c *cachingBackend) AllSchemas() ([]scoop_protocol.Config, error) {
+	schemas, err := c.cached()
+	if err != nil {
+		return nil, err
+	}
+	if schemas == nil {
+		return c.Bpdb.AllSchemas()
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	cfgs := make([]scoop_protocol.Config, 0, len(schemas))
+	for _, schema := range schemas {
+		if inStates(schema.State, LiveStates) {
+			cfgs = append(cfgs, copyConfig(schema.Config))
+		}
+	}
+	return cfgs, nil
+}
+
+// EventSchemas returns the current schemas of the events in one of `states`,
+// or of every event if none are given, from the cache
+func (c *cachingBackend) EventSchemas(states ...string) ([]EventSchema, error) {
+	schemas, err := c.cached()
+	if err != nil {
+		return nil, err
+	}
+	if schemas == nil {
+		return c.Bpdb.EventSchemas(states...)
+	}
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	filtered := make([]EventSchema, 0, len(schemas))
+	for _, schema := range schemas {
+		if inStates(schema.State, states) {
+			schema.Config = copyConfig(schema.Config)
+			filtered = append(filtered, schema)
+		}
+	}
+	return filtered, nil
+}
+
+// Schema returns the current schema for the table `name` from the cache
+func (c *cachingBackend) Schema(name string) (*scoop_protocol.Config, error) {
+	schemas, err := c.cached()
+	if err != nil {
+		return nil, err
+	}
+	if schemas == nil {
+		return c.Bpdb.Schema(name)
+	}
+	c.lock.RLock()
+	schema, ok := schemas[name]
+	c.lock.RUnlock()
+	if !ok {
+		// the name may be an alias of a renamed event
+		return c.Bpdb.Schema(name)
+	}
+	cfg := copyConfig(schema.Config)
+	return &cfg, nil
+}
+
+// CreateSchema creates the schema in the wrapped backend, and invalidates the
+// cached copy without waiting for the notification
+func (c *cachingBackend) CreateSchema(req *core.ClientCreateSchemaRequest) (lint.Findings, error) {
+	defer c.invalidate(req.EventName)
+	return c.Bpdb.CreateSchema(req)
+}
+
+// UpdateSchema updates the schema in the wrapped backend, and invalidates the
+// cached copy without waiting for the notification
+func (c *cachingBackend) UpdateSchema(req *core.ClientUpdateSchemaRequest) (lint.Findings, error) {
+	defer c.invalidate(req.EventName)
+	return c.Bpdb.UpdateSchema(req)
+}
+
+// RevertSchema reverts the schema in the wrapped backend, and invalidates the
+// cached copy without waiting for the notification
+func (c *cachingBackend) RevertSchema(req *core.ClientRevertSchemaRequest) ([]scoop_protocol.Operation, lint.Findings, error) {
+	defer c.invalidate(req.EventName)
+	return c.Bpdb.RevertSchema(req)
+}
+
+// RenameEvent renames the event in the wrapped backend, and invalidates every
+// cached schema without waiting for the notification, so the old name is dropped
+func (c *cachingBackend) RenameEvent(req *core.ClientRenameEventRequest) error {
+	defer c.invalidate("")
+	return c.Bpdb.RenameEvent(req)
+}
+
+// SetEventState changes the state of the event in the wrapped backend, and
+// invalidates the cached copy without waiting for the notification
+func (c *cachingBackend) SetEventState(req *core.ClientSetEventStateRequest) error {
+	defer c.invalidate(req.EventName)
+	return c.Bpdb.SetEventState(req)
+}
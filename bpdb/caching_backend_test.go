@@ -0,0 +1,222 @@
+package bpdb
+
+import (
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func TestCachingBackendInvalidation(t *testing.T) {
+	backend := NewMemoryBackend()
+	_, err := backend.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	c := newCachingBackend(backend)
+	c.setListening(true)
+
+	schema, err := c.Schema("video_play")
+	if err != nil || schema.Version != 0 {
+		t.Fatalf("Expected schema at version 0, got %v (err %v).", schema, err)
+	}
+
+	// a write by another process is only seen once notified
+	_, err = backend.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	schema, err = c.Schema("video_play")
+	if err != nil || schema.Version != 0 {
+		t.Errorf("Expected cached schema at version 0, got %v (err %v).", schema, err)
+	}
+	c.invalidate("video_play")
+	schema, err = c.Schema("video_play")
+	if err != nil || schema.Version != 1 {
+		t.Errorf("Expected schema at version 1 after invalidation, got %v (err %v).", schema, err)
+	}
+
+	// writes through the cache are seen immediately
+	cfg := testCreateRequest()
+	cfg.EventName = "video_pause"
+	_, err = c.CreateSchema(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	schemas, err := c.AllSchemas()
+	if err != nil || len(schemas) != 2 {
+		t.Errorf("Expected two schemas, got %v (err %v).", schemas, err)
+	}
+}
+
+func TestCachingBackendNotListening(t *testing.T) {
+	backend := NewMemoryBackend()
+	c := newCachingBackend(backend)
+	c.setListening(true)
+	schemas, err := c.AllSchemas()
+	if err != nil || len(schemas) != 0 {
+		t.Fatalf("Expected no schemas, got %v (err %v).", schemas, err)
+	}
+
+	c.setListening(false)
+	_, err = backend.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	schemas, err = c.AllSchemas()
+	if err != nil || len(schemas) != 1 {
+		t.Errorf("Expected reads to bypass the cache while not listening, got %v (err %v).", schemas, err)
+	}
+}
+
+func TestCachingBackendCopiesSchemas(t *testing.T) {
+	backend := NewMemoryBackend()
+	_, err := backend.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	c := newCachingBackend(backend)
+	c.setListening(true)
+
+	schema, err := c.Schema("video_play")
+	if err != nil {
+		t.Fatalf("Expected no error getting schema, got %v.", err)
+	}
+	schema.Columns[0].OutboundName = "mutated"
+	schema, err = c.Schema("video_play")
+	if err != nil || schema.Columns[0].OutboundName != "time" {
+		t.Errorf("Expected cached schema to be unaffected by caller mutation, got %v (err %v).", schema, err)
+	}
+}
+
+func TestCachingBackendStates(t *testing.T) {
+	backend := NewMemoryBackend()
+	req := testCreateRequest()
+	req.Draft = true
+	_, err := backend.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating draft, got %v.", err)
+	}
+	c := newCachingBackend(backend)
+	c.setListening(true)
+
+	schemas, err := c.AllSchemas()
+	if err != nil || len(schemas) != 0 {
+		t.Errorf("Expected drafts to be hidden from ingesters, got %v (err %v).", schemas, err)
+	}
+	err = c.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StatePublished})
+	if err != nil {
+		t.Fatalf("Expected no error publishing, got %v.", err)
+	}
+	schemas, err = c.AllSchemas()
+	if err != nil || len(schemas) != 1 {
+		t.Errorf("Expected published schema once invalidated, got %v (err %v).", schemas, err)
+	}
+	drafts, err := c.EventSchemas(core.StateDraft)
+	if err != nil || len(drafts) != 0 {
+		t.Errorf("Expected no drafts, got %v (err %v).", drafts, err)
+	}
+}
+
+// laggingReplica reads from a replica, which only has the writes made to it
+// directly, and validates writes against its primary
+type laggingReplica struct {
+	Bpdb
+	primaryBackend Bpdb
+}
+
+func (r *laggingReplica) primary() Bpdb {
+	return r.primaryBackend
+}
+
+// newLaggingReplica returns a backend whose replica and primary both have
+// video_play at version 0 and video_pause at version 0, with a column only
+// the replica's copy of video_pause has, and whose primary has video_play
+// at version 1 too
+func newLaggingReplica(t *testing.T) *laggingReplica {
+	r := &laggingReplica{Bpdb: NewMemoryBackend(), primaryBackend: NewMemoryBackend()}
+	for _, b := range []Bpdb{r.Bpdb, r.primaryBackend} {
+		_, err := b.CreateSchema(testCreateRequest())
+		if err != nil {
+			t.Fatalf("Expected no error creating schema, got %v.", err)
+		}
+	}
+	pause := testCreateRequest()
+	pause.EventName = "video_pause"
+	_, err := r.primaryBackend.CreateSchema(pause)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	pause.Columns = append(pause.Columns, scoop_protocol.ColumnDefinition{
+		InboundName: "replica", OutboundName: "replica", Transformer: "bigint"})
+	_, err = r.Bpdb.CreateSchema(pause)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = r.primaryBackend.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	return r
+}
+
+func TestCachingBackendPrimary(t *testing.T) {
+	backend := NewMemoryBackend()
+	if Primary(backend) != backend {
+		t.Error("Expected backends without a replica to be their own primary.")
+	}
+
+	r := newLaggingReplica(t)
+	schema, err := r.Schema("video_play")
+	if err != nil || schema.Version != 0 {
+		t.Errorf("Expected the replica to lag behind, got %v (err %v).", schema, err)
+	}
+	c := newCachingBackend(r)
+	c.setListening(true)
+
+	// the cache is loaded from the replica, but catches up with the primary
+	schema, err = c.Schema("video_pause")
+	if err != nil || len(schema.Columns) != 4 {
+		t.Errorf("Expected the replica's copy of an event that doesn't lag, got %v (err %v).", schema, err)
+	}
+	schema, err = c.Schema("video_play")
+	if err != nil || schema.Version != 1 {
+		t.Errorf("Expected the primary's copy of an event the replica lags on, got %v (err %v).", schema, err)
+	}
+
+	// notified changes are read from the primary, as are reads that must see
+	// the latest writes
+	_, err = r.primaryBackend.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"channel"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	schema, err = Primary(c).Schema("video_play")
+	if err != nil || schema.Version != 2 {
+		t.Errorf("Expected the latest schema from the primary, got %v (err %v).", schema, err)
+	}
+	c.invalidate("video_play")
+	schema, err = c.Schema("video_play")
+	if err != nil || schema.Version != 2 {
+		t.Errorf("Expected the invalidated schema from the primary, got %v (err %v).", schema, err)
+	}
+}
+
+func TestVersionsFromPrimary(t *testing.T) {
+	r := newLaggingReplica(t)
+	state, err := StateAtVersion(r, "video_play", 1)
+	if err != nil || state != core.StatePublished {
+		t.Errorf("Expected the state of a version only the primary has, got %s (err %v).", state, err)
+	}
+	details, err := DetailsAtVersion(r, "video_play", 1)
+	if err != nil || details == nil {
+		t.Errorf("Expected the details of a version only the primary has, got %+v (err %v).", details, err)
+	}
+	diff, err := DiffVersions(r, "video_play", 0, 1)
+	if err != nil || diff == nil || len(diff.Removed) != 1 {
+		t.Errorf("Expected the diff to a version only the primary has, got %+v (err %v).", diff, err)
+	}
+	details, err = DetailsAtVersion(r, "video_play", 2)
+	if err != nil || details != nil {
+		t.Errorf("Expected no details of a version neither has, got %+v (err %v).", details, err)
+	}
+}
@@ -0,0 +1,131 @@
+package bpdb
+
+import (
+	"fmt"
+
+	"github.com/twitchscience/blueprint/compatibility"
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/redshift"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// columnTypeName returns the type of a column to compare across its history:
+// its Redshift type, or its transformer and options if that can't be found
+func columnTypeName(transformer string, options string) string {
+	redshiftType, err := redshift.ColumnType(transformer, options)
+	if err != nil {
+		return transformer + options
+	}
+	return redshiftType
+}
+
+// retireColumn records the type of the column named `name`, if the schema has
+// one, as its name is deleted or renamed away
+func retireColumn(schema *scoop_protocol.Config, retired map[string]string, name string) {
+	for _, col := range schema.Columns {
+		if col.OutboundName == name {
+			retired[name] = columnTypeName(col.Transformer, col.ColumnCreationOptions)
+			return
+		}
+	}
+}
+
+// checkOperationCompatibility validates a single operation against the
+// schema before it under `mode`. `retired` holds the last type of every
+// outbound name that has been deleted or renamed away.
+func checkOperationCompatibility(mode compatibility.Mode, schema *scoop_protocol.Config, retired map[string]string, op scoop_protocol.Operation) error {
+	if mode == compatibility.AdditiveOnly && op.Action != scoop_protocol.ADD && op.Action != core.SetState && op.Action != core.DeprecateColumn {
+		return fmt.Errorf("only columns can be added, given %s of %s", op.Action, op.Name)
+	}
+	switch op.Action {
+	case scoop_protocol.ADD:
+		newType := columnTypeName(op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
+		if oldType, ok := retired[op.Name]; ok && oldType != newType {
+			return fmt.Errorf("column %s was previously %s, so it can't be added as %s", op.Name, oldType, newType)
+		}
+	case scoop_protocol.DELETE:
+		return fmt.Errorf("column %s can't be deleted", op.Name)
+	case scoop_protocol.RENAME:
+		return fmt.Errorf("column %s can't be renamed", op.Name)
+	case core.ChangeType:
+		if mode == compatibility.Full {
+			return fmt.Errorf("type of column %s can't be changed", op.Name)
+		}
+		for _, col := range schema.Columns {
+			if col.OutboundName == op.Name {
+				err := validateTypeChange(col, op.ActionMetadata["column_type"], op.ActionMetadata["column_options"], false)
+				if err != nil {
+					return fmt.Errorf("type of column %s can only be widened: %v", op.Name, err)
+				}
+			}
+		}
+	case core.Remap:
+		if mode == compatibility.Full {
+			return fmt.Errorf("column %s can't be remapped", op.Name)
+		}
+	case core.RenameEvent:
+		return fmt.Errorf("event %s can't be renamed", op.Name)
+	}
+	return nil
+}
+
+// checkCompatibility validates that the operations keep the schema
+// compatible under `mode`, given every version of the event so far. Outbound
+// names can't be reused with a different type than they had before, which is
+// checked against the whole history rather than just the current schema.
+func checkCompatibility(mode compatibility.Mode, eventName string, versions []SchemaVersion, ops []scoop_protocol.Operation) error {
+	if mode == compatibility.None {
+		return nil
+	}
+	schema := &scoop_protocol.Config{EventName: eventName}
+	retired := make(map[string]string)
+	apply := func(op scoop_protocol.Operation) error {
+		if op.Action == scoop_protocol.DELETE || op.Action == scoop_protocol.RENAME {
+			retireColumn(schema, retired, op.Name)
+		}
+		return ApplyOperation(schema, op)
+	}
+	for _, v := range versions {
+		for _, op := range v.Operations {
+			err := apply(op)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	for i, op := range ops {
+		err := checkOperationCompatibility(mode, schema, retired, op)
+		if err != nil {
+			return invalidf("operation %d (%s %s) not %s compatible: %v", i, op.Action, op.Name, mode, err)
+		}
+		err = apply(op)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// compatibilityMode returns the compatibility mode of the event, falling back
+// to the modes of the names it was renamed from
+func compatibilityMode(eventName string, versions []SchemaVersion) compatibility.Mode {
+	previousNames := []string{}
+	for _, v := range versions {
+		for _, op := range v.Operations {
+			if op.Action == core.RenameEvent {
+				previousNames = append(previousNames, op.Name)
+			}
+		}
+	}
+	return compatibility.Current().Mode(eventName, previousNames...)
+}
+
+// preValidateCompatibility validates the operations against the compatibility
+// mode of the event
+func preValidateCompatibility(bpdb Bpdb, eventName string, ops []scoop_protocol.Operation) error {
+	versions, err := bpdb.Versions(eventName)
+	if err != nil {
+		return fmt.Errorf("error getting versions to check compatibility: %v", err)
+	}
+	return checkCompatibility(compatibilityMode(eventName, versions), eventName, versions, ops)
+}
@@ -0,0 +1,127 @@
+package bpdb
+
+import (
+	"testing"
+
+	"github.com/twitchscience/blueprint/compatibility"
+	"github.com/twitchscience/blueprint/core"
+)
+
+// usePolicy sets the compatibility mode of video_play until the returned
+// function is called
+func usePolicy(mode compatibility.Mode) func() {
+	previous := compatibility.Current()
+	compatibility.Use(&compatibility.Policy{Events: map[string]compatibility.Mode{"video_play": mode}})
+	return func() { compatibility.Use(previous) }
+}
+
+func TestCompatibilityModes(t *testing.T) {
+	add := &core.ClientUpdateSchemaRequest{Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}}}
+	remove := &core.ClientUpdateSchemaRequest{Deletes: []string{"minutes"}}
+	rename := &core.ClientUpdateSchemaRequest{Renames: core.Renames{"channel": "channel_name"}}
+	widen := &core.ClientUpdateSchemaRequest{TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(64)"}}}
+	narrow := &core.ClientUpdateSchemaRequest{TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(16)"}}, Force: true}
+	remap := &core.ClientUpdateSchemaRequest{Remaps: []core.ColumnRemap{{OutboundName: "channel", InboundName: "channel_name"}}}
+
+	tests := []struct {
+		mode    compatibility.Mode
+		allowed []*core.ClientUpdateSchemaRequest
+		denied  []*core.ClientUpdateSchemaRequest
+	}{
+		{compatibility.None, []*core.ClientUpdateSchemaRequest{add, remove, rename, widen, narrow, remap}, nil},
+		{compatibility.Backward, []*core.ClientUpdateSchemaRequest{add, widen, remap}, []*core.ClientUpdateSchemaRequest{remove, rename, narrow}},
+		{compatibility.Full, []*core.ClientUpdateSchemaRequest{add}, []*core.ClientUpdateSchemaRequest{remove, rename, widen, narrow, remap}},
+		{compatibility.AdditiveOnly, []*core.ClientUpdateSchemaRequest{add}, []*core.ClientUpdateSchemaRequest{remove, rename, widen, narrow, remap}},
+	}
+	for _, test := range tests {
+		restore := usePolicy(test.mode)
+		for _, req := range append(test.allowed, test.denied...) {
+			b := NewMemoryBackend()
+			_, err := b.CreateSchema(testCreateRequest())
+			if err != nil {
+				t.Fatalf("Expected no error creating schema, got %v.", err)
+			}
+			update := *req
+			update.EventName = "video_play"
+			_, err = b.UpdateSchema(&update)
+			allowed := false
+			for _, a := range test.allowed {
+				allowed = allowed || a == req
+			}
+			if allowed && err != nil {
+				t.Errorf("Expected %v to be allowed in %s mode, got %v.", *req, test.mode, err)
+			} else if _, invalid := err.(*ValidationError); !allowed && !invalid {
+				t.Errorf("Expected %v to be rejected as invalid in %s mode, got %v.", *req, test.mode, err)
+			}
+		}
+		restore()
+	}
+}
+
+func TestCompatibilityReaddedColumn(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error deleting column, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Renames: core.Renames{"channel": "channel_name"}})
+	if err != nil {
+		t.Fatalf("Expected no error renaming column, got %v.", err)
+	}
+
+	defer usePolicy(compatibility.Backward)()
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "minutes", OutboundName: "minutes", Transformer: "varchar", Length: "(16)"}},
+	})
+	if err == nil {
+		t.Error("Expected error re-adding deleted column with a different type.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "channel", OutboundName: "channel", Transformer: "int", Length: ""}},
+	})
+	if err == nil {
+		t.Error("Expected error re-adding renamed column with a different type.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "minutes", OutboundName: "minutes", Transformer: "bigint", Length: ""}},
+	})
+	if err != nil {
+		t.Errorf("Expected no error re-adding deleted column with the same type, got %v.", err)
+	}
+}
+
+func TestCompatibilityModeAfterRename(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "playback"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+
+	// the policy still names the event by its old name
+	defer usePolicy(compatibility.Backward)()
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "playback", Deletes: []string{"minutes"}})
+	if _, invalid := err.(*ValidationError); !invalid {
+		t.Errorf("Expected the mode of the old name to reject deleting a column, got %v.", err)
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "playback", ToVersion: 0})
+	if _, invalid := err.(*ValidationError); !invalid {
+		t.Errorf("Expected the mode of the old name to reject reverting the rename, got %v.", err)
+	}
+
+	compatibility.Use(&compatibility.Policy{Events: map[string]compatibility.Mode{"video_play": compatibility.Backward, "playback": compatibility.None}})
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "playback", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Errorf("Expected the mode of the new name to allow deleting a column, got %v.", err)
+	}
+}
@@ -0,0 +1,240 @@
+package bpdb
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// The current_schema table materializes the current schema and lifecycle
+// state of each event, so reading schemas doesn't replay every operation ever
+// stored. It is updated in the same transaction as the operations, which stay
+// the source of truth it can be rebuilt from.
+var (
+	currentSchemaQuery = `SELECT config
+FROM current_schema
+WHERE event = $1
+AND event NOT IN (SELECT event FROM quarantined_event)`
+	currentSchemasQuery = `SELECT config, state
+FROM current_schema
+WHERE event NOT IN (SELECT event FROM quarantined_event)`
+	currentVersionsQuery = `SELECT event, version
+FROM current_schema
+WHERE event NOT IN (SELECT event FROM quarantined_event)`
+	currentSchemaForUpdateQuery = `SELECT version, state, config
+FROM current_schema
+WHERE event = $1
+FOR UPDATE`
+	upsertCurrentSchemaQuery = `INSERT INTO current_schema
+(event, version, state, config)
+VALUES ($1, $2, $3, $4)
+ON CONFLICT (event) DO UPDATE SET version = $2, state = $3, config = $4`
+	deleteCurrentSchemaQuery   = `DELETE FROM current_schema WHERE event = $1`
+	deleteCurrentSchemasQuery  = `DELETE FROM current_schema`
+	currentSchemasMissingQuery = `SELECT EXISTS (SELECT 1 FROM operation) AND NOT EXISTS (SELECT 1 FROM current_schema)`
+	eventLogQuery              = `
+SELECT event, action, name, version, ordering, action_metadata, sequence, COALESCE(hash, '')
+FROM operation
+WHERE event = $1
+ORDER BY version ASC, ordering ASC
+`
+)
+
+// materializedSchemas is implemented by backends that materialize the
+// current schemas from the operation log
+type materializedSchemas interface {
+	rebuildCurrentSchemas() (int, error)
+}
+
+// RebuildSchemas rebuilds the materialized current schemas from the
+// operation log, e.g. after it was fixed by hand, and returns the number of
+// events rebuilt. Events whose operations can't be replayed are left out.
+func RebuildSchemas(b Bpdb) (int, error) {
+	m, ok := b.(materializedSchemas)
+	if !ok {
+		return 0, fmt.Errorf("bpdb backend %T does not materialize schemas", b)
+	}
+	return m.rebuildCurrentSchemas()
+}
+
+// upsertCurrentSchema stores the materialized current schema of an event.
+// Does not rollback on error. Does not commit.
+func upsertCurrentSchema(tx *sql.Tx, eventName string, schema EventSchema) error {
+	b, err := json.Marshal(schema.Config)
+	if err != nil {
+		return fmt.Errorf("Error marshalling current schema of %s: %v", eventName, err)
+	}
+	_, err = tx.Exec(upsertCurrentSchemaQuery, eventName, schema.Version, schema.State, b)
+	if err != nil {
+		return fmt.Errorf("Error UPSERTing current schema of %s: %v", eventName, err)
+	}
+	return nil
+}
+
+// replayCurrentSchema replays the operation log of an event into its
+// materialized current schema, removing it if the event has no operations.
+// Does not rollback on error. Does not commit.
+func replayCurrentSchema(tx *sql.Tx, eventName string) error {
+	rows, err := tx.Query(eventLogQuery, eventName)
+	if err != nil {
+		return fmt.Errorf("Error querying for operations of %s: %v.", eventName, err)
+	}
+	ops, err := scanOperationRows(rows)
+	if err != nil {
+		return err
+	}
+	if len(ops) == 0 {
+		_, err = tx.Exec(deleteCurrentSchemaQuery, eventName)
+		if err != nil {
+			return fmt.Errorf("Error DELETEing current schema of %s: %v", eventName, err)
+		}
+		return nil
+	}
+	schemas, err := generateEventSchemas(ops, nil)
+	if err != nil {
+		return fmt.Errorf("Internal state bad - Error generating schemas from operations: %v", err)
+	}
+	return upsertCurrentSchema(tx, eventName, schemas[0])
+}
+
+// updateCurrentSchema applies the operations stored as `version` of the event
+// to its materialized current schema, or replays its whole log if the
+// materialized schema isn't of the version before.
+// Does not rollback on error. Does not commit.
+func updateCurrentSchema(tx *sql.Tx, ops []scoop_protocol.Operation, version int, eventName string) error {
+	schema := EventSchema{Config: scoop_protocol.Config{EventName: eventName}, State: core.StatePublished}
+	if version > 0 {
+		var current int
+		var b []byte
+		err := tx.QueryRow(currentSchemaForUpdateQuery, eventName).Scan(&current, &schema.State, &b)
+		if err == sql.ErrNoRows || (err == nil && current != version-1) {
+			return replayCurrentSchema(tx, eventName)
+		} else if err != nil {
+			return fmt.Errorf("Error querying for current schema of %s: %v", eventName, err)
+		}
+		err = json.Unmarshal(b, &schema.Config)
+		if err != nil {
+			return fmt.Errorf("Error unmarshalling current schema of %s: %v", eventName, err)
+		}
+	}
+	err := applyCurrentSchema(&schema, ops, version)
+	if err != nil {
+		return fmt.Errorf("Error applying operation to current schema of %s: %v", eventName, err)
+	}
+	return upsertCurrentSchema(tx, eventName, schema)
+}
+
+// applyCurrentSchema applies the operations stored as `version` of an event
+// to its materialized current schema as of the version before
+func applyCurrentSchema(schema *EventSchema, ops []scoop_protocol.Operation, version int) error {
+	for _, op := range ops {
+		err := ApplyOperation(&schema.Config, op)
+		if err != nil {
+			return err
+		}
+		if op.Action == core.SetState {
+			schema.State = op.ActionMetadata["state"]
+		}
+	}
+	schema.Version = version
+	return nil
+}
+
+// rebuildCurrentSchemas replaces the materialized current schemas with ones
+// replayed from the operation log, returning the number of events rebuilt.
+// Writes wait for the rebuild, so none are missed.
+func (p *postgresBackend) rebuildCurrentSchemas() (int, error) {
+	rebuilt := 0
+	err := p.execFnInTransaction(func(tx *sql.Tx) error {
+		rebuilt = 0
+		_, err := tx.Exec(sequenceLockQuery)
+		if err != nil {
+			return fmt.Errorf("Error locking operation sequence: %v", err)
+		}
+		rows, err := tx.Query(logRowsQuery)
+		if err != nil {
+			return fmt.Errorf("Error querying for operation log: %v.", err)
+		}
+		ops, err := scanOperationRows(rows)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(deleteCurrentSchemasQuery)
+		if err != nil {
+			return fmt.Errorf("Error DELETEing current schemas: %v", err)
+		}
+		names, events := rowsByEvent(ops)
+		for _, name := range names {
+			schemas, err := generateEventSchemas(events[name], nil)
+			if err != nil {
+				log.Printf("Leaving %s out of current schemas, its operations can't be replayed: %v", name, err)
+				continue
+			}
+			err = upsertCurrentSchema(tx, name, schemas[0])
+			if err != nil {
+				return err
+			}
+			rebuilt++
+		}
+		return notifySchemaChange(tx, "")
+	})
+	return rebuilt, err
+}
+
+// currentSchemasMissing reports whether there are operations but no
+// materialized current schemas, e.g. before the first migration since
+// current_schema was added
+func (p *postgresBackend) currentSchemasMissing() (bool, error) {
+	var missing bool
+	err := p.db.QueryRow(currentSchemasMissingQuery).Scan(&missing)
+	if err != nil {
+		return false, fmt.Errorf("Error querying for current schemas: %v", err)
+	}
+	return missing, nil
+}
+
+// rebuildMissingCurrentSchemas rebuilds the materialized current schemas if
+// none were materialized yet. It is part of migrating, so that processes
+// starting up never write.
+func (p *postgresBackend) rebuildMissingCurrentSchemas() error {
+	missing, err := p.currentSchemasMissing()
+	if err != nil || !missing {
+		return err
+	}
+	rebuilt, err := p.rebuildCurrentSchemas()
+	if err != nil {
+		return err
+	}
+	log.Printf("Materialized current schemas of %d events", rebuilt)
+	return nil
+}
+
+// currentVersions returns the current version of every event that isn't
+// quarantined, without reading their schemas
+func (p *postgresBackend) currentVersions() (map[string]int, error) {
+	rows, err := p.reads.Query(currentVersionsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for current versions: %v.", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in postgres backend currentVersions: %v", err)
+		}
+	}()
+	versions := make(map[string]int)
+	for rows.Next() {
+		var event string
+		var version int
+		err := rows.Scan(&event, &version)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing current version row: %v.", err)
+		}
+		versions[event] = version
+	}
+	return versions, rows.Err()
+}
@@ -0,0 +1,103 @@
+package bpdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// materialize writes the versions of an event through to a materialized
+// current schema the way the postgres backend does, storing it as JSON after
+// each version
+func materialize(t *testing.T, name string, versions []SchemaVersion) EventSchema {
+	schema := EventSchema{Config: scoop_protocol.Config{EventName: createdName(name, versions)}, State: core.StatePublished}
+	for _, v := range versions {
+		b, err := json.Marshal(schema.Config)
+		if err != nil {
+			t.Fatalf("Failed to marshal current schema: %v", err)
+		}
+		schema.Config = scoop_protocol.Config{}
+		err = json.Unmarshal(b, &schema.Config)
+		if err != nil {
+			t.Fatalf("Failed to unmarshal current schema: %v", err)
+		}
+		err = applyCurrentSchema(&schema, v.Operations, v.Version)
+		if err != nil {
+			t.Fatalf("Failed to apply v%d to current schema: %v", v.Version, err)
+		}
+	}
+	return schema
+}
+
+// replayed returns the schema of the only event replayed from the operation log
+func replayed(t *testing.T, b Bpdb) EventSchema {
+	schemas, err := b.EventSchemas()
+	if err != nil || len(schemas) != 1 {
+		t.Fatalf("Expected one event to be replayed, got %v (err %v)", schemas, err)
+	}
+	return schemas[0]
+}
+
+func TestCurrentSchemaWriteThrough(t *testing.T) {
+	b := NewMemoryBackend()
+	create := testCreateRequest()
+	create.Draft = true
+	until := time.Now().Add(time.Hour)
+	writes := []func() error{
+		func() error {
+			_, err := b.CreateSchema(create)
+			return err
+		},
+		func() error {
+			return b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StatePublished})
+		},
+		func() error {
+			_, err := b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+				EventName: "video_play",
+				Additions: []core.Column{{InboundName: "user", OutboundName: "user_id", Transformer: "bigint"}},
+				Renames:   core.Renames{"channel": "channel_name"},
+			})
+			return err
+		},
+		func() error {
+			_, err := b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+				EventName:    "video_play",
+				Deprecations: []core.Deprecation{{OutboundName: "minutes", Until: &until}},
+			})
+			return err
+		},
+		func() error {
+			return b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "video_start"})
+		},
+		func() error {
+			_, err := b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_start", Deletes: []string{"minutes"}, Force: true})
+			return err
+		},
+		func() error {
+			_, _, err := b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_start", ToVersion: 4})
+			return err
+		},
+		func() error {
+			return b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_start", State: core.StateDeprecated})
+		},
+	}
+	for i, write := range writes {
+		err := write()
+		if err != nil {
+			t.Fatalf("Expected no error from write %d, got %v.", i, err)
+		}
+		expected := replayed(t, b)
+		versions, err := b.Versions(expected.EventName)
+		if err != nil {
+			t.Fatalf("Failed to get versions after write %d: %v", i, err)
+		}
+		actual := materialize(t, expected.EventName, versions)
+		if !reflect.DeepEqual(actual, expected) {
+			t.Errorf("Materialized schema after write %d differs from replay:\n%+v\nvs\n%+v", i, actual, expected)
+		}
+	}
+}
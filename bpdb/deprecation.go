@@ -0,0 +1,96 @@
+package bpdb
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// applyDeprecationOperation updates the deprecated columns of a schema for an
+// operation stored in `version`. Deprecations follow their column through
+// renames and end when it is deleted.
+func applyDeprecationOperation(deprecations map[string]core.ColumnDeprecation, op scoop_protocol.Operation, version int) error {
+	switch op.Action {
+	case core.DeprecateColumn:
+		until, err := core.OperationDeprecationUntil(op)
+		if err != nil {
+			return fmt.Errorf("Error reading deprecation: %v", err)
+		}
+		if until == nil {
+			delete(deprecations, op.Name)
+		} else {
+			deprecations[op.Name] = core.ColumnDeprecation{Until: *until, Version: version}
+		}
+	case scoop_protocol.DELETE:
+		delete(deprecations, op.Name)
+	case scoop_protocol.RENAME:
+		if d, ok := deprecations[op.Name]; ok {
+			delete(deprecations, op.Name)
+			deprecations[op.ActionMetadata["new_outbound"]] = d
+		}
+	}
+	return nil
+}
+
+// replayDeprecations returns the deprecated columns of a schema as of `version`
+func replayDeprecations(versions []SchemaVersion, version int) (map[string]core.ColumnDeprecation, error) {
+	deprecations := make(map[string]core.ColumnDeprecation)
+	for _, v := range versions {
+		if v.Version > version {
+			break
+		}
+		for _, op := range v.Operations {
+			err := applyDeprecationOperation(deprecations, op, v.Version)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return deprecations, nil
+}
+
+// DeprecatedColumnsAtVersion returns the deprecated columns of the schema
+// `name` as of `version`, which may be CurrentVersion
+func DeprecatedColumnsAtVersion(b Bpdb, name string, version int) (map[string]core.ColumnDeprecation, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return nil, err
+	}
+	return replayDeprecations(versions, resolveVersion(versions, version))
+}
+
+// ExpiredColumns returns the deprecated columns whose grace period has ended
+// by `now`, in order
+func ExpiredColumns(deprecations map[string]core.ColumnDeprecation, now time.Time) []string {
+	expired := []string{}
+	for name, d := range deprecations {
+		if d.Expired(now) {
+			expired = append(expired, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// preValidateDeprecations validates that the operations don't delete a
+// column before the end of its grace period, unless `force` is set, and
+// lints the deprecated columns after the operations, returning the new warnings
+func preValidateDeprecations(eventName string, deprecations map[string]core.ColumnDeprecation, ops []scoop_protocol.Operation, force bool, now time.Time) (lint.Findings, error) {
+	before := lint.Current().LintDeprecations(deprecations, now)
+	for i, op := range ops {
+		if d, ok := deprecations[op.Name]; ok && op.Action == scoop_protocol.DELETE && !force && !d.Expired(now) {
+			return nil, invalidf("operation %d (%s %s): column is deprecated until %s, use Force to delete it anyway",
+				i, op.Action, op.Name, d.Until.Format(time.RFC3339))
+		}
+		err := applyDeprecationOperation(deprecations, op, 0)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return reportFindings(eventName, lint.Current().LintDeprecations(deprecations, now).Since(before))
+}
@@ -0,0 +1,114 @@
+package bpdb
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+func TestDeprecateColumn(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	until := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deprecations: []core.Deprecation{{OutboundName: "time", Until: &until}},
+	})
+	if err == nil {
+		t.Error("Expected error deprecating a sortkey.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deprecations: []core.Deprecation{{OutboundName: "minutes", Until: &until}},
+		WriteOptions: core.WriteOptions{Author: "alice"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error deprecating column, got %v.", err)
+	}
+
+	deprecations, err := DeprecatedColumnsAtVersion(b, "video_play", CurrentVersion)
+	expected := map[string]core.ColumnDeprecation{"minutes": {Until: until, Version: 1}}
+	if err != nil || !reflect.DeepEqual(deprecations, expected) {
+		t.Errorf("Expected %v, got %v (err %v).", expected, deprecations, err)
+	}
+	versions, err := b.Versions("video_play")
+	if err != nil || len(versions) != 2 || versions[1].Audit == nil || versions[1].Audit.Author != "alice" {
+		t.Errorf("Expected deprecation in the audited history, got %v (err %v).", versions, err)
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || len(schema.Columns) != 3 {
+		t.Errorf("Expected deprecated column to be kept, got %v (err %v).", schema, err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err == nil {
+		t.Error("Expected error deleting column in its grace period.")
+	}
+	if expired := ExpiredColumns(deprecations, time.Now()); len(expired) != 0 {
+		t.Errorf("Expected no expired columns, got %v.", expired)
+	}
+	if expired := ExpiredColumns(deprecations, until); !reflect.DeepEqual(expired, []string{"minutes"}) {
+		t.Errorf("Expected minutes to expire at the end of its grace period, got %v.", expired)
+	}
+
+	// deprecations follow renames, and are reverted
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Renames: core.Renames{"minutes": "minutes_watched"}})
+	if err != nil {
+		t.Fatalf("Expected no error renaming column, got %v.", err)
+	}
+	deprecations, err = DeprecatedColumnsAtVersion(b, "video_play", CurrentVersion)
+	if _, ok := deprecations["minutes_watched"]; err != nil || !ok || len(deprecations) != 1 {
+		t.Errorf("Expected deprecation to follow the rename, got %v (err %v).", deprecations, err)
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0})
+	if err != nil {
+		t.Fatalf("Expected no error reverting, got %v.", err)
+	}
+	deprecations, err = DeprecatedColumnsAtVersion(b, "video_play", CurrentVersion)
+	if err != nil || len(deprecations) != 0 {
+		t.Errorf("Expected no deprecations after reverting, got %v (err %v).", deprecations, err)
+	}
+}
+
+func TestDeleteDeprecatedColumn(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	past := time.Now().Add(-time.Hour)
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deprecations: []core.Deprecation{{OutboundName: "minutes", Until: &past}},
+	})
+	if err == nil {
+		t.Errorf("Expected error deprecating a column until the past.")
+	}
+	soon := time.Now().Add(10 * time.Millisecond)
+	future := time.Now().Add(time.Hour)
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deprecations: []core.Deprecation{{OutboundName: "minutes", Until: &soon}, {OutboundName: "channel", Until: &future}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error deprecating columns, got %v.", err)
+	}
+	time.Sleep(20 * time.Millisecond)
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Errorf("Expected no error deleting column after its grace period, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"channel"}, Force: true})
+	if err != nil {
+		t.Errorf("Expected no error forcing delete in the grace period, got %v.", err)
+	}
+	deprecations, err := DeprecatedColumnsAtVersion(b, "video_play", CurrentVersion)
+	if err != nil || len(deprecations) != 0 {
+		t.Errorf("Expected deprecations to end with their columns, got %v (err %v).", deprecations, err)
+	}
+}
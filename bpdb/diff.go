@@ -0,0 +1,198 @@
+package bpdb
+
+import (
+	"fmt"
+
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// CurrentVersion can be passed to the diff functions in place of a version to
+// compare the current version of a schema
+const CurrentVersion = -1
+
+// ColumnRename is a column that was renamed between two schemas
+type ColumnRename struct {
+	From string
+	To   string
+}
+
+// ColumnChange is a property of a column that changed between two schemas.
+// Column is the outbound name of the column in the later schema.
+type ColumnChange struct {
+	Column string
+	From   string
+	To     string
+}
+
+// SchemaDiff is the structured difference between two schemas
+type SchemaDiff struct {
+	FromEvent   string
+	FromVersion int
+	ToEvent     string
+	ToVersion   int
+
+	Added          []scoop_protocol.ColumnDefinition
+	Removed        []scoop_protocol.ColumnDefinition
+	Renamed        []ColumnRename
+	TypeChanged    []ColumnChange
+	OptionsChanged []ColumnChange
+	Remapped       []ColumnChange
+}
+
+// identifiedColumn is a column along with an identity that is kept when the
+// column is renamed
+type identifiedColumn struct {
+	id  string
+	col scoop_protocol.ColumnDefinition
+}
+
+// identifyColumns replays the versions of a schema up to and including
+// `version`, identifying each column by the operation that added it. It
+// returns nil if there is no such version.
+func identifyColumns(eventName string, versions []SchemaVersion, version int) ([]identifiedColumn, error) {
+	version = resolveVersion(versions, version)
+	schema := &scoop_protocol.Config{EventName: createdName(eventName, versions)}
+	ids := []string{}
+	found := false
+	for _, v := range versions {
+		if v.Version > version {
+			break
+		}
+		found = found || v.Version == version
+		for i, op := range v.Operations {
+			index := -1
+			for j, col := range schema.Columns {
+				if col.OutboundName == op.Name {
+					index = j
+				}
+			}
+			err := ApplyOperation(schema, op)
+			if err != nil {
+				return nil, err
+			}
+			switch op.Action {
+			case scoop_protocol.ADD:
+				ids = append(ids, fmt.Sprintf("%d.%d", v.Version, i))
+			case scoop_protocol.DELETE:
+				ids = append(ids[:index], ids[index+1:]...)
+			}
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+
+	cols := make([]identifiedColumn, 0, len(schema.Columns))
+	for i, col := range schema.Columns {
+		cols = append(cols, identifiedColumn{id: ids[i], col: col})
+	}
+	return cols, nil
+}
+
+// diffColumns compares the columns of two schemas, matching them by identity
+func diffColumns(from []identifiedColumn, to []identifiedColumn) *SchemaDiff {
+	diff := &SchemaDiff{
+		Added:          []scoop_protocol.ColumnDefinition{},
+		Removed:        []scoop_protocol.ColumnDefinition{},
+		Renamed:        []ColumnRename{},
+		TypeChanged:    []ColumnChange{},
+		OptionsChanged: []ColumnChange{},
+		Remapped:       []ColumnChange{},
+	}
+	fromByID := make(map[string]scoop_protocol.ColumnDefinition, len(from))
+	for _, c := range from {
+		fromByID[c.id] = c.col
+	}
+	toIDs := make(map[string]bool, len(to))
+	for _, c := range to {
+		toIDs[c.id] = true
+		old, ok := fromByID[c.id]
+		if !ok {
+			diff.Added = append(diff.Added, c.col)
+			continue
+		}
+		if old.OutboundName != c.col.OutboundName {
+			diff.Renamed = append(diff.Renamed, ColumnRename{From: old.OutboundName, To: c.col.OutboundName})
+		}
+		if old.Transformer != c.col.Transformer {
+			diff.TypeChanged = append(diff.TypeChanged, ColumnChange{Column: c.col.OutboundName, From: old.Transformer, To: c.col.Transformer})
+		}
+		if old.ColumnCreationOptions != c.col.ColumnCreationOptions {
+			diff.OptionsChanged = append(diff.OptionsChanged, ColumnChange{Column: c.col.OutboundName, From: old.ColumnCreationOptions, To: c.col.ColumnCreationOptions})
+		}
+		if old.InboundName != c.col.InboundName {
+			diff.Remapped = append(diff.Remapped, ColumnChange{Column: c.col.OutboundName, From: old.InboundName, To: c.col.InboundName})
+		}
+	}
+	for _, c := range from {
+		if !toIDs[c.id] {
+			diff.Removed = append(diff.Removed, c.col)
+		}
+	}
+	return diff
+}
+
+// DiffVersions returns the difference between two versions of the schema
+// `name`. Renamed columns are worked out from the operation log. It returns
+// nil if either version doesn't exist.
+func DiffVersions(b Bpdb, name string, from int, to int) (*SchemaDiff, error) {
+	versions, err := versionsWith(b, name, max(from, to))
+	if err != nil {
+		return nil, err
+	}
+	fromCols, err := identifyColumns(name, versions, from)
+	if err != nil || fromCols == nil {
+		return nil, err
+	}
+	toCols, err := identifyColumns(name, versions, to)
+	if err != nil || toCols == nil {
+		return nil, err
+	}
+	diff := diffColumns(fromCols, toCols)
+	diff.FromEvent, diff.FromVersion = name, resolveVersion(versions, from)
+	diff.ToEvent, diff.ToVersion = name, resolveVersion(versions, to)
+	return diff, nil
+}
+
+// DiffEvents returns the difference between versions of two different
+// schemas. The schemas share no operation log, so columns are matched by
+// outbound name and none are reported as renamed. It returns nil if either
+// version doesn't exist.
+func DiffEvents(b Bpdb, fromName string, fromVersion int, toName string, toVersion int) (*SchemaDiff, error) {
+	fromCols, fromVersion, err := namedColumns(b, fromName, fromVersion)
+	if err != nil || fromCols == nil {
+		return nil, err
+	}
+	toCols, toVersion, err := namedColumns(b, toName, toVersion)
+	if err != nil || toCols == nil {
+		return nil, err
+	}
+	diff := diffColumns(fromCols, toCols)
+	diff.FromEvent, diff.FromVersion = fromName, fromVersion
+	diff.ToEvent, diff.ToVersion = toName, toVersion
+	return diff, nil
+}
+
+// namedColumns returns the columns of the schema `name` as of `version`,
+// identified by outbound name, and the version. The columns are nil if there
+// is no such version.
+func namedColumns(b Bpdb, name string, version int) ([]identifiedColumn, int, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return nil, 0, err
+	}
+	cols, err := identifyColumns(name, versions, version)
+	for i := range cols {
+		cols[i].id = cols[i].col.OutboundName
+	}
+	return cols, resolveVersion(versions, version), err
+}
+
+// resolveVersion returns the version, or the latest of versions if it is
+// CurrentVersion
+func resolveVersion(versions []SchemaVersion, version int) int {
+	if version == CurrentVersion && len(versions) > 0 {
+		return versions[len(versions)-1].Version
+	}
+	return version
+}
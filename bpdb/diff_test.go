@@ -0,0 +1,88 @@
+package bpdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func TestDiffVersions(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Renames:   core.Renames{"channel": "channel_name"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	// a column dropped and re-added under the same name is a different column
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Deletes:   []string{"minutes"},
+		Additions: []core.Column{{InboundName: "minutes", OutboundName: "minutes", Transformer: "float"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	diff, err := DiffVersions(b, "video_play", 0, CurrentVersion)
+	if err != nil {
+		t.Fatalf("Expected no error diffing versions, got %v.", err)
+	}
+	expected := &SchemaDiff{
+		FromEvent:      "video_play",
+		FromVersion:    0,
+		ToEvent:        "video_play",
+		ToVersion:      2,
+		Added:          []scoop_protocol.ColumnDefinition{{InboundName: "minutes", OutboundName: "minutes", Transformer: "float"}},
+		Removed:        []scoop_protocol.ColumnDefinition{{InboundName: "minutes", OutboundName: "minutes", Transformer: "bigint"}},
+		Renamed:        []ColumnRename{{From: "channel", To: "channel_name"}},
+		TypeChanged:    []ColumnChange{},
+		OptionsChanged: []ColumnChange{},
+		Remapped:       []ColumnChange{},
+	}
+	if !reflect.DeepEqual(expected, diff) {
+		t.Errorf("Diff differs from expected:\n%v\nvs\n%v.", diff, expected)
+	}
+
+	diff, err = DiffVersions(b, "video_play", 0, 3)
+	if err != nil || diff != nil {
+		t.Errorf("Expected no diff to missing version, got %v (err %v).", diff, err)
+	}
+}
+
+func TestDiffEvents(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	other := testCreateRequest()
+	other.EventName = "video_pause"
+	other.Columns = []scoop_protocol.ColumnDefinition{
+		{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+		{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(64)"},
+		{InboundName: "minutes", OutboundName: "minutes", Transformer: "float"},
+	}
+	_, err = b.CreateSchema(other)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	diff, err := DiffEvents(b, "video_play", CurrentVersion, "video_pause", CurrentVersion)
+	if err != nil {
+		t.Fatalf("Expected no error diffing events, got %v.", err)
+	}
+	expectedTypes := []ColumnChange{{Column: "minutes", From: "bigint", To: "float"}}
+	expectedOptions := []ColumnChange{{Column: "channel", From: "(32)", To: "(64)"}}
+	if len(diff.Added) != 0 || len(diff.Removed) != 0 || len(diff.Renamed) != 0 ||
+		!reflect.DeepEqual(expectedTypes, diff.TypeChanged) || !reflect.DeepEqual(expectedOptions, diff.OptionsChanged) {
+		t.Errorf("Unexpected diff between events: %v.", diff)
+	}
+}
@@ -0,0 +1,262 @@
+package bpdb
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"syscall"
+
+	"github.com/twitchscience/aws_utils/logger"
+)
+
+const (
+	journalFilename = "operations.journal"
+	lockFilename    = "LOCK"
+)
+
+// fileJournal is an append-only file of committed transactions. Each line holds
+// the CRC32 of a JSON-encoded journalEntry followed by the entry itself, and is
+// fsync'd before the transaction is considered committed. A final line left
+// without its newline by a crash is discarded on open; any other line that
+// fails its CRC is corruption and stops the journal from opening.
+type fileJournal struct {
+	file *os.File
+	lock *os.File
+}
+
+// journalEntry is a transaction written to the journal
+type journalEntry struct {
+	Event          string       `json:"event"`
+	Version        int          `json:"version"`
+	Rows           []journalRow `json:"rows"`
+	IdempotencyKey string       `json:"idempotency_key,omitempty"`
+	RequestHash    string       `json:"request_hash,omitempty"`
+	Audit          Audit        `json:"audit"`
+	RenamedFrom    string       `json:"renamed_from,omitempty"`
+	Repair         *logRepair   `json:"repair,omitempty"`
+}
+
+// journalRow mirrors a row in the postgres operation table
+type journalRow struct {
+	Event          string            `json:"event"`
+	Action         string            `json:"action"`
+	Name           string            `json:"name"`
+	Version        int               `json:"version"`
+	Ordering       int               `json:"ordering"`
+	ActionMetadata map[string]string `json:"action_metadata"`
+}
+
+// NewFileBackend creates a bpdb backend that stores the operation log in a
+// journal in directory `dir`, for deployments without a postgres instance.
+// Schemas are served from memory, so only one process may use the directory
+// at a time.
+func NewFileBackend(dir string) (Bpdb, error) {
+	journal, txns, err := openFileJournal(dir)
+	if err != nil {
+		return nil, err
+	}
+	m := newMemoryBackend()
+	for _, txn := range txns {
+		m.apply(txn)
+	}
+	m.journal = journal
+	return m, nil
+}
+
+// openFileJournal locks the directory, replays the journal and returns the
+// transactions it contains, and opens the journal for appending.
+func openFileJournal(dir string) (*fileJournal, []*memoryTransaction, error) {
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error creating bpdb directory %s: %v", dir, err)
+	}
+	lock, err := os.OpenFile(path.Join(dir, lockFilename), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error opening lock file in %s: %v", dir, err)
+	}
+	err = syscall.Flock(int(lock.Fd()), syscall.LOCK_EX|syscall.LOCK_NB)
+	if err != nil {
+		_ = lock.Close()
+		return nil, nil, fmt.Errorf("Error locking %s, is another blueprint using it? %v", dir, err)
+	}
+
+	file, err := os.OpenFile(path.Join(dir, journalFilename), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		_ = lock.Close()
+		return nil, nil, fmt.Errorf("Error opening journal in %s: %v", dir, err)
+	}
+	journal := &fileJournal{file: file, lock: lock}
+	txns, err := journal.replay()
+	if err != nil {
+		_ = file.Close()
+		_ = lock.Close()
+		return nil, nil, err
+	}
+	err = syncDir(dir)
+	if err != nil {
+		_ = file.Close()
+		_ = lock.Close()
+		return nil, nil, err
+	}
+	return journal, txns, nil
+}
+
+// replay reads every committed entry in the journal, truncates an unterminated
+// final entry if there is one, and leaves the file positioned for appending.
+func (j *fileJournal) replay() ([]*memoryTransaction, error) {
+	txns := []*memoryTransaction{}
+	reader := bufio.NewReader(j.file)
+	var committed int64
+	for lineNum := 1; ; lineNum++ {
+		line, readErr := reader.ReadBytes('\n')
+		if readErr == io.EOF && len(line) == 0 {
+			break
+		}
+		if readErr == io.EOF {
+			// only an entry whose newline never made it to disk is torn, a
+			// complete one that doesn't decode was committed and is corrupt
+			logger.WithField("line", lineNum).Warn("Discarding torn entry at end of bpdb journal")
+			break
+		} else if readErr != nil {
+			return nil, fmt.Errorf("Error reading journal: %v", readErr)
+		}
+		entry, err := decodeJournalLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("Journal corrupt at line %d: %v", lineNum, err)
+		}
+		txn := &memoryTransaction{
+			event:       entry.Event,
+			version:     entry.Version,
+			rows:        make([]operationRow, 0, len(entry.Rows)),
+			idempotency: idempotency{key: entry.IdempotencyKey, requestHash: entry.RequestHash},
+			audit:       entry.Audit,
+			renamedFrom: entry.RenamedFrom,
+			repair:      entry.Repair,
+		}
+		for _, r := range entry.Rows {
+			txn.rows = append(txn.rows, operationRow{
+				event:          r.Event,
+				action:         r.Action,
+				name:           r.Name,
+				actionMetadata: r.ActionMetadata,
+				version:        r.Version,
+				ordering:       r.Ordering,
+			})
+		}
+		txns = append(txns, txn)
+		committed += int64(len(line))
+	}
+
+	err := j.file.Truncate(committed)
+	if err != nil {
+		return nil, fmt.Errorf("Error truncating journal: %v", err)
+	}
+	_, err = j.file.Seek(committed, os.SEEK_SET)
+	if err != nil {
+		return nil, fmt.Errorf("Error seeking to end of journal: %v", err)
+	}
+	return txns, nil
+}
+
+// decodeJournalLine checks the CRC of a journal line and decodes its entry
+func decodeJournalLine(line []byte) (*journalEntry, error) {
+	line = bytes.TrimSuffix(line, []byte("\n"))
+	parts := bytes.SplitN(line, []byte(" "), 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("malformed entry")
+	}
+	crc, err := strconv.ParseUint(string(parts[0]), 16, 32)
+	if err != nil {
+		return nil, fmt.Errorf("malformed checksum: %v", err)
+	}
+	if uint32(crc) != crc32.ChecksumIEEE(parts[1]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	var entry journalEntry
+	err = json.Unmarshal(parts[1], &entry)
+	if err != nil {
+		return nil, fmt.Errorf("malformed json: %v", err)
+	}
+	return &entry, nil
+}
+
+// append durably writes the transaction to the journal as a single entry. On
+// error, the journal is rolled back to its previous length.
+func (j *fileJournal) append(txn *memoryTransaction) error {
+	entry := journalEntry{
+		Event:          txn.event,
+		Version:        txn.version,
+		Rows:           make([]journalRow, 0, len(txn.rows)),
+		IdempotencyKey: txn.idempotency.key,
+		RequestHash:    txn.idempotency.requestHash,
+		Audit:          txn.audit,
+		RenamedFrom:    txn.renamedFrom,
+		Repair:         txn.repair,
+	}
+	for _, r := range txn.rows {
+		entry.Rows = append(entry.Rows, journalRow{
+			Event:          r.event,
+			Action:         r.action,
+			Name:           r.name,
+			Version:        r.version,
+			Ordering:       r.ordering,
+			ActionMetadata: r.actionMetadata,
+		})
+	}
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("Error marshalling journal entry: %v", err)
+	}
+	line := []byte(fmt.Sprintf("%08x ", crc32.ChecksumIEEE(b)))
+	line = append(append(line, b...), '\n')
+
+	offset, err := j.file.Seek(0, os.SEEK_CUR)
+	if err != nil {
+		return fmt.Errorf("Error finding end of journal: %v", err)
+	}
+	_, err = j.file.Write(line)
+	if err == nil {
+		err = j.file.Sync()
+	}
+	if err != nil {
+		rollbackErr := j.file.Truncate(offset)
+		if rollbackErr == nil {
+			_, rollbackErr = j.file.Seek(offset, os.SEEK_SET)
+		}
+		if rollbackErr != nil {
+			return fmt.Errorf("Could not rollback journal successfully after error (%v), reason: %v", err, rollbackErr)
+		}
+		return err
+	}
+	return nil
+}
+
+// close closes the journal and releases the directory lock
+func (j *fileJournal) close() error {
+	err := j.file.Close()
+	lockErr := j.lock.Close()
+	if err != nil {
+		return err
+	}
+	return lockErr
+}
+
+// syncDir fsyncs a directory so newly created files in it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("Error opening %s to sync: %v", dir, err)
+	}
+	err = d.Sync()
+	closeErr := d.Close()
+	if err != nil {
+		return fmt.Errorf("Error syncing %s: %v", dir, err)
+	}
+	return closeErr
+}
@@ -0,0 +1,181 @@
+package bpdb
+
+import (
+	"io/ioutil"
+	"os"
+	"path"
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+func tempBpdbDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "bpdb")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %v", err)
+	}
+	return dir
+}
+
+func closeFileBackend(t *testing.T, b Bpdb) {
+	err := b.(*memoryBackend).journal.close()
+	if err != nil {
+		t.Errorf("Failed to close journal: %v", err)
+	}
+}
+
+func TestFileBackendReplay(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deletes:      []string{"minutes"},
+		WriteOptions: core.WriteOptions{Author: "alice"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	expected, err := b.Schema("video_play")
+	if err != nil {
+		t.Fatalf("Expected no error getting schema, got %v.", err)
+	}
+	closeFileBackend(t, b)
+
+	b, err = NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file backend: %v", err)
+	}
+	defer closeFileBackend(t, b)
+	schema, err := b.Schema("video_play")
+	if err != nil || !reflect.DeepEqual(expected, schema) {
+		t.Errorf("Replayed schema differs from expected (err %v):\n%v\nvs\n%v.", err, schema, expected)
+	}
+	versions, err := b.Versions("video_play")
+	if err != nil || len(versions) != 2 || versions[1].Audit == nil || versions[1].Audit.Author != "alice" {
+		t.Errorf("Expected replayed audit for version 1, got %v (err %v).", versions, err)
+	}
+}
+
+func TestFileBackendTornWrite(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	closeFileBackend(t, b)
+
+	f, err := os.OpenFile(path.Join(dir, journalFilename), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatalf("Failed to open journal: %v", err)
+	}
+	_, err = f.WriteString(`0badc0de {"rows":[{"event":"video_play","action":"delete","na`)
+	if err != nil {
+		t.Fatalf("Failed to write torn entry: %v", err)
+	}
+	_ = f.Close()
+
+	b, err = NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Expected torn entry to be discarded, got %v.", err)
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.Version != 0 || len(schema.Columns) != 3 {
+		t.Errorf("Expected only the committed schema, got %v (err %v).", schema, err)
+	}
+
+	// new entries must land after the last committed one
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	closeFileBackend(t, b)
+	b, err = NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file backend: %v", err)
+	}
+	defer closeFileBackend(t, b)
+	schema, err = b.Schema("video_play")
+	if err != nil || schema.Version != 1 || len(schema.Columns) != 2 {
+		t.Errorf("Expected updated schema after reopen, got %v (err %v).", schema, err)
+	}
+}
+
+func TestFileBackendCorruptJournal(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	err := ioutil.WriteFile(path.Join(dir, journalFilename), []byte("0badc0de {}\n0badc0de {}\n"), 0644)
+	if err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+	_, err = NewFileBackend(dir)
+	if err == nil {
+		t.Error("Expected error opening corrupt journal.")
+	}
+}
+
+func TestFileBackendCorruptFinalEntry(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	closeFileBackend(t, b)
+
+	// flip a byte of the only, complete entry
+	filename := path.Join(dir, journalFilename)
+	journal, err := ioutil.ReadFile(filename)
+	if err != nil {
+		t.Fatalf("Failed to read journal: %v", err)
+	}
+	journal[len(journal)-3] ^= 0x01
+	err = ioutil.WriteFile(filename, journal, 0644)
+	if err != nil {
+		t.Fatalf("Failed to write journal: %v", err)
+	}
+	_, err = NewFileBackend(dir)
+	if err == nil {
+		t.Fatal("Expected error opening journal with a corrupt committed entry.")
+	}
+	after, err := ioutil.ReadFile(filename)
+	if err != nil || len(after) != len(journal) {
+		t.Errorf("Expected corrupt entry to be kept, journal is %d bytes instead of %d (err %v).", len(after), len(journal), err)
+	}
+}
+
+func TestFileBackendLocked(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	defer closeFileBackend(t, b)
+	_, err = NewFileBackend(dir)
+	if err == nil {
+		t.Error("Expected error opening a directory in use.")
+	}
+}
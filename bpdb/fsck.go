@@ -0,0 +1,406 @@
+package bpdb
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// Checks fsck makes of the operation log of each event
+const (
+	CheckVersions  = "versions"
+	CheckOrderings = "orderings"
+	CheckReplay    = "replay"
+	CheckHashChain = "hash-chain"
+)
+
+// Problem is an inconsistency found in the operation log of an event
+type Problem struct {
+	Event    string
+	Check    string
+	Version  int
+	Ordering int // -1 if the problem is with the whole version
+	Message  string
+}
+
+func (p Problem) String() string {
+	if p.Ordering < 0 {
+		return fmt.Sprintf("%s v%d: %s: %s", p.Event, p.Version, p.Check, p.Message)
+	}
+	return fmt.Sprintf("%s v%d #%d: %s: %s", p.Event, p.Version, p.Ordering, p.Check, p.Message)
+}
+
+// FsckReport is the result of checking the operation log
+type FsckReport struct {
+	Events   int
+	Rows     int
+	Sealed   int // rows covered by the hash chain
+	Problems []Problem
+
+	// Quarantined maps each quarantined event to the reason it was quarantined
+	Quarantined map[string]string
+}
+
+// Broken returns the events with problems that aren't quarantined, in order
+func (r *FsckReport) Broken() []string {
+	seen := make(map[string]bool)
+	broken := []string{}
+	for _, p := range r.Problems {
+		if _, ok := r.Quarantined[p.Event]; !ok && !seen[p.Event] {
+			seen[p.Event] = true
+			broken = append(broken, p.Event)
+		}
+	}
+	sort.Strings(broken)
+	return broken
+}
+
+// rowSeal is the hash chaining an operation row to the rows before it
+type rowSeal struct {
+	Event    string `json:"event"`
+	Version  int    `json:"version"`
+	Ordering int    `json:"ordering"`
+	Hash     string `json:"hash"`
+}
+
+// chainHead is the last row the hash chain of an event covered when it was
+// sealed, and the number of rows it covered, so that removing sealed rows
+// from the end of the log, or the whole event, is reported
+type chainHead struct {
+	Event    string `json:"event"`
+	Version  int    `json:"version"`
+	Ordering int    `json:"ordering"`
+	Hash     string `json:"hash"`
+	Rows     int    `json:"rows"`
+}
+
+// operationLog is implemented by backends whose operation log fsck can check
+// and repair. Quarantined events are left out of every other read, so a broken
+// event doesn't stop the others from being served.
+type operationLog interface {
+	// logRows returns every operation row, including those of quarantined
+	// events, ordered by version and ordering
+	logRows() ([]operationRow, error)
+	quarantinedEvents() (map[string]string, error)
+	quarantine(event string, reason string) error
+	release(event string) error
+
+	// chainHeads returns the head of the hash chain of each sealed event
+	chainHeads() (map[string]chainHead, error)
+
+	// seal stores the hashes of the rows and the heads of the chains together
+	seal(seals []rowSeal, heads []chainHead) error
+}
+
+// asOperationLog returns the operation log of the backend, if fsck supports it
+func asOperationLog(b Bpdb) (operationLog, error) {
+	opLog, ok := b.(operationLog)
+	if !ok {
+		return nil, fmt.Errorf("bpdb backend %T does not support fsck", b)
+	}
+	return opLog, nil
+}
+
+// rowsByEvent groups operation rows by event, keeping their order, and
+// returns the event names in order
+func rowsByEvent(rows []operationRow) ([]string, map[string][]operationRow) {
+	events := make(map[string][]operationRow)
+	for _, row := range rows {
+		events[row.event] = append(events[row.event], row)
+	}
+	names := make([]string, 0, len(events))
+	for name := range events {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, events
+}
+
+// checkSequence reports the versions of an event that aren't numbered from 0
+// without gaps, and the operations of each version that aren't either
+func checkSequence(event string, rows []operationRow) []Problem {
+	problems := []Problem{}
+	version, nextOrdering := -1, 0
+	for _, row := range rows {
+		if row.version != version {
+			if row.version != version+1 {
+				problems = append(problems, Problem{event, CheckVersions, row.version, -1,
+					fmt.Sprintf("expected version %d", version+1)})
+			}
+			version, nextOrdering = row.version, 0
+		}
+		if row.ordering != nextOrdering {
+			problems = append(problems, Problem{event, CheckOrderings, row.version, row.ordering,
+				fmt.Sprintf("expected ordering %d", nextOrdering)})
+		}
+		nextOrdering = row.ordering + 1
+	}
+	return problems
+}
+
+// checkReplay replays the operations of an event, reporting the first one
+// that can't be applied since the schema after it is unknown
+func checkReplay(event string, rows []operationRow) []Problem {
+	schema := &scoop_protocol.Config{EventName: event}
+	tableOpts := core.TableOptions{}
+	deprecations := make(map[string]core.ColumnDeprecation)
+	for _, row := range rows {
+		op := scoop_protocol.Operation{
+			Action:         scoop_protocol.Action(row.action),
+			Name:           row.name,
+			ActionMetadata: row.actionMetadata,
+		}
+		err := ApplyOperation(schema, op)
+		if err == nil {
+			err = applyTableOperation(&tableOpts, op)
+		}
+		if err == nil {
+			err = applyDeprecationOperation(deprecations, op, row.version)
+		}
+		if err != nil {
+			return []Problem{{event, CheckReplay, row.version, row.ordering,
+				fmt.Sprintf("%s %s: %v", row.action, row.name, err)}}
+		}
+	}
+	return nil
+}
+
+// rowHash chains the hash of the previous row of an event with the contents
+// of a row and the name the event had when the row was written, which renames
+// of the event don't change.
+func rowHash(previous string, name string, row operationRow) (string, error) {
+	metadata := []byte("{}")
+	if len(row.actionMetadata) > 0 {
+		var err error
+		metadata, err = json.Marshal(row.actionMetadata)
+		if err != nil {
+			return "", fmt.Errorf("Error marshalling action metadata: %v", err)
+		}
+	}
+	h := sha256.New()
+	_, err := fmt.Fprintf(h, "%s\n%s\n%s\n%s\n%d\n%d\n%s", previous, name, row.action, row.name, row.version, row.ordering, metadata)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// eventNames returns the name the event had when each of its rows was
+// written: the name it was created with, changed by each rename after it
+func eventNames(event string, rows []operationRow) []string {
+	name := event
+	for _, row := range rows {
+		if row.action == string(core.RenameEvent) {
+			name = row.name
+			break
+		}
+	}
+	names := make([]string, 0, len(rows))
+	for _, row := range rows {
+		names = append(names, name)
+		if row.action == string(core.RenameEvent) {
+			name = row.actionMetadata["new_name"]
+		}
+	}
+	return names
+}
+
+// checkHashChain verifies the hashes of the sealed rows of an event, which
+// must come before any unsealed rows, and that the chain still ends at its
+// head if the event was sealed. It returns the number of sealed rows and the
+// hash of the last one.
+func checkHashChain(event string, rows []operationRow, head *chainHead) ([]Problem, int, string, error) {
+	problems := []Problem{}
+	previous, last := "", ""
+	sealed := 0
+	unsealed := false
+	names := eventNames(event, rows)
+	for i, row := range rows {
+		expected, err := rowHash(previous, names[i], row)
+		if err != nil {
+			return nil, 0, "", err
+		}
+		previous = expected
+		if row.hash == "" {
+			unsealed = true
+			continue
+		}
+		sealed++
+		if unsealed {
+			problems = append(problems, Problem{event, CheckHashChain, row.version, row.ordering,
+				"row is sealed but an earlier row isn't, its hash may have been removed"})
+		}
+		if row.hash != expected {
+			problems = append(problems, Problem{event, CheckHashChain, row.version, row.ordering,
+				"hash doesn't match, the row or one before it was changed or removed"})
+		}
+		// continue from the stored hash so only the changed row is reported
+		previous, last = row.hash, row.hash
+	}
+	if head == nil {
+		return problems, sealed, last, nil
+	}
+	// the sealed rows came first when the chain was sealed
+	if len(rows) < head.Rows {
+		problems = append(problems, Problem{event, CheckHashChain, head.Version, head.Ordering,
+			fmt.Sprintf("%d of the %d sealed rows are missing, rows were removed from the end of the log", head.Rows-len(rows), head.Rows)})
+	} else if rows[head.Rows-1].hash != head.Hash {
+		problems = append(problems, Problem{event, CheckHashChain, head.Version, head.Ordering,
+			"row doesn't end the chain it was sealed as the head of"})
+	}
+	return problems, sealed, last, nil
+}
+
+// headOf returns the head of the chain of an event, or nil if it wasn't sealed
+func headOf(heads map[string]chainHead, event string) *chainHead {
+	head, ok := heads[event]
+	if !ok {
+		return nil
+	}
+	return &head
+}
+
+// Fsck replays the operation log of every event, including quarantined ones,
+// and reports gaps in its versions and orderings, operations that can't be
+// applied, sealed rows that don't match the hash chain, and sealed events
+// whose rows were removed
+func Fsck(b Bpdb) (*FsckReport, error) {
+	opLog, err := asOperationLog(b)
+	if err != nil {
+		return nil, err
+	}
+	rows, err := opLog.logRows()
+	if err != nil {
+		return nil, err
+	}
+	quarantined, err := opLog.quarantinedEvents()
+	if err != nil {
+		return nil, err
+	}
+	heads, err := opLog.chainHeads()
+	if err != nil {
+		return nil, err
+	}
+	names, events := rowsByEvent(rows)
+	report := &FsckReport{Events: len(names), Rows: len(rows), Problems: []Problem{}, Quarantined: quarantined}
+	for _, name := range names {
+		report.Problems = append(report.Problems, checkSequence(name, events[name])...)
+		report.Problems = append(report.Problems, checkReplay(name, events[name])...)
+		problems, sealed, _, err := checkHashChain(name, events[name], headOf(heads, name))
+		if err != nil {
+			return nil, err
+		}
+		report.Problems = append(report.Problems, problems...)
+		report.Sealed += sealed
+	}
+	deleted := []string{}
+	for name := range heads {
+		if _, ok := events[name]; !ok {
+			deleted = append(deleted, name)
+		}
+	}
+	sort.Strings(deleted)
+	for _, name := range deleted {
+		head := heads[name]
+		report.Problems = append(report.Problems, Problem{name, CheckHashChain, head.Version, -1,
+			fmt.Sprintf("event was sealed over %d rows but has none, it was deleted", head.Rows)})
+	}
+	return report, nil
+}
+
+// SealHashChain extends the hash chain of each event over its unsealed rows,
+// and stores where the chain ends, so that later changes to them or their
+// removal are reported by Fsck. Events with problems
+// are skipped, so the chain never vouches for a broken opLog. It returns the
+// number of rows sealed.
+func SealHashChain(b Bpdb) (int, error) {
+	opLog, err := asOperationLog(b)
+	if err != nil {
+		return 0, err
+	}
+	rows, err := opLog.logRows()
+	if err != nil {
+		return 0, err
+	}
+	heads, err := opLog.chainHeads()
+	if err != nil {
+		return 0, err
+	}
+	names, events := rowsByEvent(rows)
+	seals := []rowSeal{}
+	newHeads := []chainHead{}
+	for _, name := range names {
+		eventRows := events[name]
+		head := headOf(heads, name)
+		problems, _, previous, err := checkHashChain(name, eventRows, head)
+		if err != nil {
+			return 0, err
+		}
+		problems = append(problems, checkSequence(name, eventRows)...)
+		problems = append(problems, checkReplay(name, eventRows)...)
+		if len(problems) > 0 {
+			continue
+		}
+		eventSeals := []rowSeal{}
+		rowNames := eventNames(name, eventRows)
+		for i, row := range eventRows {
+			if row.hash != "" {
+				continue
+			}
+			previous, err = rowHash(previous, rowNames[i], row)
+			if err != nil {
+				return 0, err
+			}
+			eventSeals = append(eventSeals, rowSeal{Event: row.event, Version: row.version, Ordering: row.ordering, Hash: previous})
+		}
+		if len(eventSeals) == 0 && head != nil {
+			continue
+		}
+		seals = append(seals, eventSeals...)
+		last := eventRows[len(eventRows)-1]
+		newHeads = append(newHeads, chainHead{Event: name, Version: last.version, Ordering: last.ordering,
+			Hash: previous, Rows: len(eventRows)})
+	}
+	if len(newHeads) == 0 {
+		return 0, nil
+	}
+	err = opLog.seal(seals, newHeads)
+	if err != nil {
+		return 0, err
+	}
+	return len(seals), nil
+}
+
+// Quarantine hides the event from every read, including AllSchemas, so that
+// a broken operation log for it doesn't stop the other events from being
+// served. Writes to it fail until it is released.
+func Quarantine(b Bpdb, event string, reason string) error {
+	opLog, err := asOperationLog(b)
+	if err != nil {
+		return err
+	}
+	rows, err := opLog.logRows()
+	if err != nil {
+		return err
+	}
+	for _, row := range rows {
+		if row.event == event {
+			return opLog.quarantine(event, reason)
+		}
+	}
+	return fmt.Errorf("Unable to find schema: %v", event)
+}
+
+// Release serves a quarantined event again, once its operation log is fixed
+func Release(b Bpdb, event string) error {
+	opLog, err := asOperationLog(b)
+	if err != nil {
+		return err
+	}
+	return opLog.release(event)
+}
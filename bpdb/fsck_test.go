@@ -0,0 +1,238 @@
+package bpdb
+
+import (
+	"os"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+// problemChecks returns the check that found each problem, in order
+func problemChecks(report *FsckReport) []string {
+	checks := []string{}
+	for _, p := range report.Problems {
+		checks = append(checks, p.Check)
+	}
+	return checks
+}
+
+func TestFsckQuarantine(t *testing.T) {
+	b := newMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	req := testCreateRequest()
+	req.EventName = "video_pause"
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	report, err := Fsck(b)
+	if err != nil || len(report.Problems) != 0 || report.Events != 2 || report.Rows != 6 {
+		t.Fatalf("Expected a clean operation log, got %+v (err %v).", report, err)
+	}
+
+	// a manual fix skipping an ordering and dropping a column that doesn't exist
+	b.rows = append(b.rows, operationRow{
+		event: "video_pause", action: "delete", name: "seconds",
+		actionMetadata: map[string]string{}, version: 1, ordering: 1,
+	})
+	report, err = Fsck(b)
+	checks := problemChecks(report)
+	if err != nil || len(checks) != 2 || checks[0] != CheckOrderings || checks[1] != CheckReplay {
+		t.Errorf("Expected ordering and replay problems, got %v (err %v).", report.Problems, err)
+	}
+	if broken := report.Broken(); len(broken) != 1 || broken[0] != "video_pause" {
+		t.Errorf("Expected video_pause to be broken, got %v.", broken)
+	}
+	_, err = b.AllSchemas()
+	if err == nil {
+		t.Error("Expected error getting schemas with a broken event.")
+	}
+
+	err = Quarantine(b, "video_pause", "bad import")
+	if err != nil {
+		t.Fatalf("Expected no error quarantining, got %v.", err)
+	}
+	schemas, err := b.AllSchemas()
+	if err != nil || len(schemas) != 1 || schemas[0].EventName != "video_play" {
+		t.Errorf("Expected the other events to be served, got %v (err %v).", schemas, err)
+	}
+	_, err = b.Schema("video_pause")
+	if err == nil {
+		t.Error("Expected quarantined event to be hidden.")
+	}
+	report, err = Fsck(b)
+	if err != nil || len(report.Problems) != 2 || len(report.Broken()) != 0 || report.Quarantined["video_pause"] != "bad import" {
+		t.Errorf("Expected quarantined event to be checked but not broken, got %+v (err %v).", report, err)
+	}
+
+	b.rows = b.rows[:len(b.rows)-1]
+	err = Release(b, "video_pause")
+	if err != nil {
+		t.Fatalf("Expected no error releasing, got %v.", err)
+	}
+	schemas, err = b.AllSchemas()
+	if err != nil || len(schemas) != 2 {
+		t.Errorf("Expected released event to be served, got %v (err %v).", schemas, err)
+	}
+	err = Release(b, "video_pause")
+	if err == nil {
+		t.Error("Expected error releasing event that isn't quarantined.")
+	}
+	err = Quarantine(b, "video_stop", "")
+	if err == nil {
+		t.Error("Expected error quarantining unknown event.")
+	}
+}
+
+func TestFsckHashChain(t *testing.T) {
+	b := newMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	sealed, err := SealHashChain(b)
+	if err != nil || sealed != 3 {
+		t.Fatalf("Expected 3 operations sealed, got %d (err %v).", sealed, err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	report, err := Fsck(b)
+	if err != nil || len(report.Problems) != 0 || report.Sealed != 3 {
+		t.Errorf("Expected a valid chain over 3 operations, got %+v (err %v).", report, err)
+	}
+	sealed, err = SealHashChain(b)
+	if err != nil || sealed != 1 {
+		t.Fatalf("Expected the chain to be extended by 1 operation, got %d (err %v).", sealed, err)
+	}
+
+	// changing a sealed operation breaks the chain at that operation
+	b.rows[1].actionMetadata = map[string]string{"inbound": "channel", "column_type": "varchar", "column_options": "(64)"}
+	report, err = Fsck(b)
+	if checks := problemChecks(report); err != nil || len(checks) != 1 || checks[0] != CheckHashChain || report.Problems[0].Ordering != 1 {
+		t.Errorf("Expected the changed operation to be reported, got %v (err %v).", report.Problems, err)
+	}
+	b.rows[2].hash = ""
+	report, err = Fsck(b)
+	if err != nil || len(report.Problems) != 2 || report.Problems[1].Version != 1 {
+		t.Errorf("Expected removed hash to be reported, got %v (err %v).", report.Problems, err)
+	}
+}
+
+func TestFileBackendQuarantine(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = SealHashChain(b)
+	if err != nil {
+		t.Fatalf("Expected no error sealing, got %v.", err)
+	}
+	err = Quarantine(b, "video_play", "bad import")
+	if err != nil {
+		t.Fatalf("Expected no error quarantining, got %v.", err)
+	}
+	closeFileBackend(t, b)
+
+	b, err = NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file backend: %v", err)
+	}
+	defer closeFileBackend(t, b)
+	report, err := Fsck(b)
+	if err != nil || report.Sealed != 3 || report.Quarantined["video_play"] != "bad import" {
+		t.Errorf("Expected seals and quarantine to be replayed, got %+v (err %v).", report, err)
+	}
+}
+
+func TestFsckHashChainHead(t *testing.T) {
+	b := newMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	req := testCreateRequest()
+	req.EventName = "video_pause"
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "video_start"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+	sealed, err := SealHashChain(b)
+	if err != nil || sealed != 8 {
+		t.Fatalf("Expected 8 operations sealed, got %d (err %v).", sealed, err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_start", NewName: "video_begin"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+	report, err := Fsck(b)
+	if err != nil || len(report.Problems) != 0 || report.Sealed != 8 {
+		t.Fatalf("Expected renames to keep the chain, got %+v (err %v).", report, err)
+	}
+	sealed, err = SealHashChain(b)
+	if err != nil || sealed != 1 {
+		t.Fatalf("Expected the chain to be extended over the rename, got %d (err %v).", sealed, err)
+	}
+
+	// removing the last sealed rows of an event leaves a valid chain behind
+	rows := b.rows
+	b.rows = nil
+	for _, row := range rows {
+		if row.event != "video_begin" || row.version < 3 {
+			b.rows = append(b.rows, row)
+		}
+	}
+	report, err = Fsck(b)
+	if checks := problemChecks(report); err != nil || len(checks) != 1 || checks[0] != CheckHashChain || report.Problems[0].Event != "video_begin" {
+		t.Errorf("Expected the removed rows to be reported, got %v (err %v).", report.Problems, err)
+	}
+	sealed, err = SealHashChain(b)
+	if err != nil || sealed != 0 || b.heads["video_begin"].Rows != 6 {
+		t.Errorf("Expected the truncated event not to be resealed, got %d (err %v).", sealed, err)
+	}
+
+	// so does removing every row of an event
+	b.rows = nil
+	for _, row := range rows {
+		if row.event != "video_pause" {
+			b.rows = append(b.rows, row)
+		}
+	}
+	report, err = Fsck(b)
+	if checks := problemChecks(report); err != nil || len(checks) != 1 || checks[0] != CheckHashChain || report.Problems[0].Event != "video_pause" {
+		t.Errorf("Expected the deleted event to be reported, got %v (err %v).", report.Problems, err)
+	}
+
+	// rows moved to another event don't match its chain
+	b.rows = append([]operationRow(nil), rows...)
+	for i := range b.rows {
+		if b.rows[i].event == "video_pause" {
+			b.rows[i].event = "video_stop"
+		}
+	}
+	b.heads["video_stop"] = b.heads["video_pause"]
+	delete(b.heads, "video_pause")
+	report, err = Fsck(b)
+	if err != nil || len(report.Problems) == 0 || report.Problems[0].Event != "video_stop" {
+		t.Errorf("Expected the moved rows to be reported, got %v (err %v).", report.Problems, err)
+	}
+}
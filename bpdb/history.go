@@ -0,0 +1,189 @@
+package bpdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// AnonymousAuthor is recorded as the author of changes made without authentication
+const AnonymousAuthor = "anonymous"
+
+// Audit records who made a version of a schema, when, and why
+type Audit struct {
+	Author    string
+	Timestamp time.Time
+	Reason    string
+}
+
+// SchemaVersion is a version of a schema along with the operations that
+// migrated it from the previous version
+type SchemaVersion struct {
+	Version    int
+	Operations []scoop_protocol.Operation
+
+	// Sequence is the global sequence number of the version's last operation,
+	// which orders versions across every event
+	Sequence int64 `json:",omitempty"`
+
+	// Audit is nil for versions written before changes were audited
+	Audit *Audit `json:",omitempty"`
+}
+
+// Details is what the versions of a schema say about it as of a version,
+// beyond its columns
+type Details struct {
+	State             string
+	TableOptions      core.TableOptions
+	DeprecatedColumns map[string]core.ColumnDeprecation
+
+	// Audit is the audit record of the version, if it has one
+	Audit *Audit
+}
+
+// versionsWith returns the versions of the schema `name`, read from the
+// primary if the backend doesn't have `version` yet, e.g. when it reads from
+// a replica lagging behind
+func versionsWith(b Bpdb, name string, version int) ([]SchemaVersion, error) {
+	versions, err := b.Versions(name)
+	if err != nil || version == CurrentVersion {
+		return versions, err
+	}
+	if len(versions) == 0 || versions[len(versions)-1].Version < version {
+		return Primary(b).Versions(name)
+	}
+	return versions, nil
+}
+
+// DetailsAtVersion returns the details of the schema `name` as of `version`,
+// which may be CurrentVersion, reading its versions once. It returns nil if
+// the schema has no such version.
+func DetailsAtVersion(b Bpdb, name string, version int) (*Details, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return nil, err
+	}
+	version = resolveVersion(versions, version)
+	if len(versions) == 0 || version < 0 || versions[len(versions)-1].Version < version {
+		return nil, nil
+	}
+	_, opts, err := replayVersions(name, versions, version)
+	if err != nil {
+		return nil, err
+	}
+	deprecations, err := replayDeprecations(versions, version)
+	if err != nil {
+		return nil, err
+	}
+	details := &Details{
+		State:             versionsState(versions, version),
+		TableOptions:      opts,
+		DeprecatedColumns: deprecations,
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			details.Audit = v.Audit
+		}
+	}
+	return details, nil
+}
+
+// OperationsAtVersion returns every operation of version `version` of the
+// schema `name`, including those Migration leaves out, e.g. to generate the
+// DDL of a table options change. It returns nil if the schema has no
+// such version.
+func OperationsAtVersion(b Bpdb, name string, version int) ([]*scoop_protocol.Operation, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range versions {
+		if v.Version == version {
+			ops := make([]*scoop_protocol.Operation, 0, len(v.Operations))
+			for i := range v.Operations {
+				ops = append(ops, &v.Operations[i])
+			}
+			return ops, nil
+		}
+	}
+	return nil, nil
+}
+
+// newAudit returns the audit record for a change being made now
+func newAudit(author string, reason string) Audit {
+	if author == "" {
+		author = AnonymousAuthor
+	}
+	return Audit{
+		Author:    author,
+		Timestamp: time.Now().UTC(),
+		Reason:    reason,
+	}
+}
+
+// addAudits sets the audit record of each version from audits, keyed by version
+func addAudits(versions []SchemaVersion, audits map[int]Audit) {
+	for i := range versions {
+		audit, ok := audits[versions[i].Version]
+		if ok {
+			versions[i].Audit = &audit
+		}
+	}
+}
+
+// rowOperation converts an operation row into the operation it stores
+func rowOperation(row operationRow) scoop_protocol.Operation {
+	return scoop_protocol.Operation{
+		Action:         scoop_protocol.Action(row.action),
+		Name:           row.name,
+		ActionMetadata: copyMetadata(row.actionMetadata),
+	}
+}
+
+// versionsFromRows groups the sorted operation rows of one event by version
+func versionsFromRows(rows []operationRow) []SchemaVersion {
+	versions := []SchemaVersion{}
+	for _, row := range rows {
+		if len(versions) == 0 || versions[len(versions)-1].Version != row.version {
+			versions = append(versions, SchemaVersion{Version: row.version})
+		}
+		last := &versions[len(versions)-1]
+		last.Operations = append(last.Operations, rowOperation(row))
+		if row.sequence > last.Sequence {
+			last.Sequence = row.sequence
+		}
+	}
+	return versions
+}
+
+// schemaAtVersion replays the sorted operation rows of one event up to and
+// including `version`, under the name the event had then. It returns nil if
+// the event has no such version.
+func schemaAtVersion(rows []operationRow, version int) (*scoop_protocol.Config, error) {
+	if len(rows) == 0 || version < 0 || rows[len(rows)-1].version < version {
+		return nil, nil
+	}
+	schema, _, err := replayVersions(rows[0].event, versionsFromRows(rows), version)
+	if err != nil {
+		return nil, fmt.Errorf("Internal state bad - Error generating schemas from operations: %v", err)
+	}
+	// versions without operations aren't reflected in the rows
+	schema.Version = version
+	return schema, nil
+}
+
+// createdName returns the name the event `eventName` was created under. Its
+// operations are stored under its current name, so that is the old name of
+// its first rename, if it was renamed.
+func createdName(eventName string, versions []SchemaVersion) string {
+	for _, v := range versions {
+		for _, op := range v.Operations {
+			if op.Action == core.RenameEvent {
+				return op.Name
+			}
+		}
+	}
+	return eventName
+}
@@ -0,0 +1,129 @@
+package bpdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+func TestVersionsAndSchemaAtVersion(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Renames: core.Renames{"channel": "channel_name"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	versions, err := b.Versions("video_play")
+	if err != nil || len(versions) != 3 {
+		t.Fatalf("Expected 3 versions, got %v (err %v).", versions, err)
+	}
+	for i, v := range versions {
+		if v.Version != i {
+			t.Errorf("Expected version %d at index %d, got %d.", i, i, v.Version)
+		}
+	}
+	if len(versions[0].Operations) != 3 || versions[1].Operations[0].Name != "minutes" {
+		t.Errorf("Unexpected version operations: %v.", versions)
+	}
+
+	schema, err := b.SchemaAtVersion("video_play", 1)
+	if err != nil || schema.Version != 1 || len(schema.Columns) != 2 || schema.Columns[1].OutboundName != "channel" {
+		t.Errorf("Unexpected schema at version 1: %v (err %v).", schema, err)
+	}
+	schema, err = b.SchemaAtVersion("video_play", 3)
+	if err != nil || schema != nil {
+		t.Errorf("Expected no schema at version 3, got %v (err %v).", schema, err)
+	}
+	versions, err = b.Versions("missing")
+	if err != nil || len(versions) != 0 {
+		t.Errorf("Expected no versions of unknown schema, got %v (err %v).", versions, err)
+	}
+}
+
+func TestVersionsAudit(t *testing.T) {
+	b := NewMemoryBackend()
+	create := testCreateRequest()
+	create.Author = "alice"
+	create.Reason = "new event"
+	_, err := b.CreateSchema(create)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	versions, err := b.Versions("video_play")
+	if err != nil || len(versions) != 2 {
+		t.Fatalf("Expected 2 versions, got %v (err %v).", versions, err)
+	}
+	audit := versions[0].Audit
+	if audit == nil || audit.Author != "alice" || audit.Reason != "new event" || audit.Timestamp.IsZero() {
+		t.Errorf("Unexpected audit for version 0: %v.", audit)
+	}
+	audit = versions[1].Audit
+	if audit == nil || audit.Author != AnonymousAuthor || audit.Reason != "" {
+		t.Errorf("Expected anonymous audit for version 1, got %v.", audit)
+	}
+}
+
+// countingBackend counts the calls to Versions
+type countingBackend struct {
+	Bpdb
+	versionsCalls int
+}
+
+func (b *countingBackend) Versions(name string) ([]SchemaVersion, error) {
+	b.versionsCalls++
+	return b.Bpdb.Versions(name)
+}
+
+func TestDetailsAtVersion(t *testing.T) {
+	b := &countingBackend{Bpdb: NewMemoryBackend()}
+	create := testCreateRequest()
+	create.Draft = true
+	create.Author = "alice"
+	_, err := b.CreateSchema(create)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	until := time.Now().Add(time.Hour)
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		Deprecations: []core.Deprecation{{OutboundName: "minutes", Until: &until}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error deprecating column, got %v.", err)
+	}
+
+	b.versionsCalls = 0
+	details, err := DetailsAtVersion(b, "video_play", CurrentVersion)
+	if err != nil || details == nil {
+		t.Fatalf("Expected details of current version, got %v (err %v).", details, err)
+	}
+	if b.versionsCalls != 1 {
+		t.Errorf("Expected versions to be read once, read %d times.", b.versionsCalls)
+	}
+	if details.State != core.StateDraft || len(details.DeprecatedColumns) != 1 ||
+		details.Audit == nil || details.Audit.Author != AnonymousAuthor {
+		t.Errorf("Unexpected details of current version: %+v.", details)
+	}
+	details, err = DetailsAtVersion(b, "video_play", 0)
+	if err != nil || details == nil || len(details.DeprecatedColumns) != 0 || details.Audit.Author != "alice" {
+		t.Errorf("Unexpected details of version 0: %+v (err %v).", details, err)
+	}
+	details, err = DetailsAtVersion(b, "video_play", 2)
+	if err != nil || details != nil {
+		t.Errorf("Expected no details of missing version, got %+v (err %v).", details, err)
+	}
+}
@@ -0,0 +1,146 @@
+package bpdb
+
+import (
+	"fmt"
+
+	"github.com/twitchscience/aws_utils/logger"
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// LiveStates are the lifecycle states of the events ingesters see
+var LiveStates = []string{core.StatePublished, core.StateDeprecated}
+
+// EventSchema is the current schema of an event along with its lifecycle state
+type EventSchema struct {
+	scoop_protocol.Config
+	State string
+}
+
+// inStates reports whether state is one of states, or true if none are given
+func inStates(state string, states []string) bool {
+	if len(states) == 0 {
+		return true
+	}
+	for _, s := range states {
+		if s == state {
+			return true
+		}
+	}
+	return false
+}
+
+// generateEventSchemas creates schemas from a list of operations like
+// generateSchemas, along with the lifecycle state of each event, keeping the
+// events in one of `states`, or every event if none are given
+func generateEventSchemas(ops []operationRow, states []string) ([]EventSchema, error) {
+	cfgs, err := generateSchemas(ops)
+	if err != nil {
+		return nil, err
+	}
+	eventStates := make(map[string]string)
+	for _, op := range ops {
+		if op.action == string(core.SetState) {
+			eventStates[op.event] = op.actionMetadata["state"]
+		}
+	}
+	schemas := make([]EventSchema, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		state, ok := eventStates[cfg.EventName]
+		if !ok {
+			state = core.StatePublished
+		}
+		if inStates(state, states) {
+			schemas = append(schemas, EventSchema{Config: cfg, State: state})
+		}
+	}
+	return schemas, nil
+}
+
+// liveConfigs returns the schemas of the events ingesters see
+func liveConfigs(schemas []EventSchema) []scoop_protocol.Config {
+	cfgs := make([]scoop_protocol.Config, 0, len(schemas))
+	for _, schema := range schemas {
+		if inStates(schema.State, LiveStates) {
+			cfgs = append(cfgs, schema.Config)
+		}
+	}
+	return cfgs
+}
+
+// versionsState returns the lifecycle state of an event as of `version`.
+// Events are published unless created as drafts or their state was changed.
+func versionsState(versions []SchemaVersion, version int) string {
+	state := core.StatePublished
+	for _, v := range versions {
+		if v.Version > version {
+			break
+		}
+		for _, op := range v.Operations {
+			if op.Action == core.SetState {
+				state = op.ActionMetadata["state"]
+			}
+		}
+	}
+	return state
+}
+
+// StateAtVersion returns the lifecycle state of the event `name` as of
+// `version`, which may be CurrentVersion
+func StateAtVersion(b Bpdb, name string, version int) (string, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return "", err
+	}
+	version = resolveVersion(versions, version)
+	if len(versions) == 0 || version < 0 || versions[len(versions)-1].Version < version {
+		return "", fmt.Errorf("Unable to find schema: %v", name)
+	}
+	return versionsState(versions, version), nil
+}
+
+// preValidateState returns the lifecycle state of the event being changed.
+// Retired events can't be changed, and changes to deprecated events are
+// logged.
+func preValidateState(bpdb Bpdb, eventName string) (string, error) {
+	state, err := StateAtVersion(bpdb, eventName, CurrentVersion)
+	if err != nil {
+		return "", fmt.Errorf("error getting state of event: %v", err)
+	}
+	switch state {
+	case core.StateRetired:
+		return "", invalidf("event %s is retired and can't be changed", eventName)
+	case core.StateDeprecated:
+		logger.WithField("event_name", eventName).Warn("Changing deprecated event")
+	}
+	return state, nil
+}
+
+// preValidateSetState validates moving the event to another lifecycle state,
+// returning the operation setting it and the version of the schema it was
+// validated against
+func preValidateSetState(req *core.ClientSetEventStateRequest, bpdb Bpdb) ([]scoop_protocol.Operation, int, error) {
+	versions, err := bpdb.Versions(req.EventName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting versions to validate state change: %v", err)
+	}
+	if len(versions) == 0 {
+		return nil, 0, fmt.Errorf("Unable to find schema: %v", req.EventName)
+	}
+	schema, err := bpdb.Schema(req.EventName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting schema to validate state change: %v", err)
+	}
+	if schema.EventName != req.EventName {
+		return nil, 0, invalidf("event %s was renamed to %s", req.EventName, schema.EventName)
+	}
+	currentVersion := versions[len(versions)-1].Version
+	if req.BaseVersion != nil && *req.BaseVersion != currentVersion {
+		return nil, 0, ErrVersionConflict
+	}
+	err = core.ValidateStateTransition(versionsState(versions, currentVersion), req.State)
+	if err != nil {
+		return nil, 0, &ValidationError{Err: err}
+	}
+	return []scoop_protocol.Operation{core.NewSetStateOperation(req.State)}, currentVersion, nil
+}
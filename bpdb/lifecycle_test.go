@@ -0,0 +1,133 @@
+package bpdb
+
+import (
+	"testing"
+
+	"github.com/twitchscience/blueprint/compatibility"
+	"github.com/twitchscience/blueprint/core"
+)
+
+func TestEventLifecycle(t *testing.T) {
+	defer usePolicy(compatibility.Full)()
+	b := NewMemoryBackend()
+	req := testCreateRequest()
+	req.Draft = true
+	_, err := b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating draft, got %v.", err)
+	}
+	schemas, err := b.AllSchemas()
+	if err != nil || len(schemas) != 0 {
+		t.Errorf("Expected drafts to be hidden from ingesters, got %v (err %v).", schemas, err)
+	}
+
+	// drafts can be changed freely
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error changing draft, got %v.", err)
+	}
+	ops, err := b.Migration("video_play", 1)
+	if err != nil || len(ops) != 0 {
+		t.Errorf("Expected no migration while a draft, got %v (err %v).", ops, err)
+	}
+
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StateDeprecated})
+	if err == nil {
+		t.Error("Expected error deprecating a draft.")
+	}
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StatePublished, WriteOptions: core.WriteOptions{Author: "alice"}})
+	if err != nil {
+		t.Fatalf("Expected no error publishing, got %v.", err)
+	}
+	schemas, err = b.AllSchemas()
+	if err != nil || len(schemas) != 1 || len(schemas[0].Columns) != 2 || schemas[0].Version != 2 {
+		t.Errorf("Expected published schema to be visible, got %v (err %v).", schemas, err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"channel"}})
+	if err == nil {
+		t.Error("Expected published event to be checked for compatibility.")
+	}
+
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StateRetired})
+	if err != nil {
+		t.Fatalf("Expected no error retiring, got %v.", err)
+	}
+	schemas, err = b.AllSchemas()
+	if err != nil || len(schemas) != 0 {
+		t.Errorf("Expected retired event to be hidden from ingesters, got %v (err %v).", schemas, err)
+	}
+	versions, err := b.Versions("video_play")
+	if err != nil || len(versions) != 4 {
+		t.Errorf("Expected retired event to keep its history, got %v (err %v).", versions, err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}}})
+	if err == nil {
+		t.Error("Expected error changing a retired event.")
+	}
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StatePublished})
+	if err == nil {
+		t.Error("Expected error publishing a retired event.")
+	}
+}
+
+func TestEventSchemasByState(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	req := testCreateRequest()
+	req.EventName = "draft_event"
+	req.Draft = true
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating draft, got %v.", err)
+	}
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_play", State: core.StateDeprecated})
+	if err != nil {
+		t.Fatalf("Expected no error deprecating, got %v.", err)
+	}
+
+	schemas, err := b.EventSchemas()
+	if err != nil || len(schemas) != 2 {
+		t.Errorf("Expected every event without a filter, got %v (err %v).", schemas, err)
+	}
+	schemas, err = b.EventSchemas(core.StateDraft)
+	if err != nil || len(schemas) != 1 || schemas[0].EventName != "draft_event" || schemas[0].State != core.StateDraft {
+		t.Errorf("Expected only the draft, got %v (err %v).", schemas, err)
+	}
+	cfgs, err := b.AllSchemas()
+	if err != nil || len(cfgs) != 1 || cfgs[0].EventName != "video_play" {
+		t.Errorf("Expected deprecated events to stay visible to ingesters, got %v (err %v).", cfgs, err)
+	}
+	state, err := StateAtVersion(b, "video_play", 0)
+	if err != nil || state != core.StatePublished {
+		t.Errorf("Expected video_play to be published at version 0, got %s (err %v).", state, err)
+	}
+}
+
+func TestValidateStateTransition(t *testing.T) {
+	valid := [][2]string{
+		{core.StateDraft, core.StatePublished},
+		{core.StateDraft, core.StateRetired},
+		{core.StatePublished, core.StateDeprecated},
+		{core.StateDeprecated, core.StatePublished},
+		{core.StateDeprecated, core.StateRetired},
+	}
+	for _, transition := range valid {
+		if err := core.ValidateStateTransition(transition[0], transition[1]); err != nil {
+			t.Errorf("Expected %s to %s to be valid, got %v.", transition[0], transition[1], err)
+		}
+	}
+	invalid := [][2]string{
+		{core.StatePublished, core.StateDraft},
+		{core.StatePublished, core.StatePublished},
+		{core.StateRetired, core.StatePublished},
+		{core.StatePublished, "archived"},
+	}
+	for _, transition := range invalid {
+		if err := core.ValidateStateTransition(transition[0], transition[1]); err == nil {
+			t.Errorf("Expected %s to %s to be invalid.", transition[0], transition[1])
+		}
+	}
+}
@@ -0,0 +1,612 @@
+package bpdb
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+type memoryBackend struct {
+	lock sync.RWMutex
+	rows []operationRow
+
+	// idempotencyKeys maps the idempotency key of each write to its event and
+	// request hash
+	idempotencyKeys map[string]idempotentWrite
+
+	// audits maps each event to the audit record of each of its versions
+	audits map[string]map[int]Audit
+
+	// aliases maps the old names of renamed events to their current names
+	aliases map[string]string
+
+	// sequence is the sequence number of the last operation applied
+	sequence int64
+
+	// quarantined maps each event fsck quarantined to the reason for it
+	quarantined map[string]string
+
+	// heads maps each event fsck sealed to the head of its hash chain
+	heads map[string]chainHead
+
+	// journal persists each transaction before it is applied, if set
+	journal *fileJournal
+}
+
+// idempotentWrite is the write an idempotency key was used for
+type idempotentWrite struct {
+	event       string
+	requestHash string
+}
+
+// memoryTransaction is the set of changes made by a single write
+type memoryTransaction struct {
+	event       string
+	version     int
+	rows        []operationRow
+	idempotency idempotency
+	audit       Audit
+
+	// renamedFrom is the old name of the event if the transaction renames it
+	renamedFrom string
+
+	// repair is set instead of rows for a repair of the log by fsck
+	repair *logRepair
+}
+
+// logRepair is a change fsck makes to the operation log rather than a write
+type logRepair struct {
+	// Quarantined maps each event to quarantine to the reason for it
+	Quarantined map[string]string `json:"quarantined,omitempty"`
+	Released    []string          `json:"released,omitempty"`
+	Seals       []rowSeal         `json:"seals,omitempty"`
+	Heads       []chainHead       `json:"heads,omitempty"`
+}
+
+// byVersionOrdering sorts operation rows the same way the postgres queries do
+type byVersionOrdering []operationRow
+
+func (a byVersionOrdering) Len() int      { return len(a) }
+func (a byVersionOrdering) Swap(i, j int) { a[i], a[j] = a[j], a[i] }
+func (a byVersionOrdering) Less(i, j int) bool {
+	if a[i].version != a[j].version {
+		return a[i].version < a[j].version
+	}
+	return a[i].ordering < a[j].ordering
+}
+
+// NewMemoryBackend creates a bpdb backend that keeps the operation log in
+// memory. Nothing is persisted, so it is only suitable for tests and local
+// development.
+func NewMemoryBackend() Bpdb {
+	return newMemoryBackend()
+}
+
+func newMemoryBackend() *memoryBackend {
+	return &memoryBackend{
+		idempotencyKeys: make(map[string]idempotentWrite),
+		audits:          make(map[string]map[int]Audit),
+		aliases:         make(map[string]string),
+		quarantined:     make(map[string]string),
+		heads:           make(map[string]chainHead),
+	}
+}
+
+// copyMetadata copies action metadata so callers can't mutate the stored log
+func copyMetadata(metadata map[string]string) map[string]string {
+	c := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		c[k] = v
+	}
+	return c
+}
+
+// selectRows returns a sorted copy of the rows matching the filter, leaving
+// out quarantined events. The caller must hold the lock.
+func (m *memoryBackend) selectRows(filter func(operationRow) bool) []operationRow {
+	rows := []operationRow{}
+	for _, row := range m.rows {
+		if _, ok := m.quarantined[row.event]; !ok && filter(row) {
+			rows = append(rows, row)
+		}
+	}
+	sort.Stable(byVersionOrdering(rows))
+	return rows
+}
+
+// newTransaction builds the transaction inserting the operations for
+// eventName at the given version
+func newTransaction(ops []scoop_protocol.Operation, version int, eventName string, id idempotency, audit Audit) *memoryTransaction {
+	txn := &memoryTransaction{
+		event:       eventName,
+		version:     version,
+		rows:        make([]operationRow, 0, len(ops)),
+		idempotency: id,
+		audit:       audit,
+	}
+	for i, op := range ops {
+		txn.rows = append(txn.rows, operationRow{
+			event:          eventName,
+			action:         string(op.Action),
+			name:           op.Name,
+			actionMetadata: copyMetadata(op.ActionMetadata),
+			version:        version,
+			ordering:       i,
+		})
+	}
+	return txn
+}
+
+// commit journals the transaction, if there is a journal, and then applies
+// it; either all of its changes are stored or none are. The caller must hold
+// the write lock.
+func (m *memoryBackend) commit(txn *memoryTransaction) error {
+	if m.journal != nil {
+		err := m.journal.append(txn)
+		if err != nil {
+			return fmt.Errorf("Error writing transaction for %s to journal: %v", txn.event, err)
+		}
+	}
+	m.apply(txn)
+	return nil
+}
+
+// apply applies a committed transaction. The caller must hold the write lock.
+func (m *memoryBackend) apply(txn *memoryTransaction) {
+	if txn.repair != nil {
+		m.applyRepair(txn.repair)
+		return
+	}
+	if txn.renamedFrom != "" {
+		m.renameEvent(txn.renamedFrom, txn.event)
+	}
+	for _, row := range txn.rows {
+		m.sequence++
+		row.sequence = m.sequence
+		m.rows = append(m.rows, row)
+	}
+	if txn.idempotency.key != "" {
+		m.idempotencyKeys[txn.idempotency.key] = idempotentWrite{event: txn.event, requestHash: txn.idempotency.requestHash}
+	}
+	if m.audits[txn.event] == nil {
+		m.audits[txn.event] = make(map[int]Audit)
+	}
+	m.audits[txn.event][txn.version] = txn.audit
+}
+
+// applyRepair applies a repair of the log. The caller must hold the write lock.
+func (m *memoryBackend) applyRepair(repair *logRepair) {
+	for event, reason := range repair.Quarantined {
+		m.quarantined[event] = reason
+	}
+	for _, event := range repair.Released {
+		delete(m.quarantined, event)
+	}
+	for _, head := range repair.Heads {
+		m.heads[head.Event] = head
+	}
+	if len(repair.Seals) == 0 {
+		return
+	}
+	hashes := make(map[rowSeal]string, len(repair.Seals))
+	for _, seal := range repair.Seals {
+		hashes[rowSeal{Event: seal.Event, Version: seal.Version, Ordering: seal.Ordering}] = seal.Hash
+	}
+	for i, row := range m.rows {
+		if hash, ok := hashes[rowSeal{Event: row.event, Version: row.version, Ordering: row.ordering}]; ok {
+			m.rows[i].hash = hash
+		}
+	}
+}
+
+// renameEvent moves everything stored for the event `from` to `to`, and makes
+// `from` an alias of `to`. The caller must hold the write lock.
+func (m *memoryBackend) renameEvent(from string, to string) {
+	for i := range m.rows {
+		if m.rows[i].event == from {
+			m.rows[i].event = to
+		}
+	}
+	m.audits[to] = m.audits[from]
+	delete(m.audits, from)
+	if reason, ok := m.quarantined[from]; ok {
+		m.quarantined[to] = reason
+		delete(m.quarantined, from)
+	}
+	if head, ok := m.heads[from]; ok {
+		head.Event = to
+		m.heads[to] = head
+		delete(m.heads, from)
+	}
+	for key, write := range m.idempotencyKeys {
+		if write.event == from {
+			m.idempotencyKeys[key] = idempotentWrite{event: to, requestHash: write.requestHash}
+		}
+	}
+	for alias, event := range m.aliases {
+		if event == from {
+			m.aliases[alias] = to
+		}
+	}
+	delete(m.aliases, to)
+	m.aliases[from] = to
+}
+
+// resolveAlias returns the current name of the event `name`, which is an old
+// name if the event was renamed. The caller must hold the lock.
+func (m *memoryBackend) resolveAlias(name string) string {
+	if event, ok := m.aliases[name]; ok {
+		return event
+	}
+	return name
+}
+
+// alreadyApplied reports whether the request to eventName with the
+// idempotency key was already committed. The caller must hold the lock.
+func (m *memoryBackend) alreadyApplied(id idempotency, eventName string) (bool, error) {
+	if id.key == "" {
+		return false, nil
+	}
+	write, found := m.idempotencyKeys[id.key]
+	if !found {
+		return false, nil
+	}
+	if id.reused(write.event, write.requestHash, eventName) {
+		return false, ErrIdempotencyKeyReused
+	}
+	return true, nil
+}
+
+// currentVersion returns the latest version of eventName, or -1 if it has no
+// operations. The caller must hold the lock.
+func (m *memoryBackend) currentVersion(eventName string) int {
+	version := -1
+	for _, row := range m.rows {
+		if row.event == eventName {
+			version = max(version, row.version)
+		}
+	}
+	return version
+}
+
+// Migration returns the operations necessary to migration `table` from version `to -1` to version `to`,
+// which are none while the event is a draft. Only actions in migrationActions are returned.
+func (m *memoryBackend) Migration(table string, to int) ([]*scoop_protocol.Operation, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	table = m.resolveAlias(table)
+	if versionsState(versionsFromRows(m.eventRows(table)), to) == core.StateDraft {
+		return []*scoop_protocol.Operation{}, nil
+	}
+	rows := m.selectRows(func(row operationRow) bool {
+		return row.event == table && row.version == to
+	})
+	ops := make([]*scoop_protocol.Operation, 0, len(rows))
+	for _, row := range rows {
+		op := rowOperation(row)
+		ops = append(ops, &op)
+	}
+	return migrationOperations(ops), nil
+}
+
+// eventRows returns the operation rows of the table `name`, which may be an
+// alias, in order. The caller must hold the lock.
+func (m *memoryBackend) eventRows(name string) []operationRow {
+	name = m.resolveAlias(name)
+	return m.selectRows(func(row operationRow) bool {
+		return row.event == name
+	})
+}
+
+// Versions returns every version of the table `name` with the operations
+// that migrated it to that version
+func (m *memoryBackend) Versions(name string) ([]SchemaVersion, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	versions := versionsFromRows(m.eventRows(name))
+	addAudits(versions, m.audits[m.resolveAlias(name)])
+	return versions, nil
+}
+
+// SchemaAtVersion returns the schema for the table `name` as of `version`,
+// or nil if there is no such version
+func (m *memoryBackend) SchemaAtVersion(name string, version int) (*scoop_protocol.Config, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+	return schemaAtVersion(m.eventRows(name), version)
+}
+
+// CreateSchema validates that the creation operation is valid and if so, stores
+// the schema as 'add' operations in the log
+func (m *memoryBackend) CreateSchema(req *core.ClientCreateSchemaRequest) (lint.Findings, error) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := m.alreadyApplied(id, req.EventName)
+	if applied || err != nil {
+		return nil, err
+	}
+	var warnings lint.Findings
+	err = resolveCreateOptions(req)
+	if err == nil {
+		warnings, err = preValidateSchema(&req.Config, req.TableOptions)
+	}
+	if err != nil {
+		return nil, invalidRequest("schema creation", err)
+	}
+	if _, isAlias := m.aliases[req.EventName]; isAlias || m.currentVersion(req.EventName) >= 0 {
+		return nil, ErrSchemaExists
+	}
+
+	ops := schemaCreateRequestToOps(req)
+	return warnings, m.commit(newTransaction(ops, 0, req.EventName, id, newAudit(req.Author, req.Reason)))
+}
+
+// UpdateSchema validates that the update operation is valid and if so, stores
+// the operations for this migration to the schema in the log. It applies the
+// operations in order of deprecation, delete, type change, remap, add, then
+// renames, unless the request gives its operations in order.
+func (m *memoryBackend) UpdateSchema(req *core.ClientUpdateSchemaRequest) (lint.Findings, error) {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	m.lock.RLock()
+	applied, err := m.alreadyApplied(id, req.EventName)
+	m.lock.RUnlock()
+	if applied || err != nil {
+		return nil, err
+	}
+
+	validatedVersion, warnings, err := preValidateUpdate(req, m)
+	if err == ErrVersionConflict {
+		return nil, err
+	} else if err != nil {
+		return nil, invalidRequest("schema creation", err)
+	}
+
+	ops := schemaUpdateRequestToOps(req)
+	return warnings, m.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
+}
+
+// insertVersion stores the operations as the version after validatedVersion,
+// unless the schema was changed since it was validated
+func (m *memoryBackend) insertVersion(eventName string, ops []scoop_protocol.Operation, validatedVersion int, id idempotency, audit Audit) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	applied, err := m.alreadyApplied(id, eventName)
+	if applied || err != nil {
+		return err
+	}
+	currentVersion := m.currentVersion(eventName)
+	if currentVersion < 0 {
+		return fmt.Errorf("Error finding version number for %s: no operations found.", eventName)
+	}
+	if currentVersion != validatedVersion {
+		return ErrVersionConflict
+	}
+	return m.commit(newTransaction(ops, currentVersion+1, eventName, id, audit))
+}
+
+// RevertSchema validates that reverting the schema is valid and if so, stores
+// the operations undoing every change since the target version as a new
+// version. It returns the operations, which are not stored on a dry run.
+func (m *memoryBackend) RevertSchema(req *core.ClientRevertSchemaRequest) ([]scoop_protocol.Operation, lint.Findings, error) {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	m.lock.RLock()
+	applied, err := m.alreadyApplied(id, req.EventName)
+	m.lock.RUnlock()
+	if applied || err != nil {
+		return nil, nil, err
+	}
+
+	ops, validatedVersion, warnings, err := preValidateRevert(req, m)
+	if err == ErrVersionConflict {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, invalidRequest("schema revert", err)
+	}
+	if req.DryRun {
+		return ops, warnings, nil
+	}
+	return ops, warnings, m.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
+}
+
+// RenameEvent validates that renaming the event is valid and if so, moves
+// its history to the new name, stores the rename as a new version, and keeps
+// the old name as an alias
+func (m *memoryBackend) RenameEvent(req *core.ClientRenameEventRequest) error {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	m.lock.RLock()
+	applied, err := m.alreadyApplied(id, req.NewName)
+	m.lock.RUnlock()
+	if applied || err != nil {
+		return err
+	}
+
+	ops, validatedVersion, err := preValidateRenameEvent(req, m)
+	if err == ErrVersionConflict {
+		return err
+	} else if err != nil {
+		return invalidRequest("event rename", err)
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	applied, err = m.alreadyApplied(id, req.NewName)
+	if applied || err != nil {
+		return err
+	}
+	if event, isAlias := m.aliases[req.NewName]; (isAlias && event != req.EventName) || m.currentVersion(req.NewName) >= 0 {
+		return ErrSchemaExists
+	}
+	currentVersion := m.currentVersion(req.EventName)
+	if currentVersion != validatedVersion {
+		return ErrVersionConflict
+	}
+	txn := newTransaction(ops, currentVersion+1, req.NewName, id, newAudit(req.Author, req.Reason))
+	txn.renamedFrom = req.EventName
+	return m.commit(txn)
+}
+
+// SetEventState validates that moving the event to another lifecycle state
+// is valid and if so, stores the change as a new version
+func (m *memoryBackend) SetEventState(req *core.ClientSetEventStateRequest) error {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	m.lock.RLock()
+	applied, err := m.alreadyApplied(id, req.EventName)
+	m.lock.RUnlock()
+	if applied || err != nil {
+		return err
+	}
+
+	ops, validatedVersion, err := preValidateSetState(req, m)
+	if err == ErrVersionConflict {
+		return err
+	} else if err != nil {
+		return invalidRequest("event state", err)
+	}
+	return m.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
+}
+
+// Schema returns the current schema for the table `name`
+func (m *memoryBackend) Schema(name string) (*scoop_protocol.Config, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	schemas, err := generateSchemas(m.eventRows(name))
+	if err != nil {
+		return nil, fmt.Errorf("Internal state bad - Error generating schemas from operations: %v", err)
+	}
+	if len(schemas) > 1 {
+		return nil, fmt.Errorf("Expected only one schema, received %v.", len(schemas))
+	}
+	if len(schemas) == 0 {
+		return nil, fmt.Errorf("Unable to find schema: %v", name)
+	}
+	return &schemas[0], nil
+}
+
+// SchemasAsOf returns the schemas of the published and deprecated events as
+// they were once the operation numbered `sequence` was committed
+func (m *memoryBackend) SchemasAsOf(sequence int64) ([]scoop_protocol.Config, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return schemasAsOf(m.selectRows(func(operationRow) bool { return true }), sequence)
+}
+
+// SequenceAt returns the sequence number of the last operation committed at
+// or before `t`, or 0 if there is none. Operations without an audit record
+// count as committed before any `t`.
+func (m *memoryBackend) SequenceAt(t time.Time) (int64, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	var sequence int64
+	for _, row := range m.rows {
+		audit, ok := m.audits[row.event][row.version]
+		if (!ok || !audit.Timestamp.After(t)) && row.sequence > sequence {
+			sequence = row.sequence
+		}
+	}
+	return sequence, nil
+}
+
+// AllSchemas returns the current schemas of the published and deprecated events
+func (m *memoryBackend) AllSchemas() ([]scoop_protocol.Config, error) {
+	schemas, err := m.EventSchemas(LiveStates...)
+	if err != nil {
+		return nil, err
+	}
+	return liveConfigs(schemas), nil
+}
+
+// EventSchemas returns the current schemas of the events in one of `states`,
+// or of every event if none are given
+func (m *memoryBackend) EventSchemas(states ...string) ([]EventSchema, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	return generateEventSchemas(m.selectRows(func(operationRow) bool { return true }), states)
+}
+
+// currentVersions returns the current version of every event that isn't
+// quarantined
+func (m *memoryBackend) currentVersions() (map[string]int, error) {
+	schemas, err := m.EventSchemas()
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[string]int, len(schemas))
+	for _, schema := range schemas {
+		versions[schema.EventName] = schema.Version
+	}
+	return versions, nil
+}
+
+// logRows returns every operation row, including those of quarantined events
+func (m *memoryBackend) logRows() ([]operationRow, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	rows := append([]operationRow(nil), m.rows...)
+	sort.Stable(byVersionOrdering(rows))
+	return rows, nil
+}
+
+// quarantinedEvents returns each quarantined event and the reason for it
+func (m *memoryBackend) quarantinedEvents() (map[string]string, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	quarantined := make(map[string]string, len(m.quarantined))
+	for event, reason := range m.quarantined {
+		quarantined[event] = reason
+	}
+	return quarantined, nil
+}
+
+// quarantine hides the event from every read
+func (m *memoryBackend) quarantine(event string, reason string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.commit(&memoryTransaction{event: event, repair: &logRepair{Quarantined: map[string]string{event: reason}}})
+}
+
+// release serves a quarantined event again
+func (m *memoryBackend) release(event string) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	if _, ok := m.quarantined[event]; !ok {
+		return fmt.Errorf("event %s is not quarantined", event)
+	}
+	return m.commit(&memoryTransaction{event: event, repair: &logRepair{Released: []string{event}}})
+}
+
+// chainHeads returns the head of the hash chain of each sealed event
+func (m *memoryBackend) chainHeads() (map[string]chainHead, error) {
+	m.lock.RLock()
+	defer m.lock.RUnlock()
+
+	heads := make(map[string]chainHead, len(m.heads))
+	for event, head := range m.heads {
+		heads[event] = head
+	}
+	return heads, nil
+}
+
+// seal stores the hashes chaining the rows of each event and the heads of
+// the chains
+func (m *memoryBackend) seal(seals []rowSeal, heads []chainHead) error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	return m.commit(&memoryTransaction{repair: &logRepair{Seals: seals, Heads: heads}})
+}
@@ -0,0 +1,539 @@
+package bpdb
+
+import (
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/blueprint/transformers"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func testCreateRequest() *core.ClientCreateSchemaRequest {
+	return &core.ClientCreateSchemaRequest{Config: scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+			{InboundName: "minutes", OutboundName: "minutes", Transformer: "bigint", ColumnCreationOptions: ""},
+		},
+	}}
+}
+
+func TestMemoryBackendCreateAndUpdate(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}},
+		Deletes:   []string{"minutes"},
+		Renames:   core.Renames{"channel": "channel_name"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	expected := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "channel", OutboundName: "channel_name", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+			{InboundName: "os", OutboundName: "os", Transformer: "varchar", ColumnCreationOptions: "(16)"},
+		},
+		Version: 1,
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || !reflect.DeepEqual(expected, schema) {
+		t.Errorf("Results schema differs from expected (err %v):\n%v\nvs\n%v.", err, schema, expected)
+	}
+
+	ops, err := b.Migration("video_play", 1)
+	if err != nil {
+		t.Fatalf("Expected no error getting migration, got %v.", err)
+	}
+	expectedOps := []*scoop_protocol.Operation{
+		{Action: scoop_protocol.DELETE, Name: "minutes", ActionMetadata: map[string]string{}},
+		{Action: scoop_protocol.ADD, Name: "os", ActionMetadata: map[string]string{"inbound": "os", "column_type": "varchar", "column_options": "(16)"}},
+		{Action: scoop_protocol.RENAME, Name: "channel", ActionMetadata: map[string]string{"new_outbound": "channel_name"}},
+	}
+	if !reflect.DeepEqual(expectedOps, ops) {
+		t.Errorf("Migration differs from expected:\n%v\nvs\n%v.", ops, expectedOps)
+	}
+}
+
+func TestMemoryBackendUnknownSchema(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.Schema("missing")
+	if err == nil {
+		t.Error("Expected error getting unknown schema.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "missing", Deletes: []string{"a"}})
+	if err == nil {
+		t.Error("Expected error updating unknown schema.")
+	}
+}
+
+func TestMemoryBackendInvalidUpdateNotStored(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"time"}})
+	if err == nil {
+		t.Error("Expected error deleting sortkey column.")
+	}
+	ops, err := b.Migration("video_play", 1)
+	if err != nil || len(ops) != 0 {
+		t.Errorf("Expected no migration to v1, got %v (err %v).", ops, err)
+	}
+}
+
+func TestMemoryBackendConcurrentUpdates(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	var wg sync.WaitGroup
+	for _, name := range []string{"a", "b", "c", "d", "e"} {
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			err := ErrVersionConflict
+			for err == ErrVersionConflict {
+				_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+					EventName: "video_play",
+					Additions: []core.Column{{InboundName: name, OutboundName: name, Transformer: "bigint"}},
+				})
+			}
+			if err != nil {
+				t.Errorf("Expected no error adding %s, got %v.", name, err)
+			}
+		}(name)
+	}
+	wg.Wait()
+
+	schemas, err := b.AllSchemas()
+	if err != nil || len(schemas) != 1 {
+		t.Fatalf("Expected one schema, got %v (err %v).", schemas, err)
+	}
+	if schemas[0].Version != 5 || len(schemas[0].Columns) != 8 {
+		t.Errorf("Expected version 5 with 8 columns, got %v.", schemas[0])
+	}
+}
+
+func TestMemoryBackendCreateExisting(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != ErrSchemaExists {
+		t.Errorf("Expected ErrSchemaExists creating existing schema, got %v.", err)
+	}
+}
+
+func TestMemoryBackendBaseVersion(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	baseVersion := 0
+	req := &core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}, WriteOptions: core.WriteOptions{BaseVersion: &baseVersion}}
+	_, err = b.UpdateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error updating schema at base version, got %v.", err)
+	}
+	req = &core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"channel"}, WriteOptions: core.WriteOptions{BaseVersion: &baseVersion}}
+	_, err = b.UpdateSchema(req)
+	if err != ErrVersionConflict {
+		t.Errorf("Expected ErrVersionConflict updating stale version, got %v.", err)
+	}
+}
+
+func TestMemoryBackendIdempotencyKey(t *testing.T) {
+	b := NewMemoryBackend()
+	create := testCreateRequest()
+	create.IdempotencyKey = "create-key"
+	for i := 0; i < 2; i++ {
+		_, err := b.CreateSchema(create)
+		if err != nil {
+			t.Fatalf("Expected no error on attempt %d creating schema, got %v.", i, err)
+		}
+	}
+
+	update := &core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}, WriteOptions: core.WriteOptions{IdempotencyKey: "update-key"}}
+	for i := 0; i < 2; i++ {
+		_, err := b.UpdateSchema(update)
+		if err != nil {
+			t.Fatalf("Expected no error on attempt %d updating schema, got %v.", i, err)
+		}
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.Version != 1 {
+		t.Errorf("Expected retried update to be applied once, got %v (err %v).", schema, err)
+	}
+
+	other := testCreateRequest()
+	other.EventName = "video_pause"
+	other.IdempotencyKey = "update-key"
+	_, err = b.CreateSchema(other)
+	if err != ErrIdempotencyKeyReused {
+		t.Errorf("Expected ErrIdempotencyKeyReused reusing key for another event, got %v.", err)
+	}
+
+	changed := &core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"channel"}, WriteOptions: core.WriteOptions{IdempotencyKey: "update-key"}}
+	_, err = b.UpdateSchema(changed)
+	if err != ErrIdempotencyKeyReused {
+		t.Errorf("Expected ErrIdempotencyKeyReused reusing key for another change to the event, got %v.", err)
+	}
+	schema, err = b.Schema("video_play")
+	if err != nil || schema.Version != 1 || len(schema.Columns) != 2 {
+		t.Errorf("Expected the other change not to be applied, got %v (err %v).", schema, err)
+	}
+}
+
+func TestMemoryBackendChangeType(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(255)"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error widening varchar, got %v.", err)
+	}
+	narrow := &core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(16)"}},
+	}
+	_, err = b.UpdateSchema(narrow)
+	if err == nil {
+		t.Error("Expected error narrowing varchar without force.")
+	}
+	narrow.Force = true
+	_, err = b.UpdateSchema(narrow)
+	if err != nil {
+		t.Errorf("Expected no error narrowing varchar with force, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "time", Transformer: "bigint"}},
+		Force:       true,
+	})
+	if err == nil {
+		t.Error("Expected error changing whether a column is a key.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "time", Transformer: "bigint", Length: " sortkey"}},
+		Force:       true,
+	})
+	if err == nil {
+		t.Error("Expected error changing the type of a key column, which can't be replaced.")
+	}
+
+	schema, err := b.Schema("video_play")
+	expected := scoop_protocol.ColumnDefinition{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(16)"}
+	if err != nil || schema.Version != 2 || !reflect.DeepEqual(expected, schema.Columns[1]) {
+		t.Errorf("Unexpected schema after type changes: %v (err %v).", schema, err)
+	}
+}
+
+func TestMemoryBackendRemap(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Remaps:    []core.ColumnRemap{{OutboundName: "minutes", InboundName: "minutes_watched", Transformer: "stringToIntegerMD5"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error remapping column, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Remaps:    []core.ColumnRemap{{OutboundName: "minutes", InboundName: "minutes", Transformer: "float"}},
+	})
+	if err == nil {
+		t.Error("Expected error remapping to a transformer with a different type.")
+	}
+
+	ops, err := b.Migration("video_play", 1)
+	expectedOps := []*scoop_protocol.Operation{
+		{Action: core.Remap, Name: "minutes", ActionMetadata: map[string]string{"inbound": "minutes_watched", "column_type": "stringToIntegerMD5"}},
+	}
+	if err != nil || !reflect.DeepEqual(expectedOps, ops) {
+		t.Errorf("Migration differs from expected (err %v):\n%v\nvs\n%v.", err, ops, expectedOps)
+	}
+	schema, err := b.Schema("video_play")
+	expected := scoop_protocol.ColumnDefinition{InboundName: "minutes_watched", OutboundName: "minutes", Transformer: "stringToIntegerMD5"}
+	if err != nil || !reflect.DeepEqual(expected, schema.Columns[2]) {
+		t.Errorf("Unexpected schema after remap: %v (err %v).", schema, err)
+	}
+}
+
+func TestMemoryBackendColumnOptions(t *testing.T) {
+	b := NewMemoryBackend()
+	req := testCreateRequest()
+	req.Columns[1].ColumnCreationOptions = ""
+	req.ColumnOptions = map[string]core.ColumnOptions{"channel": {Length: 32, DistKey: true, Encoding: "zstd"}}
+	_, err := b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema with structured options, got %v.", err)
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.Columns[1].ColumnCreationOptions != "(32) distkey encode zstd" {
+		t.Errorf("Expected options generated from structure, got %v (err %v).", schema, err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16); DROP TABLE video_play"}},
+	})
+	if err == nil {
+		t.Error("Expected error adding column with unparseable options.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Options: &core.ColumnOptions{Length: 16}}},
+	})
+	if err != nil {
+		t.Errorf("Expected no error adding column with structured options, got %v.", err)
+	}
+}
+
+func TestMemoryBackendLegacyColumnOptions(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	// columns created before options were parsed can have options that don't
+	m := b.(*memoryBackend)
+	m.apply(newTransaction([]scoop_protocol.Operation{
+		scoop_protocol.NewAddOperation("legacy", "legacy", "varchar", "(32) identity(1,1)"),
+	}, 1, "video_play", idempotency{}, Audit{}))
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}},
+	})
+	if err != nil {
+		t.Errorf("Expected no error adding column next to legacy column, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"legacy"}})
+	if err != nil {
+		t.Errorf("Expected no error deleting legacy column, got %v.", err)
+	}
+}
+
+func TestMemoryBackendAddNotNull(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16) not null"}},
+	})
+	if err == nil {
+		t.Error("Expected error adding not null column to existing table.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(64) not null"}},
+	})
+	if err == nil {
+		t.Error("Expected error making column not null.")
+	}
+}
+
+func TestMemoryBackendTransformerRegistry(t *testing.T) {
+	defer transformers.Use(transformers.Current())
+	registry, err := transformers.NewRegistry([]transformers.Transformer{
+		{Name: "f@timestamp@unix", OutputType: "TIMESTAMP WITHOUT TIME ZONE"},
+		{Name: "varchar", OutputType: "VARCHAR", Arguments: []transformers.Argument{{Name: transformers.ArgumentLength, Required: true, Max: 64}}},
+		{Name: "bigint", OutputType: "BIGINT", Deprecated: true},
+		{Name: "decimal", OutputType: "DECIMAL", Arguments: []transformers.Argument{{Name: transformers.ArgumentPrecision, Required: true, Max: 38}}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error creating registry, got %v.", err)
+	}
+	transformers.Use(registry)
+
+	b := NewMemoryBackend()
+	_, err = b.CreateSchema(testCreateRequest())
+	if err == nil {
+		t.Error("Expected error creating schema with deprecated transformer.")
+	}
+	req := testCreateRequest()
+	req.Columns = req.Columns[:2]
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(128)"}},
+	})
+	if err == nil {
+		t.Error("Expected error adding varchar longer than the registry allows.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:   "video_play",
+		TypeChanges: []core.TypeChange{{OutboundName: "channel", Transformer: "varchar", Length: "(128)"}},
+	})
+	if err == nil {
+		t.Error("Expected error widening varchar beyond the registry max length.")
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Options: &core.ColumnOptions{Precision: 10, Scale: 2}}},
+	})
+	if err == nil || !strings.Contains(err.Error(), "precision") {
+		t.Errorf("Expected error adding varchar with a precision, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "price", OutboundName: "price", Transformer: "decimal", Options: &core.ColumnOptions{Precision: 10, Scale: 2}}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error adding decimal with a precision, got %v.", err)
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.Columns[len(schema.Columns)-1].ColumnCreationOptions != "(10,2)" {
+		t.Errorf("Expected precision kept in the column options, got %v (err %v).", schema, err)
+	}
+}
+
+func TestMemoryBackendLint(t *testing.T) {
+	b := NewMemoryBackend()
+	req := testCreateRequest()
+	req.Columns[2].OutboundName = "user"
+	_, err := b.CreateSchema(req)
+	lintErr, ok := err.(*LintError)
+	if !ok || len(lintErr.Findings) != 1 || lintErr.Findings[0].Rule != lint.RuleReservedWord || lintErr.Findings[0].Column != "user" {
+		t.Fatalf("Expected lint error creating schema with reserved word column, got %v.", err)
+	}
+
+	defer lint.Use(lint.Current())
+	linter, err := lint.New(lint.Config{lint.RuleReservedWord: {Severity: lint.SeverityWarning}})
+	if err != nil {
+		t.Fatalf("Expected no error creating linter, got %v.", err)
+	}
+	lint.Use(linter)
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema with lint warning, got %v.", err)
+	}
+
+	lint.Use(lint.Default())
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}},
+	})
+	if err != nil {
+		t.Errorf("Expected existing lint errors not to block update, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "group", OutboundName: "group", Transformer: "varchar", Length: "(16)"}},
+	})
+	if _, ok := err.(*LintError); !ok {
+		t.Errorf("Expected lint error adding reserved word column, got %v.", err)
+	}
+}
+
+func TestMemoryBackendOrderedOperations(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Operations: []scoop_protocol.Operation{
+			scoop_protocol.NewRenameOperation("channel", "tmp"),
+			scoop_protocol.NewRenameOperation("minutes", "channel"),
+			scoop_protocol.NewRenameOperation("tmp", "minutes"),
+			scoop_protocol.NewRenameOperation("time", "client_time"),
+			scoop_protocol.NewAddOperation("time", "server_time", "f@timestamp@unix", ""),
+		},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error applying ordered operations, got %v.", err)
+	}
+	expected := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "client_time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "channel", OutboundName: "minutes", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+			{InboundName: "minutes", OutboundName: "channel", Transformer: "bigint", ColumnCreationOptions: ""},
+			{InboundName: "server_time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: ""},
+		},
+		Version: 1,
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || !reflect.DeepEqual(expected, schema) {
+		t.Errorf("Results schema differs from expected (err %v):\n%v\nvs\n%v.", err, schema, expected)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Operations: []scoop_protocol.Operation{
+			scoop_protocol.NewDeleteOperation("minutes"),
+			scoop_protocol.NewRenameOperation("channel", "time"),
+		},
+	})
+	if err == nil || !strings.HasPrefix(err.Error(), "Invalid schema creation request: operation 1 (rename channel)") {
+		t.Errorf("Expected error naming the failing operation, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:  "video_play",
+		Deletes:    []string{"minutes"},
+		Operations: []scoop_protocol.Operation{scoop_protocol.NewDeleteOperation("channel")},
+	})
+	if err == nil {
+		t.Error("Expected error combining operations with other changes.")
+	}
+	until := time.Now().Add(time.Hour)
+	for _, op := range []scoop_protocol.Operation{
+		core.NewTableOptionsOperation(core.TableOptions{DistStyle: core.DistStyleEven}),
+		core.NewDeprecateColumnOperation("minutes", &until),
+		core.NewSetStateOperation(core.StateDeprecated),
+		core.NewRenameEventOperation("video_play", "playback"),
+	} {
+		_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Operations: []scoop_protocol.Operation{op}})
+		if err == nil {
+			t.Errorf("Expected error giving %s as an operation.", op.Action)
+		}
+	}
+	schema, err = b.Schema("video_play")
+	if err != nil || schema.Version != 1 {
+		t.Errorf("Expected invalid updates not to be stored, got %v (err %v).", schema, err)
+	}
+}
@@ -0,0 +1,309 @@
+package bpdb
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"time"
+)
+
+// schemaMigration is a versioned change to the tables of the postgres
+// backend. Statements only create what doesn't exist yet, so databases set up
+// by hand before migrations were tracked can be migrated too.
+type schemaMigration struct {
+	version     int
+	description string
+	statements  []string
+}
+
+// schemaMigrations are applied in order and never changed once released;
+// changes to the tables are made by appending a migration.
+var schemaMigrations = []schemaMigration{
+	{1, "operation log", []string{`
+CREATE TABLE IF NOT EXISTS operation (
+    event text NOT NULL,
+    action text NOT NULL,
+    name text NOT NULL,
+    version integer NOT NULL,
+    ordering integer NOT NULL,
+    action_metadata jsonb NOT NULL
+)`,
+	}},
+	{2, "conflicting writes and idempotency keys", []string{
+		// two writers can't both store the same version of an event
+		`CREATE UNIQUE INDEX IF NOT EXISTS operation_event_version_ordering_idx
+    ON operation (event, version, ordering)`,
+		`
+CREATE TABLE IF NOT EXISTS idempotency_key (
+    key text PRIMARY KEY,
+    event text NOT NULL,
+    version integer NOT NULL,
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+)`,
+	}},
+	{3, "operation audit", []string{`
+CREATE TABLE IF NOT EXISTS operation_audit (
+    event text NOT NULL,
+    version integer NOT NULL,
+    author text NOT NULL,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL,
+    PRIMARY KEY (event, version)
+)`,
+	}},
+	{4, "event aliases", []string{`
+CREATE TABLE IF NOT EXISTS event_alias (
+    alias text PRIMARY KEY,
+    event text NOT NULL
+)`,
+	}},
+	{5, "operation sequence numbers", []string{
+		`ALTER TABLE operation ADD COLUMN IF NOT EXISTS sequence bigint`,
+		`CREATE SEQUENCE IF NOT EXISTS operation_sequence_seq OWNED BY operation.sequence`,
+		// existing operations are numbered in the order they were audited in,
+		// after the ones written before the audit table existed, whose order
+		// across events is unknown
+		`
+UPDATE operation o
+SET sequence = numbered.sequence
+FROM (
+    SELECT op.event, op.version, op.ordering,
+        row_number() OVER (ORDER BY a.created_at ASC NULLS FIRST, op.event, op.version, op.ordering) AS sequence
+    FROM operation op
+    LEFT JOIN operation_audit a ON a.event = op.event AND a.version = op.version
+) numbered
+WHERE o.event = numbered.event AND o.version = numbered.version AND o.ordering = numbered.ordering
+AND o.sequence IS NULL`,
+		`SELECT setval('operation_sequence_seq', COALESCE((SELECT max(sequence) FROM operation), 0) + 1, false)`,
+		`ALTER TABLE operation ALTER COLUMN sequence SET DEFAULT nextval('operation_sequence_seq')`,
+		`ALTER TABLE operation ALTER COLUMN sequence SET NOT NULL`,
+		`CREATE UNIQUE INDEX IF NOT EXISTS operation_sequence_idx ON operation (sequence)`,
+	}},
+	{6, "operation hash chain and quarantined events", []string{
+		`ALTER TABLE operation ADD COLUMN IF NOT EXISTS hash text`,
+		`
+CREATE TABLE IF NOT EXISTS quarantined_event (
+    event text PRIMARY KEY,
+    reason text NOT NULL DEFAULT '',
+    created_at timestamp with time zone NOT NULL DEFAULT now()
+)`,
+	}},
+	{7, "materialized current schemas", []string{`
+CREATE TABLE IF NOT EXISTS current_schema (
+    event text PRIMARY KEY,
+    version integer NOT NULL,
+    state text NOT NULL,
+    config jsonb NOT NULL
+)`,
+	}},
+	{8, "idempotency key request hashes", []string{
+		// keys stored before have no hash, and match any request to their event
+		`ALTER TABLE idempotency_key ADD COLUMN IF NOT EXISTS request_hash text NOT NULL DEFAULT ''`,
+	}},
+	{9, "operation hash chain heads", []string{`
+CREATE TABLE IF NOT EXISTS hash_chain_head (
+    event text PRIMARY KEY,
+    version integer NOT NULL,
+    ordering integer NOT NULL,
+    hash text NOT NULL,
+    row_count integer NOT NULL
+)`,
+	}},
+}
+
+var (
+	createSchemaMigrationTableQuery = `
+CREATE TABLE IF NOT EXISTS schema_migration (
+    version integer PRIMARY KEY,
+    description text NOT NULL,
+    applied_at timestamp with time zone NOT NULL DEFAULT now()
+)`
+	schemaMigrationTableExistsQuery = `SELECT to_regclass('schema_migration') IS NOT NULL`
+	appliedSchemaMigrationsQuery    = `SELECT version, applied_at
+FROM schema_migration`
+	insertSchemaMigrationQuery = `INSERT INTO schema_migration
+(version, description)
+VALUES ($1, $2)`
+
+	// schemaMigrationLockQuery keeps blueprint processes started together from
+	// applying the same migration at once
+	schemaMigrationLockQuery = `SELECT pg_advisory_xact_lock(hashtext('bpdb_schema_migration'))`
+)
+
+// SchemaMigration is a versioned change to the tables of the postgres backend
+type SchemaMigration struct {
+	Version     int
+	Description string
+	AppliedAt   *time.Time // nil until applied
+}
+
+// queryer is a database or a transaction
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// openPostgresDB connects to the postgres bpdb
+func openPostgresDB(dbConnection string) (*sql.DB, error) {
+	db, err := sql.Open("postgres", dbConnection)
+	if err != nil {
+		return nil, fmt.Errorf("Got err %v while connecting to db.", err)
+	}
+	err = db.Ping()
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("Got err %v trying to ping the db.", err)
+	}
+	return db, nil
+}
+
+// appliedSchemaMigrations returns when each applied migration was applied
+func appliedSchemaMigrations(q queryer) (map[int]time.Time, error) {
+	rows, err := q.Query(appliedSchemaMigrationsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for applied migrations: %v", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in appliedSchemaMigrations: %v", err)
+		}
+	}()
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		err := rows.Scan(&version, &appliedAt)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing migration row: %v", err)
+		}
+		applied[version] = appliedAt
+	}
+	return applied, nil
+}
+
+// SchemaMigrationStatus returns every migration of the postgres bpdb at
+// `dbConnection`, and when it was applied
+func SchemaMigrationStatus(dbConnection string) ([]SchemaMigration, error) {
+	db, err := openPostgresDB(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	p := &postgresBackend{db: db}
+	return p.schemaMigrationStatus()
+}
+
+func (p *postgresBackend) schemaMigrationStatus() ([]SchemaMigration, error) {
+	var exists bool
+	err := p.db.QueryRow(schemaMigrationTableExistsQuery).Scan(&exists)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for migrations table: %v", err)
+	}
+	applied := make(map[int]time.Time)
+	if exists {
+		applied, err = appliedSchemaMigrations(p.db)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return migrationStatus(schemaMigrations, applied), nil
+}
+
+// migrationStatus returns each of `migrations` and when it was applied,
+// given when each applied one was
+func migrationStatus(migrations []schemaMigration, applied map[int]time.Time) []SchemaMigration {
+	status := make([]SchemaMigration, 0, len(migrations))
+	for _, m := range migrations {
+		s := SchemaMigration{Version: m.version, Description: m.description}
+		if appliedAt, ok := applied[m.version]; ok {
+			s.AppliedAt = &appliedAt
+		}
+		status = append(status, s)
+	}
+	return status
+}
+
+// checkApplied returns an error naming the first pending migration, if any
+func checkApplied(status []SchemaMigration) error {
+	for _, s := range status {
+		if s.AppliedAt == nil {
+			return fmt.Errorf("bpdb migration %d (%s) is pending, run `blueprint migrate up` or start with -migrate", s.Version, s.Description)
+		}
+	}
+	return nil
+}
+
+// MigrateUp applies the pending migrations of the postgres bpdb at
+// `dbConnection` in order, and returns the ones it applied. Each migration is
+// applied in its own transaction holding an advisory lock, so concurrent
+// callers wait for each other and apply each migration once. The current
+// schemas are materialized afterwards if they weren't yet.
+func MigrateUp(dbConnection string) ([]SchemaMigration, error) {
+	db, err := openPostgresDB(dbConnection)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = db.Close() }()
+	p := &postgresBackend{db: db}
+	migrated, err := p.migrateUp()
+	if err != nil {
+		return migrated, err
+	}
+	return migrated, p.rebuildMissingCurrentSchemas()
+}
+
+func (p *postgresBackend) migrateUp() ([]SchemaMigration, error) {
+	migrated := []SchemaMigration{}
+	for _, m := range schemaMigrations {
+		applied := false
+		err := p.execFnInTransaction(func(tx *sql.Tx) error {
+			_, err := tx.Exec(schemaMigrationLockQuery)
+			if err != nil {
+				return fmt.Errorf("Error locking migrations: %v", err)
+			}
+			_, err = tx.Exec(createSchemaMigrationTableQuery)
+			if err != nil {
+				return fmt.Errorf("Error creating migrations table: %v", err)
+			}
+			done, err := appliedSchemaMigrations(tx)
+			if err != nil {
+				return err
+			}
+			if _, ok := done[m.version]; ok {
+				return nil
+			}
+			for _, statement := range m.statements {
+				_, err = tx.Exec(statement)
+				if err != nil {
+					return fmt.Errorf("Error applying migration %d (%s): %v", m.version, m.description, err)
+				}
+			}
+			_, err = tx.Exec(insertSchemaMigrationQuery, m.version, m.description)
+			if err != nil {
+				return fmt.Errorf("Error recording migration %d: %v", m.version, err)
+			}
+			applied = true
+			return nil
+		})
+		if err != nil {
+			return migrated, err
+		}
+		if applied {
+			log.Printf("Applied bpdb migration %d: %s", m.version, m.description)
+			now := time.Now()
+			migrated = append(migrated, SchemaMigration{Version: m.version, Description: m.description, AppliedAt: &now})
+		}
+	}
+	return migrated, nil
+}
+
+// checkSchemaMigrations returns an error if the postgres bpdb has pending
+// migrations, since the backend relies on their tables
+func (p *postgresBackend) checkSchemaMigrations() error {
+	status, err := p.schemaMigrationStatus()
+	if err != nil {
+		return err
+	}
+	return checkApplied(status)
+}
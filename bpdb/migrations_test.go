@@ -0,0 +1,80 @@
+package bpdb
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// idempotentStatements match the statements that can be run again after they
+// were applied, e.g. to a database set up by hand
+var idempotentStatements = []*regexp.Regexp{
+	regexp.MustCompile(`^CREATE (UNIQUE )?(TABLE|INDEX|SEQUENCE) IF NOT EXISTS `),
+	regexp.MustCompile(`^ALTER TABLE \w+ ADD COLUMN IF NOT EXISTS `),
+	regexp.MustCompile(`^ALTER TABLE \w+ ALTER COLUMN \w+ SET `),
+	regexp.MustCompile(`^UPDATE [\s\S]* IS NULL$`),
+	regexp.MustCompile(`^SELECT setval\(`),
+}
+
+func TestSchemaMigrationsNumbered(t *testing.T) {
+	for i, m := range schemaMigrations {
+		if m.version != i+1 {
+			t.Errorf("Expected migration %d to be numbered %d, got %d.", i, i+1, m.version)
+		}
+		if m.description == "" || len(m.statements) == 0 {
+			t.Errorf("Expected migration %d to have a description and statements.", m.version)
+		}
+	}
+}
+
+func TestSchemaMigrationsIdempotent(t *testing.T) {
+	for _, m := range schemaMigrations {
+		for _, statement := range m.statements {
+			statement = strings.TrimSpace(statement)
+			idempotent := false
+			for _, re := range idempotentStatements {
+				if re.MatchString(statement) {
+					idempotent = true
+					break
+				}
+			}
+			if !idempotent {
+				t.Errorf("Expected statement of migration %d to be idempotent:\n%s", m.version, statement)
+			}
+		}
+	}
+}
+
+func TestMigrationStatus(t *testing.T) {
+	migrations := []schemaMigration{
+		{1, "first", []string{"CREATE TABLE IF NOT EXISTS a ()"}},
+		{2, "second", []string{"CREATE TABLE IF NOT EXISTS b ()"}},
+		{3, "third", []string{"CREATE TABLE IF NOT EXISTS c ()"}},
+	}
+	status := migrationStatus(migrations, map[int]time.Time{})
+	if len(status) != 3 || status[0].AppliedAt != nil || status[2].AppliedAt != nil {
+		t.Errorf("Expected every migration to be pending, got %v.", status)
+	}
+	err := checkApplied(status)
+	if err == nil || !strings.Contains(err.Error(), "migration 1 (first)") {
+		t.Errorf("Expected the first migration to be reported pending, got %v.", err)
+	}
+
+	appliedAt := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	status = migrationStatus(migrations, map[int]time.Time{1: appliedAt, 3: appliedAt})
+	if status[0].AppliedAt == nil || !status[0].AppliedAt.Equal(appliedAt) || status[1].AppliedAt != nil || status[2].AppliedAt == nil {
+		t.Errorf("Expected only the second migration to be pending, got %v.", status)
+	}
+	err = checkApplied(status)
+	if err == nil || !strings.Contains(err.Error(), "migration 2 (second)") {
+		t.Errorf("Expected the second migration to be reported pending, got %v.", err)
+	}
+
+	// migrations applied by a newer blueprint are ignored
+	status = migrationStatus(migrations, map[int]time.Time{1: appliedAt, 2: appliedAt, 3: appliedAt, 4: appliedAt})
+	err = checkApplied(status)
+	if len(status) != 3 || err != nil {
+		t.Errorf("Expected every migration to be applied, got %v (err %v).", status, err)
+	}
+}
@@ -2,25 +2,35 @@ package bpdb
 
 import (
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
+	"time"
 
 	"encoding/json"
 
-	_ "github.com/lib/pq" // to include the 'postgres' driver
+	"github.com/lib/pq" // also includes the 'postgres' driver
 	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
 	"github.com/twitchscience/scoop_protocol/scoop_protocol"
 )
 
 var (
 	schemaQuery = `
-SELECT event, action, name, version, ordering, action_metadata
+SELECT event, action, name, version, ordering, action_metadata, sequence, COALESCE(hash, '')
 FROM operation
 WHERE event = $1
+AND event NOT IN (SELECT event FROM quarantined_event)
 ORDER BY version ASC, ordering ASC
 `
 	allSchemasQuery = `
-SELECT event, action, name,  version, ordering, action_metadata
+SELECT event, action, name,  version, ordering, action_metadata, sequence, COALESCE(hash, '')
+FROM operation
+WHERE event NOT IN (SELECT event FROM quarantined_event)
+ORDER BY version ASC, ordering ASC
+`
+	logRowsQuery = `
+SELECT event, action, name, version, ordering, action_metadata, sequence, COALESCE(hash, '')
 FROM operation
 ORDER BY version ASC, ordering ASC
 `
@@ -29,20 +39,100 @@ SELECT action, name, action_metadata
 FROM operation
 WHERE version = $1
 AND event = $2
+AND event NOT IN (SELECT event FROM quarantined_event)
 ORDER BY ordering ASC
+`
+	// stateQuery is the lifecycle state the event $1 was set to last as of
+	// version $2, if it was ever set
+	stateQuery = `
+SELECT action_metadata->>'state'
+FROM operation
+WHERE event = $1
+AND action = 'set_state'
+AND version <= $2
+ORDER BY version DESC, ordering DESC
+LIMIT 1
 `
 	insertOperationsQuery = `INSERT INTO operation
 (event, action, name, version, ordering, action_metadata)
 VALUES ($1, $2, $3, $4, $5, $6)
 `
-	nextVersionQuery = `SELECT max(version) + 1
+	currentVersionQuery = `SELECT max(version)
 FROM operation
 WHERE event = $1
 GROUP BY event`
+	idempotencyKeyQuery = `SELECT event, request_hash
+FROM idempotency_key
+WHERE key = $1`
+	insertIdempotencyKeyQuery = `INSERT INTO idempotency_key
+(key, event, version, request_hash)
+VALUES ($1, $2, $3, $4)`
+	insertAuditQuery = `INSERT INTO operation_audit
+(event, version, author, reason, created_at)
+VALUES ($1, $2, $3, $4, $5)`
+	auditQuery = `SELECT version, author, reason, created_at
+FROM operation_audit
+WHERE event = $1`
+	notifySchemaChangeQuery = `SELECT pg_notify($1, $2)`
+	sequenceAtQuery         = `SELECT COALESCE(max(o.sequence), 0)
+FROM operation o
+LEFT JOIN operation_audit a ON a.event = o.event AND a.version = o.version
+WHERE a.created_at IS NULL OR a.created_at <= $1`
+
+	// sequenceLockQuery serializes writes until they commit, so that operations
+	// are committed in the order of their sequence numbers and a snapshot never
+	// misses an operation with a lower sequence number than one it includes
+	sequenceLockQuery = `SELECT pg_advisory_xact_lock(hashtext('bpdb_operation_sequence'))`
+	aliasQuery        = `SELECT event
+FROM event_alias
+WHERE alias = $1`
+	quarantinedEventsQuery = `SELECT event, reason
+FROM quarantined_event`
+	quarantineEventQuery = `INSERT INTO quarantined_event
+(event, reason)
+VALUES ($1, $2)
+ON CONFLICT (event) DO UPDATE SET reason = $2`
+	releaseEventQuery = `DELETE FROM quarantined_event
+WHERE event = $1`
+	sealRowQuery = `UPDATE operation
+SET hash = $4
+WHERE event = $1 AND version = $2 AND ordering = $3 AND hash IS NULL`
+	chainHeadsQuery = `SELECT event, version, ordering, hash, row_count
+FROM hash_chain_head`
+	upsertChainHeadQuery = `INSERT INTO hash_chain_head
+(event, version, ordering, hash, row_count)
+VALUES ($1, $2, $3, $4, $5)
+ON CONFLICT (event) DO UPDATE SET version = $2, ordering = $3, hash = $4, row_count = $5`
+
+	// renameEventQueries move everything stored for the event $1 to $2 and
+	// make $1 an alias of $2, dropping $2 as an alias if it was one of $1
+	renameEventQueries = []string{
+		`DELETE FROM event_alias WHERE alias = $2 AND event = $1`,
+		`UPDATE operation SET event = $2 WHERE event = $1`,
+		`UPDATE operation_audit SET event = $2 WHERE event = $1`,
+		`UPDATE idempotency_key SET event = $2 WHERE event = $1`,
+		`UPDATE event_alias SET event = $2 WHERE event = $1`,
+		`UPDATE quarantined_event SET event = $2 WHERE event = $1`,
+		`UPDATE current_schema SET event = $2 WHERE event = $1`,
+		`UPDATE hash_chain_head SET event = $2 WHERE event = $1`,
+		`INSERT INTO event_alias (alias, event) VALUES ($1, $2)`,
+	}
+
+	// errAlreadyApplied is returned from a transaction that lost a race with
+	// another request using the same idempotency key
+	errAlreadyApplied = errors.New("request with idempotency key already applied")
 )
 
+// uniqueViolationCode is the postgres error code for a unique constraint violation
+const uniqueViolationCode = "23505"
+
 type postgresBackend struct {
 	db *sql.DB
+
+	// reads serves every read but the ones writes are validated against. It
+	// is a replica of db when one is set, and may lag behind it, so reads of
+	// a version the replica doesn't have yet fall back to db.
+	reads *sql.DB
 }
 
 type operationRow struct {
@@ -52,26 +142,99 @@ type operationRow struct {
 	actionMetadata map[string]string
 	version        int
 	ordering       int
+
+	// sequence orders the operations of every event globally
+	sequence int64
+
+	// hash chains the row to the rows before it once fsck seals it
+	hash string
 }
 
 // NewPostgresBackend creates a postgres bpdb backend to interface with
 // the schema store
 func NewPostgresBackend(dbConnection string) (Bpdb, error) {
-	db, err := sql.Open("postgres", dbConnection)
+	return NewPostgresBackendWithReplica(dbConnection, "")
+}
+
+// NewPostgresBackendWithReplica creates a postgres bpdb backend that sends
+// reads to the read replica at replicaConnection, unless it is empty. Writes,
+// the reads they are validated against, and reads of versions the replica
+// doesn't have yet go to the primary at dbConnection.
+func NewPostgresBackendWithReplica(dbConnection string, replicaConnection string) (Bpdb, error) {
+	db, err := openPostgresDB(dbConnection)
 	if err != nil {
-		return nil, fmt.Errorf("Got err %v while connecting to db.", err)
+		return nil, err
 	}
-	err = db.Ping()
+	b := &postgresBackend{db: db, reads: db}
+	err = b.checkSchemaMigrations()
 	if err != nil {
-		return nil, fmt.Errorf("Got err %v trying to ping the db.", err)
+		return nil, err
+	}
+	missing, err := b.currentSchemasMissing()
+	if err != nil {
+		return nil, err
+	}
+	if missing {
+		log.Printf("Current schemas aren't materialized, run `migrate up` or `rebuild-schemas` to serve them")
+	}
+	if replicaConnection != "" {
+		b.reads, err = openPostgresDB(replicaConnection)
+		if err != nil {
+			_ = db.Close()
+			return nil, fmt.Errorf("Error connecting to read replica: %v", err)
+		}
 	}
-	b := &postgresBackend{db: db}
 	return b, nil
 }
 
-// Migration returns the operations necessary to migration `table` from version `to -1` to version `to`
+// primary returns the backend reading everything from the primary
+func (p *postgresBackend) primary() Bpdb {
+	return &postgresBackend{db: p.db, reads: p.db}
+}
+
+// hasReplica reports whether reads are served by a replica
+func (p *postgresBackend) hasReplica() bool {
+	return p.reads != p.db
+}
+
+// resolveAlias returns the current name of the event `name`, which is an old
+// name if the event was renamed
+func resolveAlias(db queryer, name string) (string, error) {
+	var event string
+	err := db.QueryRow(aliasQuery, name).Scan(&event)
+	if err == sql.ErrNoRows {
+		return name, nil
+	} else if err != nil {
+		return "", fmt.Errorf("Error querying for alias %s: %v", name, err)
+	}
+	return event, nil
+}
+
+// Migration returns the operations necessary to migration `table` from version `to -1` to version `to`,
+// which are none while the event is a draft. Only actions in migrationActions are returned.
 func (p *postgresBackend) Migration(table string, to int) ([]*scoop_protocol.Operation, error) {
-	rows, err := p.db.Query(migrationQuery, to, table)
+	ops, err := migration(p.reads, table, to)
+	if err != nil || len(ops) > 0 || !p.hasReplica() {
+		return ops, err
+	}
+	// the replica may not have the version yet
+	return migration(p.db, table, to)
+}
+
+// migration returns the operations of version `to` of `table` from `db`
+func migration(db queryer, table string, to int) ([]*scoop_protocol.Operation, error) {
+	table, err := resolveAlias(db, table)
+	if err != nil {
+		return nil, err
+	}
+	state, err := stateAtVersion(db, table, to)
+	if err != nil {
+		return nil, err
+	}
+	if state == core.StateDraft {
+		return []*scoop_protocol.Operation{}, nil
+	}
+	rows, err := db.Query(migrationQuery, to, table)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying for migration (%s) to v%v: %v.", table, to, err)
 	}
@@ -98,7 +261,21 @@ func (p *postgresBackend) Migration(table string, to int) ([]*scoop_protocol.Ope
 		}
 		ops = append(ops, &op)
 	}
-	return ops, nil
+	return migrationOperations(ops), nil
+}
+
+// stateAtVersion returns the lifecycle state of the event `name` as of
+// `version` without reading the rest of its operations
+func stateAtVersion(q queryer, name string, version int) (string, error) {
+	var state string
+	err := q.QueryRow(stateQuery, name, version).Scan(&state)
+	switch {
+	case err == sql.ErrNoRows:
+		return core.StatePublished, nil
+	case err != nil:
+		return "", fmt.Errorf("Error querying state of %s at v%d: %v", name, version, err)
+	}
+	return state, nil
 }
 
 //execFnInTransaction takes a closure function of a request and runs it on the db in a transaction
@@ -118,8 +295,67 @@ func (p *postgresBackend) execFnInTransaction(work func(*sql.Tx) error) error {
 	return tx.Commit()
 }
 
-// returns error but does not rollback on error. Does not commit.
+// isUniqueViolation returns whether err is postgres rejecting a duplicate key
+func isUniqueViolation(err error) bool {
+	pqErr, ok := err.(*pq.Error)
+	return ok && pqErr.Code == uniqueViolationCode
+}
+
+// alreadyApplied reports whether the request to eventName with the
+// idempotency key was already committed
+func (p *postgresBackend) alreadyApplied(id idempotency, eventName string) (bool, error) {
+	if id.key == "" {
+		return false, nil
+	}
+	var usedFor, requestHash string
+	err := p.db.QueryRow(idempotencyKeyQuery, id.key).Scan(&usedFor, &requestHash)
+	if err == sql.ErrNoRows {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("Error querying for idempotency key: %v", err)
+	}
+	if id.reused(usedFor, requestHash, eventName) {
+		return false, ErrIdempotencyKeyReused
+	}
+	return true, nil
+}
+
+// idempotentResult turns the error from a write that lost a race with a
+// request using the same idempotency key into that request's result.
+func (p *postgresBackend) idempotentResult(err error, id idempotency, eventName string) error {
+	if err != errAlreadyApplied {
+		return err
+	}
+	_, err = p.alreadyApplied(id, eventName)
+	return err
+}
+
+// insertIdempotencyKey records the idempotency key of a write and the hash of
+// its request, if it has one. Does not rollback on error. Does not commit.
+func insertIdempotencyKey(tx *sql.Tx, id idempotency, eventName string, version int) error {
+	if id.key == "" {
+		return nil
+	}
+	_, err := tx.Exec(insertIdempotencyKeyQuery, id.key, eventName, version, id.requestHash)
+	if isUniqueViolation(err) {
+		return errAlreadyApplied
+	} else if err != nil {
+		return fmt.Errorf("Error INSERTing idempotency key for %s: %v", eventName, err)
+	}
+	return nil
+}
+
+// returns error but does not rollback on error. Does not commit. The
+// materialized current schema of the event is updated along with it. The unique
+// index on (event, version, ordering) rejects a concurrent write of the same
+// version, which is returned as ErrSchemaExists for version 0 and
+// ErrVersionConflict otherwise. Other writes wait for the transaction to end
+// once it has inserted operations, so sequence numbers are committed in order.
 func insertOperations(tx *sql.Tx, ops []scoop_protocol.Operation, version int, eventName string) error {
+	_, err := tx.Exec(sequenceLockQuery)
+	if err != nil {
+		return fmt.Errorf("Error locking operation sequence: %v", err)
+	}
 	for i, op := range ops {
 		var b []byte
 		b, err := json.Marshal(op.ActionMetadata)
@@ -134,50 +370,233 @@ func insertOperations(tx *sql.Tx, ops []scoop_protocol.Operation, version int, e
 			i, // ordering
 			b, // action_metadata
 		)
-		if err != nil {
-			rollErr := tx.Rollback()
-			if rollErr != nil {
-				return fmt.Errorf("Error rolling back commit: %v.", rollErr)
-			}
-			return fmt.Errorf("Error INSERTing row for delete column on %s: %v", eventName, err)
+		if isUniqueViolation(err) && version == 0 {
+			return ErrSchemaExists
+		} else if isUniqueViolation(err) {
+			return ErrVersionConflict
+		} else if err != nil {
+			return fmt.Errorf("Error INSERTing row for %s column on %s: %v", op.Action, eventName, err)
 		}
 	}
+	return updateCurrentSchema(tx, ops, version, eventName)
+}
+
+// insertAudit records who made a version of a schema, when, and why.
+// Does not rollback on error. Does not commit.
+func insertAudit(tx *sql.Tx, eventName string, version int, audit Audit) error {
+	_, err := tx.Exec(insertAuditQuery, eventName, version, audit.Author, audit.Reason, audit.Timestamp)
+	if err != nil {
+		return fmt.Errorf("Error INSERTing audit for %s v%d: %v", eventName, version, err)
+	}
+	return nil
+}
+
+// notifySchemaChange tells listeners, such as caching backends, that the
+// schema for eventName changed. Postgres delivers it when the transaction commits.
+func notifySchemaChange(tx *sql.Tx, eventName string) error {
+	_, err := tx.Exec(notifySchemaChangeQuery, schemaChangeChannel, eventName)
+	if err != nil {
+		return fmt.Errorf("Error notifying schema change on %s: %v", eventName, err)
+	}
 	return nil
 }
 
 // CreateSchema validates that the creation operation is valid and if so, stores
 // the schema as 'add' operations in bpdb
-func (p *postgresBackend) CreateSchema(req *scoop_protocol.Config) error {
-	err := preValidateSchema(req)
+func (p *postgresBackend) CreateSchema(req *core.ClientCreateSchemaRequest) (lint.Findings, error) {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := p.alreadyApplied(id, req.EventName)
+	if applied || err != nil {
+		return nil, err
+	}
+	var warnings lint.Findings
+	err = resolveCreateOptions(req)
+	if err == nil {
+		warnings, err = preValidateSchema(&req.Config, req.TableOptions)
+	}
+	if err != nil {
+		return nil, invalidRequest("schema creation", err)
+	}
+	event, err := resolveAlias(p.db, req.EventName)
 	if err != nil {
-		return fmt.Errorf("Invalid schema creation request: %v", err)
+		return nil, err
+	}
+	if event != req.EventName {
+		return nil, ErrSchemaExists
 	}
 
 	ops := schemaCreateRequestToOps(req)
-	return p.execFnInTransaction(func(tx *sql.Tx) error {
-		return insertOperations(tx, ops, 0, req.EventName)
+	err = p.execFnInTransaction(func(tx *sql.Tx) error {
+		err := insertIdempotencyKey(tx, id, req.EventName, 0)
+		if err != nil {
+			return err
+		}
+		err = insertOperations(tx, ops, 0, req.EventName)
+		if err != nil {
+			return err
+		}
+		err = insertAudit(tx, req.EventName, 0, newAudit(req.Author, req.Reason))
+		if err != nil {
+			return err
+		}
+		return notifySchemaChange(tx, req.EventName)
 	})
+	return warnings, p.idempotentResult(err, id, req.EventName)
 }
 
 // UpdateSchema validates that the update operation is valid and if so, stores
 // the operations for this migration to the schema as operations in bpdb. It
-// applies the operations in order of delete, add, then renames.
-func (p *postgresBackend) UpdateSchema(req *core.ClientUpdateSchemaRequest) error {
-	err := preValidateUpdate(req, p)
-	if err != nil {
-		return fmt.Errorf("Invalid schema creation request: %v", err)
+// applies the operations in order of deprecation, delete, type change, remap,
+// add, then renames, unless the request gives its operations in order.
+func (p *postgresBackend) UpdateSchema(req *core.ClientUpdateSchemaRequest) (lint.Findings, error) {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := p.alreadyApplied(id, req.EventName)
+	if applied || err != nil {
+		return nil, err
+	}
+	validatedVersion, warnings, err := preValidateUpdate(req, p.primary())
+	if err == ErrVersionConflict {
+		return nil, err
+	} else if err != nil {
+		return nil, invalidRequest("schema creation", err)
 	}
 
 	ops := schemaUpdateRequestToOps(req)
-	return p.execFnInTransaction(func(tx *sql.Tx) error {
-		row := tx.QueryRow(nextVersionQuery, req.EventName)
-		var newVersion int
-		err = row.Scan(&newVersion)
+	return warnings, p.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
+}
+
+// insertVersion stores the operations as the version after validatedVersion,
+// unless the schema was changed since it was validated
+func (p *postgresBackend) insertVersion(eventName string, ops []scoop_protocol.Operation, validatedVersion int, id idempotency, audit Audit) error {
+	err := p.execFnInTransaction(func(tx *sql.Tx) error {
+		var currentVersion int
+		err := tx.QueryRow(currentVersionQuery, eventName).Scan(&currentVersion)
+		if err != nil {
+			return fmt.Errorf("Error parsing response for version number for %s: %v.", eventName, err)
+		}
+		if currentVersion != validatedVersion {
+			return ErrVersionConflict
+		}
+		err = insertIdempotencyKey(tx, id, eventName, currentVersion+1)
 		if err != nil {
+			return err
+		}
+		err = insertOperations(tx, ops, currentVersion+1, eventName)
+		if err != nil {
+			return err
+		}
+		err = insertAudit(tx, eventName, currentVersion+1, audit)
+		if err != nil {
+			return err
+		}
+		return notifySchemaChange(tx, eventName)
+	})
+	return p.idempotentResult(err, id, eventName)
+}
+
+// RevertSchema validates that reverting the schema is valid and if so, stores
+// the operations undoing every change since the target version as a new
+// version in bpdb. It returns the operations, which are not stored on a dry run.
+func (p *postgresBackend) RevertSchema(req *core.ClientRevertSchemaRequest) ([]scoop_protocol.Operation, lint.Findings, error) {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := p.alreadyApplied(id, req.EventName)
+	if applied || err != nil {
+		return nil, nil, err
+	}
+	ops, validatedVersion, warnings, err := preValidateRevert(req, p.primary())
+	if err == ErrVersionConflict {
+		return nil, nil, err
+	} else if err != nil {
+		return nil, nil, invalidRequest("schema revert", err)
+	}
+	if req.DryRun {
+		return ops, warnings, nil
+	}
+	return ops, warnings, p.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
+}
+
+// RenameEvent validates that renaming the event is valid and if so, moves
+// its history to the new name, stores the rename as a new version, and keeps
+// the old name as an alias
+func (p *postgresBackend) RenameEvent(req *core.ClientRenameEventRequest) error {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := p.alreadyApplied(id, req.NewName)
+	if applied || err != nil {
+		return err
+	}
+	ops, validatedVersion, err := preValidateRenameEvent(req, p.primary())
+	if err == ErrVersionConflict {
+		return err
+	} else if err != nil {
+		return invalidRequest("event rename", err)
+	}
+
+	err = p.execFnInTransaction(func(tx *sql.Tx) error {
+		var currentVersion int
+		err := tx.QueryRow(currentVersionQuery, req.EventName).Scan(&currentVersion)
+		if err == sql.ErrNoRows {
+			// renamed by another request
+			return ErrVersionConflict
+		} else if err != nil {
 			return fmt.Errorf("Error parsing response for version number for %s: %v.", req.EventName, err)
 		}
-		return insertOperations(tx, ops, newVersion, req.EventName)
+		if currentVersion != validatedVersion {
+			return ErrVersionConflict
+		}
+		var taken int
+		err = tx.QueryRow(currentVersionQuery, req.NewName).Scan(&taken)
+		if err == nil {
+			return ErrSchemaExists
+		} else if err != sql.ErrNoRows {
+			return fmt.Errorf("Error querying for version number for %s: %v.", req.NewName, err)
+		}
+		var aliasOf string
+		err = tx.QueryRow(aliasQuery, req.NewName).Scan(&aliasOf)
+		if err == nil && aliasOf != req.EventName {
+			return ErrSchemaExists
+		} else if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("Error querying for alias %s: %v", req.NewName, err)
+		}
+
+		for _, query := range renameEventQueries {
+			_, err = tx.Exec(query, req.EventName, req.NewName)
+			if err != nil {
+				return fmt.Errorf("Error renaming %s to %s: %v", req.EventName, req.NewName, err)
+			}
+		}
+		err = insertIdempotencyKey(tx, id, req.NewName, currentVersion+1)
+		if err != nil {
+			return err
+		}
+		err = insertOperations(tx, ops, currentVersion+1, req.NewName)
+		if err != nil {
+			return err
+		}
+		err = insertAudit(tx, req.NewName, currentVersion+1, newAudit(req.Author, req.Reason))
+		if err != nil {
+			return err
+		}
+		// every cached schema is reloaded so the old name is dropped
+		return notifySchemaChange(tx, "")
 	})
+	return p.idempotentResult(err, id, req.NewName)
+}
+
+// SetEventState validates that moving the event to another lifecycle state
+// is valid and if so, stores the change as a new version in bpdb
+func (p *postgresBackend) SetEventState(req *core.ClientSetEventStateRequest) error {
+	id := newIdempotency(req.IdempotencyKey, req.EventName, req)
+	applied, err := p.alreadyApplied(id, req.EventName)
+	if applied || err != nil {
+		return err
+	}
+	ops, validatedVersion, err := preValidateSetState(req, p.primary())
+	if err == ErrVersionConflict {
+		return err
+	} else if err != nil {
+		return invalidRequest("event state", err)
+	}
+	return p.insertVersion(req.EventName, ops, validatedVersion, id, newAudit(req.Author, req.Reason))
 }
 
 // scanOperationRows scans the rows into operationRow objects
@@ -192,7 +611,7 @@ func scanOperationRows(rows *sql.Rows) ([]operationRow, error) {
 	for rows.Next() {
 		var op operationRow
 		var b []byte
-		err := rows.Scan(&op.event, &op.action, &op.name, &op.version, &op.ordering, &b)
+		err := rows.Scan(&op.event, &op.action, &op.name, &op.version, &op.ordering, &b, &op.sequence, &op.hash)
 		if err != nil {
 			return nil, fmt.Errorf("Error parsing operation row: %v.", err)
 		}
@@ -205,32 +624,121 @@ func scanOperationRows(rows *sql.Rows) ([]operationRow, error) {
 	return ops, nil
 }
 
-// Schema returns the current schema for the table `name`
-func (p *postgresBackend) Schema(name string) (*scoop_protocol.Config, error) {
-	rows, err := p.db.Query(schemaQuery, name)
+// eventRows returns the operation rows of the table `name`, which must not be
+// an alias, in order
+func eventRows(db queryer, name string) ([]operationRow, error) {
+	rows, err := db.Query(schemaQuery, name)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying for schema %s: %v.", name, err)
 	}
-	ops, err := scanOperationRows(rows)
+	return scanOperationRows(rows)
+}
+
+// Versions returns every version of the table `name` with the operations
+// that migrated it to that version
+func (p *postgresBackend) Versions(name string) ([]SchemaVersion, error) {
+	versions, err := eventVersions(p.reads, name)
+	if err != nil || len(versions) > 0 || !p.hasReplica() {
+		return versions, err
+	}
+	// the replica may not have the event yet
+	return eventVersions(p.db, name)
+}
+
+// eventVersions returns every version of the table `name` from `db`
+func eventVersions(db queryer, name string) ([]SchemaVersion, error) {
+	name, err := resolveAlias(db, name)
+	if err != nil {
+		return nil, err
+	}
+	ops, err := eventRows(db, name)
+	if err != nil {
+		return nil, err
+	}
+	audits, err := eventAudits(db, name)
 	if err != nil {
 		return nil, err
 	}
+	versions := versionsFromRows(ops)
+	addAudits(versions, audits)
+	return versions, nil
+}
+
+// eventAudits returns the audit record of each version of the table `name`
+func eventAudits(db queryer, name string) (map[int]Audit, error) {
+	rows, err := db.Query(auditQuery, name)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for audits of %s: %v.", name, err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in postgres backend eventAudits: %v", err)
+		}
+	}()
+	audits := make(map[int]Audit)
+	for rows.Next() {
+		var version int
+		var audit Audit
+		err := rows.Scan(&version, &audit.Author, &audit.Reason, &audit.Timestamp)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing audit row: %v.", err)
+		}
+		audit.Timestamp = audit.Timestamp.UTC()
+		audits[version] = audit
+	}
+	return audits, rows.Err()
+}
+
+// SchemaAtVersion returns the schema for the table `name` as of `version`,
+// or nil if there is no such version
+func (p *postgresBackend) SchemaAtVersion(name string, version int) (*scoop_protocol.Config, error) {
+	schema, err := eventSchemaAtVersion(p.reads, name, version)
+	if err != nil || schema != nil || !p.hasReplica() {
+		return schema, err
+	}
+	// the replica may not have the version yet
+	return eventSchemaAtVersion(p.db, name, version)
+}
 
-	schemas, err := generateSchemas(ops)
+// eventSchemaAtVersion returns the schema for the table `name` as of
+// `version` from `db`, or nil if it has no such version
+func eventSchemaAtVersion(db queryer, name string, version int) (*scoop_protocol.Config, error) {
+	name, err := resolveAlias(db, name)
 	if err != nil {
-		return nil, fmt.Errorf("Internal state bad - Error generating schemas from operations: %v", err)
+		return nil, err
 	}
-	if len(schemas) > 1 {
-		return nil, fmt.Errorf("Expected only one schema, received %v.", len(schemas))
+	ops, err := eventRows(db, name)
+	if err != nil {
+		return nil, err
 	}
-	if len(schemas) == 0 {
+	return schemaAtVersion(ops, version)
+}
+
+// Schema returns the current schema for the table `name`
+func (p *postgresBackend) Schema(name string) (*scoop_protocol.Config, error) {
+	event, err := resolveAlias(p.reads, name)
+	if err != nil {
+		return nil, err
+	}
+	var b []byte
+	err = p.reads.QueryRow(currentSchemaQuery, event).Scan(&b)
+	if err == sql.ErrNoRows {
 		return nil, fmt.Errorf("Unable to find schema: %v", name)
+	} else if err != nil {
+		return nil, fmt.Errorf("Error querying for schema %s: %v.", name, err)
+	}
+	var schema scoop_protocol.Config
+	err = json.Unmarshal(b, &schema)
+	if err != nil {
+		return nil, fmt.Errorf("Error unmarshalling schema %s: %v.", name, err)
 	}
-	return &schemas[0], nil
+	return &schema, nil
 }
 
-// Schema returns all of the current schemas
-func (p *postgresBackend) AllSchemas() ([]scoop_protocol.Config, error) {
+// SchemasAsOf returns the schemas of the published and deprecated events as
+// they were once the operation numbered `sequence` was committed
+func (p *postgresBackend) SchemasAsOf(sequence int64) ([]scoop_protocol.Config, error) {
 	rows, err := p.db.Query(allSchemasQuery)
 	if err != nil {
 		return nil, fmt.Errorf("Error querying for all schemas: %v.", err)
@@ -239,7 +747,172 @@ func (p *postgresBackend) AllSchemas() ([]scoop_protocol.Config, error) {
 	if err != nil {
 		return nil, err
 	}
-	return generateSchemas(ops)
+	return schemasAsOf(ops, sequence)
+}
+
+// SequenceAt returns the sequence number of the last operation committed at
+// or before `t`, or 0 if there is none. Operations without an audit record
+// were numbered before every audited one, and count as committed before any
+// `t`, since times before the audit table existed can't be resolved.
+func (p *postgresBackend) SequenceAt(t time.Time) (int64, error) {
+	var sequence int64
+	err := p.db.QueryRow(sequenceAtQuery, t).Scan(&sequence)
+	if err != nil {
+		return 0, fmt.Errorf("Error querying for sequence number at %v: %v.", t, err)
+	}
+	return sequence, nil
+}
+
+// AllSchemas returns the current schemas of the published and deprecated events
+func (p *postgresBackend) AllSchemas() ([]scoop_protocol.Config, error) {
+	schemas, err := p.EventSchemas(LiveStates...)
+	if err != nil {
+		return nil, err
+	}
+	return liveConfigs(schemas), nil
+}
+
+// EventSchemas returns the current schemas of the events in one of `states`,
+// or of every event if none are given
+func (p *postgresBackend) EventSchemas(states ...string) ([]EventSchema, error) {
+	rows, err := p.reads.Query(currentSchemasQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for all schemas: %v.", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in postgres backend EventSchemas: %v", err)
+		}
+	}()
+	schemas := []EventSchema{}
+	for rows.Next() {
+		var schema EventSchema
+		var b []byte
+		err := rows.Scan(&b, &schema.State)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing current schema row: %v.", err)
+		}
+		err = json.Unmarshal(b, &schema.Config)
+		if err != nil {
+			return nil, fmt.Errorf("Error unmarshalling current schema: %v.", err)
+		}
+		if inStates(schema.State, states) {
+			schemas = append(schemas, schema)
+		}
+	}
+	return schemas, nil
+}
+
+// logRows returns every operation row, including those of quarantined events
+func (p *postgresBackend) logRows() ([]operationRow, error) {
+	rows, err := p.db.Query(logRowsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for operation log: %v.", err)
+	}
+	return scanOperationRows(rows)
+}
+
+// quarantinedEvents returns each quarantined event and the reason for it
+func (p *postgresBackend) quarantinedEvents() (map[string]string, error) {
+	rows, err := p.db.Query(quarantinedEventsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for quarantined events: %v.", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in postgres backend quarantinedEvents: %v", err)
+		}
+	}()
+	quarantined := make(map[string]string)
+	for rows.Next() {
+		var event, reason string
+		err := rows.Scan(&event, &reason)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing quarantined event row: %v.", err)
+		}
+		quarantined[event] = reason
+	}
+	return quarantined, nil
+}
+
+// quarantine hides the event from every read
+func (p *postgresBackend) quarantine(event string, reason string) error {
+	return p.execFnInTransaction(func(tx *sql.Tx) error {
+		_, err := tx.Exec(quarantineEventQuery, event, reason)
+		if err != nil {
+			return fmt.Errorf("Error quarantining %s: %v", event, err)
+		}
+		return notifySchemaChange(tx, event)
+	})
+}
+
+// release serves a quarantined event again
+func (p *postgresBackend) release(event string) error {
+	return p.execFnInTransaction(func(tx *sql.Tx) error {
+		result, err := tx.Exec(releaseEventQuery, event)
+		if err != nil {
+			return fmt.Errorf("Error releasing %s: %v", event, err)
+		}
+		released, err := result.RowsAffected()
+		if err != nil {
+			return fmt.Errorf("Error releasing %s: %v", event, err)
+		}
+		if released == 0 {
+			return fmt.Errorf("event %s is not quarantined", event)
+		}
+		// the operations may have been fixed by hand while quarantined
+		err = replayCurrentSchema(tx, event)
+		if err != nil {
+			return err
+		}
+		return notifySchemaChange(tx, event)
+	})
+}
+
+// chainHeads returns the head of the hash chain of each sealed event
+func (p *postgresBackend) chainHeads() (map[string]chainHead, error) {
+	rows, err := p.db.Query(chainHeadsQuery)
+	if err != nil {
+		return nil, fmt.Errorf("Error querying for hash chain heads: %v.", err)
+	}
+	defer func() {
+		err := rows.Close()
+		if err != nil {
+			log.Printf("Error closing rows in postgres backend chainHeads: %v", err)
+		}
+	}()
+	heads := make(map[string]chainHead)
+	for rows.Next() {
+		var head chainHead
+		err := rows.Scan(&head.Event, &head.Version, &head.Ordering, &head.Hash, &head.Rows)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing hash chain head row: %v.", err)
+		}
+		heads[head.Event] = head
+	}
+	return heads, nil
+}
+
+// seal stores the hashes chaining the rows of each event, leaving rows
+// sealed since they were read alone, and the heads of the chains
+func (p *postgresBackend) seal(seals []rowSeal, heads []chainHead) error {
+	return p.execFnInTransaction(func(tx *sql.Tx) error {
+		for _, seal := range seals {
+			_, err := tx.Exec(sealRowQuery, seal.Event, seal.Version, seal.Ordering, seal.Hash)
+			if err != nil {
+				return fmt.Errorf("Error sealing %s v%d #%d: %v", seal.Event, seal.Version, seal.Ordering, err)
+			}
+		}
+		for _, head := range heads {
+			_, err := tx.Exec(upsertChainHeadQuery, head.Event, head.Version, head.Ordering, head.Hash, head.Rows)
+			if err != nil {
+				return fmt.Errorf("Error storing hash chain head of %s: %v", head.Event, err)
+			}
+		}
+		return nil
+	})
 }
 
 // max returns the max of the two arguments
@@ -0,0 +1,52 @@
+package bpdb
+
+import (
+	"fmt"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// preValidateRenameEvent validates renaming the event like an update,
+// returning the operation renaming it and the version of the schema it was
+// validated against. The new name not being taken is checked when the rename
+// is stored. Drafts can be renamed regardless of their compatibility mode.
+func preValidateRenameEvent(req *core.ClientRenameEventRequest, bpdb Bpdb) ([]scoop_protocol.Operation, int, error) {
+	if req.NewName == req.EventName {
+		return nil, 0, invalidf("event is already named %s", req.NewName)
+	}
+	schema, err := bpdb.Schema(req.EventName)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error getting schema to validate event rename: %v", err)
+	}
+	if schema.EventName != req.EventName {
+		return nil, 0, invalidf("event %s was renamed to %s", req.EventName, schema.EventName)
+	}
+	if req.BaseVersion != nil && *req.BaseVersion != schema.Version {
+		return nil, 0, ErrVersionConflict
+	}
+	state, err := preValidateState(bpdb, req.EventName)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	version := schema.Version
+	tableOpts, err := TableOptionsAtVersion(bpdb, req.EventName, version)
+	if err != nil || tableOpts == nil {
+		return nil, 0, fmt.Errorf("error getting table options to validate event rename: %v", err)
+	}
+	ops := []scoop_protocol.Operation{core.NewRenameEventOperation(req.EventName, req.NewName)}
+	// renaming the event changes no column, so there are no new warnings
+	_, err = preValidateOperations(schema, tableOpts, ops, false)
+	if err != nil {
+		return nil, 0, err
+	}
+	if state == core.StateDraft {
+		return ops, version, nil
+	}
+	err = preValidateCompatibility(bpdb, req.EventName, ops)
+	if err != nil {
+		return nil, 0, err
+	}
+	return ops, version, nil
+}
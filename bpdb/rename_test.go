@@ -0,0 +1,123 @@
+package bpdb
+
+import (
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func TestRenameEvent(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "video-play!"})
+	if err == nil {
+		t.Error("Expected error renaming to invalid name.")
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "playback", WriteOptions: core.WriteOptions{Author: "alice"}})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+
+	versions, err := b.Versions("playback")
+	if err != nil || len(versions) != 3 || versions[2].Audit == nil || versions[2].Audit.Author != "alice" {
+		t.Fatalf("Expected history to move to the new name, got %v (err %v).", versions, err)
+	}
+	for _, name := range []string{"video_play", "playback"} {
+		schema, err := b.Schema(name)
+		if err != nil || schema.EventName != "playback" || schema.Version != 2 {
+			t.Errorf("Expected %s to resolve to the renamed schema, got %v (err %v).", name, schema, err)
+		}
+	}
+	ops, err := b.Migration("video_play", 2)
+	expectedOps := []*scoop_protocol.Operation{{Action: core.RenameEvent, Name: "video_play", ActionMetadata: map[string]string{"new_name": "playback"}}}
+	if err != nil || !reflect.DeepEqual(ops, expectedOps) {
+		t.Errorf("Expected ingesters to follow the rename through the old name, got %v (err %v).", ops, err)
+	}
+	schemas, err := b.AllSchemas()
+	if err != nil || len(schemas) != 1 || schemas[0].EventName != "playback" {
+		t.Errorf("Expected only the renamed schema, got %v (err %v).", schemas, err)
+	}
+
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != ErrSchemaExists {
+		t.Errorf("Expected %v creating schema with an alias, got %v.", ErrSchemaExists, err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:  "playback",
+		Operations: []scoop_protocol.Operation{core.NewRenameEventOperation("playback", "video_play")},
+	})
+	if err == nil {
+		t.Error("Expected error renaming event in an update.")
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "playback", ToVersion: 1})
+	if err == nil {
+		t.Error("Expected error reverting an event rename.")
+	}
+
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "playback", NewName: "video_play"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event back, got %v.", err)
+	}
+	schema, err := b.Schema("playback")
+	if err != nil || schema.EventName != "video_play" || schema.Version != 3 {
+		t.Errorf("Expected old name to resolve after renaming back, got %v (err %v).", schema, err)
+	}
+}
+
+func TestRenameEventTaken(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	req := testCreateRequest()
+	req.EventName = "playback"
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "playback"})
+	if err != ErrSchemaExists {
+		t.Errorf("Expected %v renaming to an existing event, got %v.", ErrSchemaExists, err)
+	}
+}
+
+func TestFileBackendRenameEvent(t *testing.T) {
+	dir := tempBpdbDir(t)
+	defer func() { _ = os.RemoveAll(dir) }()
+
+	b, err := NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to open file backend: %v", err)
+	}
+	_, err = b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "playback"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+	closeFileBackend(t, b)
+
+	b, err = NewFileBackend(dir)
+	if err != nil {
+		t.Fatalf("Failed to reopen file backend: %v", err)
+	}
+	defer closeFileBackend(t, b)
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.EventName != "playback" || schema.Version != 1 {
+		t.Errorf("Expected rename to be replayed, got %v (err %v).", schema, err)
+	}
+}
@@ -0,0 +1,176 @@
+package bpdb
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// RevertKeyColumnError is returned when reverting a schema would drop a
+// distkey or sortkey column added after the version reverted to, since key
+// columns can't be dropped
+type RevertKeyColumnError struct {
+	Column  string
+	Version int // the version that added the column
+}
+
+func (e *RevertKeyColumnError) Error() string {
+	return fmt.Sprintf("version %d added %s as a distkey or sortkey column, which can't be dropped; revert to version %d or later instead",
+		e.Version, e.Column, e.Version)
+}
+
+// invertOperation returns the operation undoing op, given the schema, table
+// options and deprecated columns as they were before op was applied
+func invertOperation(schema *scoop_protocol.Config, tableOpts core.TableOptions, deprecations map[string]core.ColumnDeprecation, op scoop_protocol.Operation) (scoop_protocol.Operation, error) {
+	switch op.Action {
+	case scoop_protocol.ADD:
+		return scoop_protocol.NewDeleteOperation(op.Name), nil
+	case scoop_protocol.DELETE:
+		for _, col := range schema.Columns {
+			if col.OutboundName == op.Name {
+				return scoop_protocol.NewAddOperation(col.OutboundName, col.InboundName, col.Transformer, col.ColumnCreationOptions), nil
+			}
+		}
+		return scoop_protocol.Operation{}, fmt.Errorf("Outbound column '%s' does not exist in schema, cannot restore dropped column.", op.Name)
+	case scoop_protocol.RENAME:
+		return scoop_protocol.NewRenameOperation(op.ActionMetadata["new_outbound"], op.Name), nil
+	case core.ChangeType:
+		for _, col := range schema.Columns {
+			if col.OutboundName == op.Name {
+				return core.NewChangeTypeOperation(col.OutboundName, col.Transformer, col.ColumnCreationOptions), nil
+			}
+		}
+		return scoop_protocol.Operation{}, fmt.Errorf("Outbound column '%s' does not exist in schema, cannot restore column type.", op.Name)
+	case core.Remap:
+		for _, col := range schema.Columns {
+			if col.OutboundName == op.Name {
+				return core.NewRemapOperation(col.OutboundName, col.InboundName, col.Transformer), nil
+			}
+		}
+		return scoop_protocol.Operation{}, fmt.Errorf("Outbound column '%s' does not exist in schema, cannot restore column mapping.", op.Name)
+	case core.SetTableOptions:
+		return core.NewTableOptionsOperation(tableOpts), nil
+	case core.DeprecateColumn:
+		if d, ok := deprecations[op.Name]; ok {
+			return core.NewDeprecateColumnOperation(op.Name, &d.Until), nil
+		}
+		return core.NewDeprecateColumnOperation(op.Name, nil), nil
+	case core.RenameEvent:
+		return scoop_protocol.Operation{}, invalidf("Event was renamed from %s, which can't be reverted; rename it back instead.", op.Name)
+	default:
+		return scoop_protocol.Operation{}, fmt.Errorf("Error, unsupported operation action %s.", op.Action)
+	}
+}
+
+// revertOperations returns the operations that restore the schema built from
+// `versions` to version `to`: the inverse of every later operation, latest
+// first. Restored columns are added at the end of the schema. Lifecycle state
+// changes aren't reverted. It returns a RevertKeyColumnError if a later
+// version added a key column.
+func revertOperations(eventName string, versions []SchemaVersion, to int) ([]scoop_protocol.Operation, error) {
+	schema := &scoop_protocol.Config{EventName: eventName}
+	tableOpts := core.TableOptions{}
+	deprecations := make(map[string]core.ColumnDeprecation)
+	inverses := []scoop_protocol.Operation{}
+	var keyColumnErr *RevertKeyColumnError
+	found := false
+	for _, version := range versions {
+		found = found || version.Version == to
+		for _, op := range version.Operations {
+			if version.Version > to && op.Action == scoop_protocol.ADD && validateIsNotKey(op.ActionMetadata["column_options"]) != nil {
+				// the latest one, so reverting to its version is enough
+				keyColumnErr = &RevertKeyColumnError{Column: op.Name, Version: version.Version}
+			}
+			if version.Version > to && op.Action != core.SetState {
+				inverse, err := invertOperation(schema, tableOpts, deprecations, op)
+				if err != nil {
+					return nil, err
+				}
+				inverses = append(inverses, inverse)
+			}
+			err := ApplyOperation(schema, op)
+			if err != nil {
+				return nil, err
+			}
+			err = applyTableOperation(&tableOpts, op)
+			if err != nil {
+				return nil, err
+			}
+			err = applyDeprecationOperation(deprecations, op, version.Version)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	if !found {
+		return nil, invalidf("version %d not found", to)
+	}
+	if keyColumnErr != nil {
+		return nil, keyColumnErr
+	}
+
+	ops := make([]scoop_protocol.Operation, 0, len(inverses))
+	for i := len(inverses) - 1; i >= 0; i-- {
+		ops = append(ops, inverses[i])
+	}
+	return ops, nil
+}
+
+// preValidateRevert plans the operations reverting the schema and validates
+// them like an update, returning the operations, the version of the schema
+// they were validated against and the lint warnings about the reverted schema.
+// Drafts can be reverted freely.
+func preValidateRevert(req *core.ClientRevertSchemaRequest, bpdb Bpdb) ([]scoop_protocol.Operation, int, lint.Findings, error) {
+	versions, err := bpdb.Versions(req.EventName)
+	if err != nil {
+		return nil, 0, nil, fmt.Errorf("error getting versions to validate schema revert: %v", err)
+	}
+	if len(versions) == 0 {
+		return nil, 0, nil, fmt.Errorf("Unable to find schema: %v", req.EventName)
+	}
+	currentVersion := versions[len(versions)-1].Version
+	if req.BaseVersion != nil && *req.BaseVersion != currentVersion {
+		return nil, 0, nil, ErrVersionConflict
+	}
+	state, err := preValidateState(bpdb, req.EventName)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	if req.ToVersion >= currentVersion {
+		return nil, 0, nil, invalidf("can only revert to a version before the current version %d, given %d", currentVersion, req.ToVersion)
+	}
+
+	ops, err := revertOperations(req.EventName, versions, req.ToVersion)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	schema, tableOpts, err := replayVersions(req.EventName, versions, currentVersion)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	draft := state == core.StateDraft
+	warnings, err := preValidateOperations(schema, &tableOpts, ops, req.Force || draft)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	deprecations, err := replayDeprecations(versions, currentVersion)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	deprecationWarnings, err := preValidateDeprecations(req.EventName, deprecations, ops, req.Force || draft, time.Now())
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	warnings = append(warnings, deprecationWarnings...)
+	if draft {
+		return ops, currentVersion, warnings, nil
+	}
+	err = checkCompatibility(compatibilityMode(req.EventName, versions), req.EventName, versions, ops)
+	if err != nil {
+		return nil, 0, nil, err
+	}
+	return ops, currentVersion, warnings, nil
+}
@@ -0,0 +1,108 @@
+package bpdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func TestRevertSchema(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16)"}},
+		Deletes:   []string{"minutes"},
+		Renames:   core.Renames{"channel": "channel_name"},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	expectedOps := []scoop_protocol.Operation{
+		scoop_protocol.NewRenameOperation("channel_name", "channel"),
+		scoop_protocol.NewDeleteOperation("os"),
+		scoop_protocol.NewAddOperation("minutes", "minutes", "bigint", ""),
+	}
+	ops, _, err := b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0, DryRun: true})
+	if err != nil || !reflect.DeepEqual(expectedOps, ops) {
+		t.Errorf("Dry run operations differ from expected (err %v):\n%v\nvs\n%v.", err, ops, expectedOps)
+	}
+	schema, err := b.Schema("video_play")
+	if err != nil || schema.Version != 1 {
+		t.Errorf("Expected dry run not to change schema, got %v (err %v).", schema, err)
+	}
+
+	ops, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0})
+	if err != nil || !reflect.DeepEqual(expectedOps, ops) {
+		t.Errorf("Revert operations differ from expected (err %v):\n%v\nvs\n%v.", err, ops, expectedOps)
+	}
+	expected := testCreateRequest().Config
+	expected.Version = 2
+	schema, err = b.Schema("video_play")
+	if err != nil || !reflect.DeepEqual(&expected, schema) {
+		t.Errorf("Reverted schema differs from expected (err %v):\n%v\nvs\n%v.", err, schema, expected)
+	}
+}
+
+func TestRevertSchemaInvalid(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0})
+	if err == nil {
+		t.Error("Expected error reverting to the current version.")
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "missing", ToVersion: 0})
+	if err == nil {
+		t.Error("Expected error reverting unknown schema.")
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	baseVersion := 0
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0, WriteOptions: core.WriteOptions{BaseVersion: &baseVersion}})
+	if err != ErrVersionConflict {
+		t.Errorf("Expected ErrVersionConflict reverting stale version, got %v.", err)
+	}
+}
+
+func TestRevertSchemaKeyColumn(t *testing.T) {
+	b := NewMemoryBackend()
+	req := testCreateRequest()
+	req.Columns[0].ColumnCreationOptions = ""
+	_, err := b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName: "video_play",
+		Additions: []core.Column{{InboundName: "os", OutboundName: "os", Transformer: "varchar", Length: "(16) sortkey"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 0, DryRun: true})
+	keyErr, ok := err.(*RevertKeyColumnError)
+	if !ok || keyErr.Column != "os" || keyErr.Version != 1 {
+		t.Errorf("Expected RevertKeyColumnError for os added in version 1, got %v.", err)
+	}
+	_, _, err = b.RevertSchema(&core.ClientRevertSchemaRequest{EventName: "video_play", ToVersion: 1, DryRun: true})
+	if err != nil {
+		t.Errorf("Expected no error reverting to the version adding the key column, got %v.", err)
+	}
+}
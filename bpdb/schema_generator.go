@@ -3,6 +3,7 @@ package bpdb
 import (
 	"fmt"
 
+	"github.com/twitchscience/blueprint/core"
 	"github.com/twitchscience/scoop_protocol/scoop_protocol"
 )
 
@@ -51,6 +52,42 @@ func ApplyOperation(s *scoop_protocol.Config, op scoop_protocol.Operation) error
 			}
 		}
 		return fmt.Errorf("Outbound column '%s' does not exists in schema, cannot rename non-existent column.", op.Name)
+	case core.ChangeType:
+		for i, existingCol := range s.Columns {
+			if existingCol.OutboundName == op.Name {
+				s.Columns[i].Transformer = op.ActionMetadata["column_type"]
+				s.Columns[i].ColumnCreationOptions = op.ActionMetadata["column_options"]
+				return nil
+			}
+		}
+		return fmt.Errorf("Outbound column '%s' does not exists in schema, cannot change type of non-existent column.", op.Name)
+	case core.Remap:
+		for i, existingCol := range s.Columns {
+			if existingCol.OutboundName == op.Name {
+				s.Columns[i].InboundName = op.ActionMetadata["inbound"]
+				if op.ActionMetadata["column_type"] != "" {
+					s.Columns[i].Transformer = op.ActionMetadata["column_type"]
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("Outbound column '%s' does not exists in schema, cannot remap non-existent column.", op.Name)
+	case core.SetTableOptions:
+		// table options don't change the columns
+		return nil
+	case core.RenameEvent:
+		s.EventName = op.ActionMetadata["new_name"]
+	case core.SetState:
+		// lifecycle states don't change the columns
+		return nil
+	case core.DeprecateColumn:
+		for _, existingCol := range s.Columns {
+			if existingCol.OutboundName == op.Name {
+				// deprecation doesn't change the column
+				return nil
+			}
+		}
+		return fmt.Errorf("Outbound column '%s' does not exists in schema, cannot deprecate non-existent column.", op.Name)
 	default:
 		return fmt.Errorf("Error, unsupported operation action %s.", op.Action)
 	}
@@ -0,0 +1,37 @@
+package bpdb
+
+import (
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// schemasAsOf returns the schemas of the published and deprecated events as
+// they were once the operation numbered `sequence` was committed, given every
+// operation row in order. Events renamed since have the name they had then.
+func schemasAsOf(rows []operationRow, sequence int64) ([]scoop_protocol.Config, error) {
+	// rows are stored under the current name of their event, so the name it
+	// had then is the old name of its first later rename
+	names := make(map[string]string)
+	for _, row := range rows {
+		if row.sequence > sequence && row.action == string(core.RenameEvent) {
+			if _, ok := names[row.event]; !ok {
+				names[row.event] = row.name
+			}
+		}
+	}
+	committed := make([]operationRow, 0, len(rows))
+	for _, row := range rows {
+		if row.sequence > sequence {
+			continue
+		}
+		if name, ok := names[row.event]; ok {
+			row.event = name
+		}
+		committed = append(committed, row)
+	}
+	schemas, err := generateEventSchemas(committed, LiveStates)
+	if err != nil {
+		return nil, err
+	}
+	return liveConfigs(schemas), nil
+}
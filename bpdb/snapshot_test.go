@@ -0,0 +1,106 @@
+package bpdb
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// snapshotNames returns the name and version of each schema, in order
+func snapshotNames(cfgs []scoop_protocol.Config) []string {
+	names := []string{}
+	for _, cfg := range cfgs {
+		names = append(names, fmt.Sprintf("%s@%d", cfg.EventName, cfg.Version))
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lastSequence returns the sequence number of the latest version of the event
+func lastSequence(t *testing.T, b Bpdb, name string) int64 {
+	versions, err := b.Versions(name)
+	if err != nil || len(versions) == 0 {
+		t.Fatalf("Expected versions of %s, got %v (err %v).", name, versions, err)
+	}
+	return versions[len(versions)-1].Sequence
+}
+
+func TestSchemasAsOf(t *testing.T) {
+	b := NewMemoryBackend()
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	created := lastSequence(t, b, "video_play")
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes"}})
+	if err != nil {
+		t.Fatalf("Expected no error updating schema, got %v.", err)
+	}
+	updated := lastSequence(t, b, "video_play")
+	req := testCreateRequest()
+	req.EventName = "video_pause"
+	req.Draft = true
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating draft, got %v.", err)
+	}
+	err = b.RenameEvent(&core.ClientRenameEventRequest{EventName: "video_play", NewName: "playback"})
+	if err != nil {
+		t.Fatalf("Expected no error renaming event, got %v.", err)
+	}
+	err = b.SetEventState(&core.ClientSetEventStateRequest{EventName: "video_pause", State: core.StatePublished})
+	if err != nil {
+		t.Fatalf("Expected no error publishing, got %v.", err)
+	}
+	latest := lastSequence(t, b, "video_pause")
+
+	tests := []struct {
+		sequence int64
+		expected []string
+	}{
+		{0, []string{}},
+		{created, []string{"video_play@0"}},
+		{updated, []string{"video_play@1"}},
+		{latest - 1, []string{"playback@2"}},
+		{latest, []string{"playback@2", "video_pause@1"}},
+	}
+	for _, test := range tests {
+		cfgs, err := b.SchemasAsOf(test.sequence)
+		if names := snapshotNames(cfgs); err != nil || !reflect.DeepEqual(names, test.expected) {
+			t.Errorf("Expected %v as of %d, got %v (err %v).", test.expected, test.sequence, names, err)
+		}
+	}
+	cfgs, err := b.SchemasAsOf(created)
+	if err != nil || len(cfgs) != 1 || len(cfgs[0].Columns) != 3 {
+		t.Errorf("Expected the columns as they were, got %v (err %v).", cfgs, err)
+	}
+}
+
+func TestSequenceAt(t *testing.T) {
+	b := NewMemoryBackend()
+	before := time.Now().Add(-time.Minute)
+	_, err := b.CreateSchema(testCreateRequest())
+	if err != nil {
+		t.Fatalf("Expected no error creating schema, got %v.", err)
+	}
+	sequence, err := b.SequenceAt(before)
+	if err != nil || sequence != 0 {
+		t.Errorf("Expected no operations before the schema was created, got %d (err %v).", sequence, err)
+	}
+	sequence, err = b.SequenceAt(time.Now().Add(time.Minute))
+	if err != nil || sequence != lastSequence(t, b, "video_play") {
+		t.Errorf("Expected the latest sequence number, got %d (err %v).", sequence, err)
+	}
+
+	// operations written before changes were audited count as committed at any time
+	delete(b.(*memoryBackend).audits, "video_play")
+	sequence, err = b.SequenceAt(before)
+	if err != nil || sequence != lastSequence(t, b, "video_play") {
+		t.Errorf("Expected unaudited operations at any time, got %d (err %v).", sequence, err)
+	}
+}
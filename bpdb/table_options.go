@@ -0,0 +1,124 @@
+package bpdb
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// applyTableOperation updates the table options of a schema for an operation.
+// Sort keys are kept pointing at renamed columns.
+func applyTableOperation(opts *core.TableOptions, op scoop_protocol.Operation) error {
+	switch op.Action {
+	case core.SetTableOptions:
+		newOpts, err := core.OperationTableOptions(op)
+		if err != nil {
+			return fmt.Errorf("Error reading table options: %v", err)
+		}
+		*opts = newOpts
+	case scoop_protocol.RENAME:
+		for i, key := range opts.SortKeys {
+			if key == op.Name {
+				opts.SortKeys[i] = op.ActionMetadata["new_outbound"]
+			}
+		}
+	}
+	return nil
+}
+
+// validateTableOptions validates the table options against the keys of the
+// columns of the schema. The options of added and changed columns are
+// validated by their operations, so those of existing columns aren't parsed.
+func validateTableOptions(schema *scoop_protocol.Config, opts core.TableOptions) error {
+	err := opts.Validate()
+	if err != nil {
+		return err
+	}
+	sortKeys := make(map[string]bool, len(opts.SortKeys))
+	for _, key := range opts.SortKeys {
+		sortKeys[key] = true
+	}
+	distKeys := []string{}
+	for _, col := range schema.Columns {
+		distKey, sortKey := core.ColumnKeys(col.ColumnCreationOptions)
+		if distKey {
+			distKeys = append(distKeys, col.OutboundName)
+		}
+		if sortKey && len(sortKeys) > 0 && !sortKeys[col.OutboundName] {
+			return fmt.Errorf("column %s is a sortkey but not one of the table's sort keys", col.OutboundName)
+		}
+		delete(sortKeys, col.OutboundName)
+	}
+	for key := range sortKeys {
+		return fmt.Errorf("sort key %s is not a column", key)
+	}
+	switch {
+	case opts.DistStyle == core.DistStyleKey && len(distKeys) != 1:
+		return fmt.Errorf("dist style %s needs exactly one distkey column, found %v", opts.DistStyle, distKeys)
+	case (opts.DistStyle == core.DistStyleEven || opts.DistStyle == core.DistStyleAll) && len(distKeys) > 0:
+		return fmt.Errorf("dist style %s can't have a distkey column, found %v", opts.DistStyle, distKeys)
+	case len(distKeys) > 1:
+		return fmt.Errorf("a table can have only one distkey column, found %v", distKeys)
+	}
+	return nil
+}
+
+// validateTableOptionsChange validates changing the table options of an
+// existing table. Redshift can't change the backup setting or interleaved
+// sort keys of a table after it is created.
+func validateTableOptionsChange(old core.TableOptions, new core.TableOptions) error {
+	if !reflect.DeepEqual(old.Backup, new.Backup) {
+		return fmt.Errorf("table backup can only be set when the table is created")
+	}
+	interleaved := old.SortStyle == core.SortStyleInterleaved || new.SortStyle == core.SortStyleInterleaved
+	if interleaved && (old.SortStyle != new.SortStyle || !reflect.DeepEqual(old.SortKeys, new.SortKeys)) {
+		return fmt.Errorf("interleaved sort keys can only be set when the table is created")
+	}
+	return nil
+}
+
+// replayVersions replays the versions of a schema up to and including
+// `version`, returning the schema under the name it had then and its table
+// options
+func replayVersions(eventName string, versions []SchemaVersion, version int) (*scoop_protocol.Config, core.TableOptions, error) {
+	schema := &scoop_protocol.Config{EventName: createdName(eventName, versions)}
+	opts := core.TableOptions{}
+	for _, v := range versions {
+		if v.Version > version {
+			break
+		}
+		for _, op := range v.Operations {
+			err := ApplyOperation(schema, op)
+			if err != nil {
+				return nil, opts, err
+			}
+			err = applyTableOperation(&opts, op)
+			if err != nil {
+				return nil, opts, err
+			}
+		}
+		schema.Version = v.Version
+	}
+	return schema, opts, nil
+}
+
+// TableOptionsAtVersion returns the table options of the schema `name` as of
+// `version`, which may be CurrentVersion. It returns nil if there is no such
+// version.
+func TableOptionsAtVersion(b Bpdb, name string, version int) (*core.TableOptions, error) {
+	versions, err := versionsWith(b, name, version)
+	if err != nil {
+		return nil, err
+	}
+	version = resolveVersion(versions, version)
+	if len(versions) == 0 || version < 0 || versions[len(versions)-1].Version < version {
+		return nil, nil
+	}
+	_, opts, err := replayVersions(name, versions, version)
+	if err != nil {
+		return nil, err
+	}
+	return &opts, nil
+}
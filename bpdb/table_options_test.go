@@ -0,0 +1,62 @@
+package bpdb
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+func TestTableOptions(t *testing.T) {
+	b := NewMemoryBackend()
+	req := testCreateRequest()
+	req.TableOptions = &core.TableOptions{DistStyle: core.DistStyleKey}
+	_, err := b.CreateSchema(req)
+	if err == nil {
+		t.Error("Expected error creating schema with dist style key and no distkey.")
+	}
+
+	req.Columns[1].ColumnCreationOptions = "(32) distkey"
+	backup := false
+	req.TableOptions.Backup = &backup
+	_, err = b.CreateSchema(req)
+	if err != nil {
+		t.Fatalf("Expected no error creating schema with table options, got %v.", err)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		TableOptions: &core.TableOptions{DistStyle: core.DistStyleKey, SortKeys: []string{"time", "minutes"}, Backup: &backup},
+	})
+	if err != nil {
+		t.Fatalf("Expected no error setting sort keys, got %v.", err)
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Renames: core.Renames{"minutes": "minutes_watched"}})
+	if err != nil {
+		t.Fatalf("Expected no error renaming sort key, got %v.", err)
+	}
+	expected := &core.TableOptions{DistStyle: core.DistStyleKey, SortKeys: []string{"time", "minutes_watched"}, Backup: &backup}
+	opts, err := TableOptionsAtVersion(b, "video_play", CurrentVersion)
+	if err != nil || !reflect.DeepEqual(expected, opts) {
+		t.Errorf("Table options differ from expected (err %v):\n%v\nvs\n%v.", err, opts, expected)
+	}
+
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{EventName: "video_play", Deletes: []string{"minutes_watched"}})
+	if err == nil {
+		t.Error("Expected error deleting a sort key column.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		TableOptions: &core.TableOptions{DistStyle: core.DistStyleKey},
+	})
+	if err == nil {
+		t.Error("Expected error changing table backup.")
+	}
+	_, err = b.UpdateSchema(&core.ClientUpdateSchemaRequest{
+		EventName:    "video_play",
+		TableOptions: &core.TableOptions{DistStyle: core.DistStyleEven, Backup: &backup},
+	})
+	if err == nil {
+		t.Error("Expected error for dist style even with a distkey column.")
+	}
+}
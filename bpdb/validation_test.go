@@ -20,7 +20,7 @@ func TestPreValidateSchemaBadType(t *testing.T) {
 		},
 		Version: 0,
 	}
-	err := preValidateSchema(&cfg)
+	_, err := preValidateSchema(&cfg, nil)
 	if err == nil {
 		t.Error("Expected error on invalid type.")
 	}
@@ -45,7 +45,7 @@ func TestPreValidateSchemaOkay(t *testing.T) {
 		},
 		Version: 0,
 	}
-	err := preValidateSchema(&cfg)
+	_, err := preValidateSchema(&cfg, nil)
 	if err != nil {
 		t.Errorf("Expected no error on valid schema, got %v.", err)
 	}
@@ -67,7 +67,7 @@ func TestPreValidateSchemaManyColumns(t *testing.T) {
 		Columns:   columns,
 		Version:   0,
 	}
-	err := preValidateSchema(&cfg)
+	_, err := preValidateSchema(&cfg, nil)
 	if err == nil {
 		t.Error("Expected error on too many columns.")
 	}
@@ -92,7 +92,7 @@ func TestPreValidateSchemaColumnCollision(t *testing.T) {
 		},
 		Version: 0,
 	}
-	err := preValidateSchema(&cfg)
+	_, err := preValidateSchema(&cfg, nil)
 	if err == nil {
 		t.Error("Expected error on duplicate column.")
 	}
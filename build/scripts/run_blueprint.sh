@@ -11,9 +11,20 @@ aws s3 cp --region us-west-2 "$CONFIG_PREFIX/conf.sh" conf.sh
 aws s3 cp --region us-west-2 "$CONFIG_PREFIX/conf.json" $CONFIG_DIR/conf.json
 source conf.sh
 
+# readonly instances serve reads from the replica, if there is one, while
+# others read their own writes from the primary
+REPLICA_URL=""
+if [[ " $* " == *" -readonly=true "* ]]; then
+  REPLICA_URL="${BLUEPRINT_DB_REPLICA_URL:-}"
+fi
+
+# only the deployer started with MIGRATE_BPDB=true applies bpdb migrations,
+# so that processes reading the db never write to it
 exec ./blueprint "$@"                                        \
   -enableAuth=${ENABLE_AUTH}                                \
   -bpdbConnection="${BLUEPRINT_DB_URL}"                      \
+  -bpdbReplicaConnection="${REPLICA_URL}"                    \
+  -migrate=${MIGRATE_BPDB:-false}                            \
   -cookieSecret=${COOKIE_SECRET}                             \
   -clientID=${CLIENT_ID}                                     \
   -clientSecret=${CLIENT_SECRET}                             \
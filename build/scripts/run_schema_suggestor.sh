@@ -16,5 +16,6 @@ export GOMAXPROCS="2"
 
 exec ./schema_suggestor \
   -bpdbConnection="${BLUEPRINT_DB_URL}" \
+  -bpdbReplicaConnection="${BLUEPRINT_DB_REPLICA_URL:-}" \
   -staticfiles="${SCIENCE_DIR}/nginx/html/events" \
   -nonTrackedQueue="${NONTRACKED_QUEUE}"
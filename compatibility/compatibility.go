@@ -0,0 +1,98 @@
+// Package compatibility defines the policies for how a schema may evolve
+// without breaking the consumers of its table. A mode can be set for every
+// event, and overridden for individual events, in the blueprint config.
+package compatibility
+
+import (
+	"fmt"
+	"sync"
+)
+
+// Mode is a compatibility policy for schema changes
+type Mode string
+
+// Compatibility modes, from least to most restrictive of existing columns
+const (
+	// None allows every valid change.
+	None Mode = "none"
+
+	// Backward keeps every query on the existing columns working: columns
+	// can't be deleted or renamed, and types can only be widened.
+	Backward Mode = "backward"
+
+	// Full is Backward, and also keeps the values of existing columns
+	// readable as before: types and inbound properties can't be changed, and
+	// added columns must be nullable.
+	Full Mode = "full"
+
+	// AdditiveOnly allows nothing but adding nullable columns.
+	AdditiveOnly Mode = "additive-only"
+)
+
+// Policy is the mode for each event
+type Policy struct {
+	// Default is the mode of events not in Events, None if empty.
+	Default Mode `json:",omitempty"`
+
+	Events map[string]Mode `json:",omitempty"`
+}
+
+var (
+	currentLock sync.RWMutex
+	current     = &Policy{}
+)
+
+// Validate returns an error if the policy has an unknown mode
+func (p *Policy) Validate() error {
+	err := p.Default.validate()
+	if err != nil {
+		return fmt.Errorf("default: %v", err)
+	}
+	for event, mode := range p.Events {
+		err = mode.validate()
+		if err != nil {
+			return fmt.Errorf("event %s: %v", event, err)
+		}
+	}
+	return nil
+}
+
+func (m Mode) validate() error {
+	switch m {
+	case "", None, Backward, Full, AdditiveOnly:
+		return nil
+	}
+	return fmt.Errorf("unknown compatibility mode %q", m)
+}
+
+// Mode returns the compatibility mode of the event. If it has none of its own
+// and was renamed, the mode of the latest of its previous names that has one
+// is used, so renaming an event keeps its mode.
+func (p *Policy) Mode(event string, previousNames ...string) Mode {
+	if mode, ok := p.Events[event]; ok && mode != "" {
+		return mode
+	}
+	for i := len(previousNames) - 1; i >= 0; i-- {
+		if mode, ok := p.Events[previousNames[i]]; ok && mode != "" {
+			return mode
+		}
+	}
+	if p.Default == "" {
+		return None
+	}
+	return p.Default
+}
+
+// Current returns the policy in use.
+func Current() *Policy {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
+
+// Use sets the policy in use.
+func Use(p *Policy) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = p
+}
@@ -0,0 +1,41 @@
+package compatibility
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestPolicyMode(t *testing.T) {
+	p := &Policy{}
+	if p.Mode("video_play") != None {
+		t.Errorf("Expected %s by default, got %s.", None, p.Mode("video_play"))
+	}
+	p = &Policy{Default: Backward, Events: map[string]Mode{"video_play": Full}}
+	if p.Mode("video_play") != Full || p.Mode("minute_watched") != Backward {
+		t.Errorf("Expected per event mode to override default, got %s and %s.", p.Mode("video_play"), p.Mode("minute_watched"))
+	}
+	p = &Policy{Default: Backward, Events: map[string]Mode{"video_play": Full, "playback": AdditiveOnly}}
+	if p.Mode("video_start", "video_play") != Full || p.Mode("video_start", "video_play", "playback") != AdditiveOnly {
+		t.Errorf("Expected the mode of the latest previous name, got %s and %s.", p.Mode("video_start", "video_play"), p.Mode("video_start", "video_play", "playback"))
+	}
+	if p.Mode("playback", "video_play") != AdditiveOnly || p.Mode("video_start", "minute_watched") != Backward {
+		t.Errorf("Expected the event's own mode, then the default, got %s and %s.", p.Mode("playback", "video_play"), p.Mode("video_start", "minute_watched"))
+	}
+}
+
+func TestPolicyFromConfig(t *testing.T) {
+	var p Policy
+	err := json.Unmarshal([]byte(`{"Default": "backward", "Events": {"video_play": "additive-only"}}`), &p)
+	if err != nil || p.Validate() != nil || p.Mode("video_play") != AdditiveOnly || p.Mode("minute_watched") != Backward {
+		t.Errorf("Unexpected policy from config: %v (err %v, validation %v).", p, err, p.Validate())
+	}
+
+	p = Policy{}
+	err = json.Unmarshal([]byte(`{"Default": "forward"}`), &p)
+	if err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+	if p.Validate() == nil {
+		t.Error("Expected error validating unknown mode.")
+	}
+}
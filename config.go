@@ -0,0 +1,42 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/twitchscience/blueprint/compatibility"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/blueprint/transformers"
+)
+
+// config is the -config file. It is read once on start, and each package is
+// given its section.
+type config struct {
+	// Transformers replaces the built in transformer registry if set.
+	Transformers []transformers.Transformer `json:"transformers"`
+
+	// Lint tunes the lint rules by ID.
+	Lint lint.Config `json:"lint"`
+
+	// Compatibility is the compatibility mode of each event.
+	Compatibility compatibility.Policy `json:"compatibility"`
+}
+
+// loadConfig reads the config file. Every section is empty if the file
+// doesn't exist.
+func loadConfig(filename string) (*config, error) {
+	var cfg config
+	b, err := ioutil.ReadFile(filename)
+	if os.IsNotExist(err) {
+		return &cfg, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("Error reading config from %s: %v", filename, err)
+	}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing config in %s: %v", filename, err)
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,177 @@
+package core
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// ValidEncodings are the Redshift compression encodings a column can use.
+var ValidEncodings = []string{
+	"raw", "az64", "bytedict", "delta", "delta32k", "lzo",
+	"mostly8", "mostly16", "mostly32", "runlength", "text255", "text32k", "zstd",
+}
+
+var (
+	lengthOption    = regexp.MustCompile(`^\((\d+)\)$`)
+	precisionOption = regexp.MustCompile(`^\((\d+),(\d+)\)$`)
+)
+
+// ColumnOptions are the creation options of a column. They are stored as the
+// legacy ColumnCreationOptions string, e.g. "(32) distkey".
+type ColumnOptions struct {
+	// Length is the length of a variable length type like varchar, or 0.
+	Length int `json:",omitempty"`
+
+	// Precision and Scale are the precision of a fixed point type like
+	// decimal, or 0. Only transformers taking a precision accept them.
+	Precision int `json:",omitempty"`
+	Scale     int `json:",omitempty"`
+
+	DistKey bool `json:",omitempty"`
+	SortKey bool `json:",omitempty"`
+
+	// Encoding is the compression encoding of the column, or empty for the default.
+	Encoding string `json:",omitempty"`
+
+	NotNull bool `json:",omitempty"`
+}
+
+// ParseColumnOptions parses a ColumnCreationOptions string. Anything other
+// than the options ColumnOptions can represent is rejected.
+func ParseColumnOptions(s string) (ColumnOptions, error) {
+	var opts ColumnOptions
+	seen := make(map[string]bool)
+	tokens := strings.Fields(strings.ToLower(s))
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		option := token
+		switch {
+		case lengthOption.MatchString(token):
+			option = "length"
+			opts.Length, _ = strconv.Atoi(lengthOption.FindStringSubmatch(token)[1])
+			if opts.Length < 1 {
+				return ColumnOptions{}, fmt.Errorf("length must be positive, given %s", token)
+			}
+		case precisionOption.MatchString(token):
+			option = "length"
+			match := precisionOption.FindStringSubmatch(token)
+			opts.Precision, _ = strconv.Atoi(match[1])
+			opts.Scale, _ = strconv.Atoi(match[2])
+			if opts.Precision < 1 || opts.Scale > opts.Precision {
+				return ColumnOptions{}, fmt.Errorf("precision must be positive and at least the scale, given %s", token)
+			}
+		case token == "distkey":
+			opts.DistKey = true
+		case token == "sortkey":
+			opts.SortKey = true
+		case token == "encode":
+			if i+1 == len(tokens) || !validEncoding(tokens[i+1]) {
+				return ColumnOptions{}, fmt.Errorf("encode must be followed by one of %v", ValidEncodings)
+			}
+			i++
+			opts.Encoding = tokens[i]
+		case token == "not" && i+1 < len(tokens) && tokens[i+1] == "null":
+			option = "null"
+			i++
+			opts.NotNull = true
+		case token == "null":
+		default:
+			return ColumnOptions{}, fmt.Errorf("unsupported column option %q", token)
+		}
+		if seen[option] {
+			return ColumnOptions{}, fmt.Errorf("column option %q given twice", option)
+		}
+		seen[option] = true
+	}
+	return opts, nil
+}
+
+// ColumnKeys reports whether a ColumnCreationOptions string makes the column a
+// distkey or a sortkey. Unlike ParseColumnOptions it accepts any string, so it
+// can be used on the options of existing columns, which may predate parsing.
+func ColumnKeys(s string) (distKey bool, sortKey bool) {
+	for _, token := range strings.Fields(strings.ToLower(s)) {
+		switch token {
+		case "distkey":
+			distKey = true
+		case "sortkey":
+			sortKey = true
+		}
+	}
+	return distKey, sortKey
+}
+
+func validEncoding(encoding string) bool {
+	for _, valid := range ValidEncodings {
+		if encoding == valid {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate checks that the options could have been parsed from a string.
+func (o ColumnOptions) Validate() error {
+	_, err := ParseColumnOptions(o.String())
+	if err != nil {
+		return err
+	}
+	switch {
+	case o.Length < 0 || o.Precision < 0 || o.Scale < 0:
+		return fmt.Errorf("length and precision can't be negative")
+	case o.Length > 0 && o.Precision > 0:
+		return fmt.Errorf("a column can't have both a length and a precision")
+	case o.Scale > 0 && o.Precision == 0:
+		return fmt.Errorf("a column can't have a scale without a precision")
+	case o.Encoding != "" && !validEncoding(o.Encoding):
+		return fmt.Errorf("encoding must be one of %v, given %q", ValidEncodings, o.Encoding)
+	}
+	return nil
+}
+
+// String returns the legacy ColumnCreationOptions string for the options.
+func (o ColumnOptions) String() string {
+	s := ""
+	if o.Length > 0 {
+		s += fmt.Sprintf("(%d)", o.Length)
+	} else if o.Precision > 0 {
+		s += fmt.Sprintf("(%d,%d)", o.Precision, o.Scale)
+	}
+	if o.DistKey {
+		s += " distkey"
+	}
+	if o.SortKey {
+		s += " sortkey"
+	}
+	if o.Encoding != "" {
+		s += " encode " + o.Encoding
+	}
+	if o.NotNull {
+		s += " not null"
+	}
+	return s
+}
+
+// ResolveColumnOptions returns the ColumnCreationOptions string for a column
+// given either as the legacy string or as structured options. The legacy
+// string is rejected if it can't be parsed, or if it disagrees with the
+// structured options.
+func ResolveColumnOptions(legacy string, options *ColumnOptions) (string, error) {
+	parsed, err := ParseColumnOptions(legacy)
+	if err != nil {
+		return "", err
+	}
+	if options == nil {
+		return legacy, nil
+	}
+	err = options.Validate()
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(legacy) != "" && parsed != *options {
+		return "", fmt.Errorf("ColumnCreationOptions %q disagrees with Options %q", legacy, options.String())
+	}
+	return options.String(), nil
+}
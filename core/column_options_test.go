@@ -0,0 +1,90 @@
+package core
+
+import "testing"
+
+func TestParseColumnOptions(t *testing.T) {
+	for legacy, expected := range map[string]ColumnOptions{
+		"":                        {},
+		"(32)":                    {Length: 32},
+		" sortkey":                {SortKey: true},
+		"(32) distkey":            {Length: 32, DistKey: true},
+		"(32) encode zstd":        {Length: 32, Encoding: "zstd"},
+		" ENCODE lzo NOT NULL":    {Encoding: "lzo", NotNull: true},
+		"(255) sortkey not null ": {Length: 255, SortKey: true, NotNull: true},
+		"(18,4) encode zstd":      {Precision: 18, Scale: 4, Encoding: "zstd"},
+	} {
+		opts, err := ParseColumnOptions(legacy)
+		if err != nil || opts != expected {
+			t.Errorf("Parsing %q: expected %+v, got %+v (err %v).", legacy, expected, opts, err)
+		}
+		reparsed, err := ParseColumnOptions(opts.String())
+		if err != nil || reparsed != opts {
+			t.Errorf("Round trip of %q through %q gave %+v (err %v).", legacy, opts.String(), reparsed, err)
+		}
+	}
+}
+
+func TestParseColumnOptionsRejected(t *testing.T) {
+	for _, legacy := range []string{
+		"(32); DROP TABLE users",
+		"(0)",
+		"(32) (64)",
+		"(0,0)",
+		"(4,18)",
+		"(18,4) (32)",
+		"distkey distkey",
+		"encode",
+		"encode gzip",
+		"default 5",
+		"not",
+	} {
+		_, err := ParseColumnOptions(legacy)
+		if err == nil {
+			t.Errorf("Expected error parsing %q.", legacy)
+		}
+	}
+}
+
+func TestColumnKeys(t *testing.T) {
+	for legacy, expected := range map[string][2]bool{
+		"(32)":                   {false, false},
+		" sortkey":               {false, true},
+		"(32) DISTKEY":           {true, false},
+		"(18,4) distkey sortkey": {true, true},
+		"identity(1,1) sortkey":  {false, true},
+	} {
+		distKey, sortKey := ColumnKeys(legacy)
+		if distKey != expected[0] || sortKey != expected[1] {
+			t.Errorf("Keys of %q: expected %v, got [%v %v].", legacy, expected, distKey, sortKey)
+		}
+	}
+}
+
+func TestResolveColumnOptions(t *testing.T) {
+	resolved, err := ResolveColumnOptions("", &ColumnOptions{Length: 64, DistKey: true})
+	if err != nil || resolved != "(64) distkey" {
+		t.Errorf("Expected options generated from structure, got %q (err %v).", resolved, err)
+	}
+	resolved, err = ResolveColumnOptions("(64)", nil)
+	if err != nil || resolved != "(64)" {
+		t.Errorf("Expected legacy options kept, got %q (err %v).", resolved, err)
+	}
+	_, err = ResolveColumnOptions("(32)", &ColumnOptions{Length: 64})
+	if err == nil {
+		t.Error("Expected error for disagreeing options.")
+	}
+	_, err = ResolveColumnOptions("", &ColumnOptions{Encoding: "zstd; DROP TABLE users"})
+	if err == nil {
+		t.Error("Expected error for invalid encoding.")
+	}
+	resolved, err = ResolveColumnOptions("(10,2)", &ColumnOptions{Precision: 10, Scale: 2})
+	if err != nil || resolved != "(10,2)" {
+		t.Errorf("Expected precision generated from structure, got %q (err %v).", resolved, err)
+	}
+	for _, opts := range []ColumnOptions{{Scale: 2}, {Length: 32, Precision: 10}, {Precision: 2, Scale: 4}} {
+		_, err = ResolveColumnOptions("", &opts)
+		if err == nil {
+			t.Errorf("Expected error for invalid precision %+v.", opts)
+		}
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"sync"
 
 	"github.com/twitchscience/aws_utils/logger"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
 )
 
 // Subprocess represents something that can be set up, started, and stopped. E.g. a server.
@@ -65,16 +66,147 @@ type Column struct {
 	// TODO: length should be an int, currently the client supplies this
 	// to us, so pass through now, with a view to fixing this later
 	Length string `json:"ColumnCreationOptions"`
+
+	// Options are the structured creation options of the column. If given,
+	// Length is generated from them.
+	Options *ColumnOptions `json:",omitempty"`
 }
 
 // Renames is a map of old name to new name, representing a rename operation on
 // a set of columns.
 type Renames map[string]string
 
+// TypeChange changes the type of an existing column, keeping its data.
+type TypeChange struct {
+	// OutboundName is the name of the column to change.
+	OutboundName string `json:"OutboundName"`
+
+	// Transformer is the column's new SQL type.
+	Transformer string `json:"Transformer"`
+
+	// Length is the column's new creation options, as in Column.
+	Length string `json:"ColumnCreationOptions"`
+
+	// Options are the column's new structured creation options, as in Column.
+	Options *ColumnOptions `json:",omitempty"`
+}
+
+// ColumnRemap changes the event property an existing column is populated from.
+type ColumnRemap struct {
+	// OutboundName is the name of the column to remap.
+	OutboundName string `json:"OutboundName"`
+
+	// InboundName is the name of the event property to populate it from.
+	InboundName string `json:"InboundName"`
+
+	// Transformer optionally changes how the property is transformed. It must
+	// output the same SQL type as the column's current transformer.
+	Transformer string `json:"Transformer,omitempty"`
+}
+
+// WriteOptions are the options common to every request that changes a
+// schema.
+type WriteOptions struct {
+	// BaseVersion is the version of the schema the change was made against.
+	// If set, the change is rejected unless it is still the current version.
+	// It has no effect when creating an event.
+	BaseVersion *int `json:",omitempty"`
+
+	// IdempotencyKey identifies the request so that a retry is not applied
+	// twice, even if it renamed the event it was sent to.
+	IdempotencyKey string `json:"-"`
+
+	// Author is the user making the request, or empty if anonymous.
+	Author string `json:"-"`
+
+	// Reason optionally explains why the change was made.
+	Reason string `json:",omitempty"`
+}
+
+// ClientCreateSchemaRequest is a request to create the schema for a new event.
+type ClientCreateSchemaRequest struct {
+	scoop_protocol.Config
+
+	// ColumnOptions are the structured creation options of columns by their
+	// outbound name. The ColumnCreationOptions of each is generated from them.
+	ColumnOptions map[string]ColumnOptions `json:",omitempty"`
+
+	// TableOptions are the table-level settings of the schema, if any.
+	TableOptions *TableOptions `json:",omitempty"`
+
+	// Draft creates the event as a draft, which ingesters don't see until
+	// it is published.
+	Draft bool `json:",omitempty"`
+
+	WriteOptions
+}
+
 // ClientUpdateSchemaRequest is a request to update the schema for an event.
 type ClientUpdateSchemaRequest struct {
 	EventName string `json:"-"`
 	Additions []Column
 	Deletes   []string
 	Renames   Renames
+
+	// TypeChanges change the type of columns by their name before any renames.
+	TypeChanges []TypeChange `json:",omitempty"`
+
+	// Remaps change the inbound property of columns by their name before any
+	// renames.
+	Remaps []ColumnRemap `json:",omitempty"`
+
+	// TableOptions replace the table-level settings of the schema after every
+	// other change, if given.
+	TableOptions *TableOptions `json:",omitempty"`
+
+	// Deprecations deprecate or undeprecate columns by their name before any
+	// other change. A deprecation must end in the future.
+	Deprecations []Deprecation `json:",omitempty"`
+
+	// Operations, if given, are applied in the order given instead of the
+	// changes above, which must then be empty. This allows changes the fixed
+	// order can't express, such as swapping the names of two columns. Only
+	// column adds, deletes, renames, type changes and remaps can be given as
+	// operations; table options and deprecations have their own fields.
+	Operations []scoop_protocol.Operation `json:",omitempty"`
+
+	// Force allows type changes that aren't safe widenings, which may truncate
+	// or fail to convert existing data, and deleting columns before the end of
+	// their grace period.
+	Force bool `json:",omitempty"`
+
+	WriteOptions
+}
+
+// ClientRevertSchemaRequest is a request to restore the schema for an event
+// to an earlier version, by applying the inverse of every later change.
+type ClientRevertSchemaRequest struct {
+	EventName string `json:"-"`
+	ToVersion int
+
+	// DryRun returns the operations the revert would apply without storing them.
+	DryRun bool `json:",omitempty"`
+
+	// Force allows reverting type changes, which narrows the columns.
+	Force bool `json:",omitempty"`
+
+	WriteOptions
+}
+
+// ClientRenameEventRequest is a request to rename an event. NewName must not
+// be the name or alias of another event.
+type ClientRenameEventRequest struct {
+	EventName string `json:"-"`
+	NewName   string
+
+	WriteOptions
+}
+
+// ClientSetEventStateRequest is a request to move an event to another
+// lifecycle state, e.g. to publish a draft.
+type ClientSetEventStateRequest struct {
+	EventName string `json:"-"`
+	State     string
+
+	WriteOptions
 }
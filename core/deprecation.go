@@ -0,0 +1,62 @@
+package core
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// DeprecateColumn is the action of an operation that marks a column deprecated
+// until the end of its grace period, after which it can be deleted. An
+// operation without an end date undeprecates the column. It doesn't change
+// the column.
+const DeprecateColumn scoop_protocol.Action = "deprecate_column"
+
+// ColumnDeprecation records that a column is deprecated
+type ColumnDeprecation struct {
+	// Until is the end of the grace period, after which the column can be deleted.
+	Until time.Time
+
+	// Version is the version of the schema the column was deprecated in.
+	Version int
+}
+
+// Expired reports whether the grace period of the column has ended by `now`
+func (d ColumnDeprecation) Expired(now time.Time) bool {
+	return !now.Before(d.Until)
+}
+
+// Deprecation deprecates a column in a schema update, or undeprecates it if
+// Until is nil
+type Deprecation struct {
+	OutboundName string
+	Until        *time.Time `json:",omitempty"`
+}
+
+// NewDeprecateColumnOperation returns the operation deprecating the column
+// `outbound` until `until`, or undeprecating it if `until` is nil.
+func NewDeprecateColumnOperation(outbound string, until *time.Time) scoop_protocol.Operation {
+	metadata := map[string]string{"until": ""}
+	if until != nil {
+		metadata["until"] = until.UTC().Format(time.RFC3339)
+	}
+	return scoop_protocol.Operation{
+		Action:         DeprecateColumn,
+		Name:           outbound,
+		ActionMetadata: metadata,
+	}
+}
+
+// OperationDeprecationUntil returns the end of the grace period set by a
+// deprecate_column operation, or nil if it undeprecates the column
+func OperationDeprecationUntil(op scoop_protocol.Operation) (*time.Time, error) {
+	if op.ActionMetadata["until"] == "" {
+		return nil, nil
+	}
+	until, err := time.Parse(time.RFC3339, op.ActionMetadata["until"])
+	if err != nil {
+		return nil, fmt.Errorf("grace period end %q can't be parsed: %v", op.ActionMetadata["until"], err)
+	}
+	return &until, nil
+}
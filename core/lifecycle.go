@@ -0,0 +1,70 @@
+package core
+
+import (
+	"fmt"
+
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// SetState is the action of an operation that moves an event to another
+// lifecycle state. It doesn't change the columns.
+const SetState scoop_protocol.Action = "set_state"
+
+// Lifecycle states of an event
+const (
+	// StateDraft events can be edited freely and aren't seen by ingesters.
+	StateDraft = "draft"
+
+	// StatePublished events are ingested. Events are published when created
+	// unless created as drafts.
+	StatePublished = "published"
+
+	// StateDeprecated events are still ingested, but warned about.
+	StateDeprecated = "deprecated"
+
+	// StateRetired events aren't ingested and can't be changed, but keep
+	// their history.
+	StateRetired = "retired"
+)
+
+// States are the lifecycle states, in the order events move through them
+var States = []string{StateDraft, StatePublished, StateDeprecated, StateRetired}
+
+// stateTransitions are the states each state can move to
+var stateTransitions = map[string][]string{
+	StateDraft:      {StatePublished, StateRetired},
+	StatePublished:  {StateDeprecated, StateRetired},
+	StateDeprecated: {StatePublished, StateRetired},
+	StateRetired:    {},
+}
+
+// NewSetStateOperation returns the operation moving an event to `state`.
+func NewSetStateOperation(state string) scoop_protocol.Operation {
+	return scoop_protocol.Operation{
+		Action: SetState,
+		Name:   "",
+		ActionMetadata: map[string]string{
+			"state": state,
+		},
+	}
+}
+
+// IsState reports whether `state` is a lifecycle state
+func IsState(state string) bool {
+	_, ok := stateTransitions[state]
+	return ok
+}
+
+// ValidateStateTransition returns an error if an event can't move from the
+// state `from` to `to`
+func ValidateStateTransition(from string, to string) error {
+	if !IsState(to) {
+		return fmt.Errorf("unknown state %q", to)
+	}
+	for _, state := range stateTransitions[from] {
+		if state == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("a %s event can't be made %s", from, to)
+}
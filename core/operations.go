@@ -0,0 +1,53 @@
+package core
+
+import "github.com/twitchscience/scoop_protocol/scoop_protocol"
+
+// ChangeType is the action of an operation that changes the transformer and
+// column options of a column in place, keeping its data.
+const ChangeType scoop_protocol.Action = "change_type"
+
+// NewChangeTypeOperation returns the operation changing the transformer and
+// column options of the column `outbound`.
+func NewChangeTypeOperation(outbound, type_, options string) scoop_protocol.Operation {
+	return scoop_protocol.Operation{
+		Action: ChangeType,
+		Name:   outbound,
+		ActionMetadata: map[string]string{
+			"column_type":    type_,
+			"column_options": options,
+		},
+	}
+}
+
+// Remap is the action of an operation that changes the inbound property, and
+// optionally the transformer, an existing column is populated from.
+const Remap scoop_protocol.Action = "remap"
+
+// NewRemapOperation returns the operation populating the column `outbound`
+// from the property `inbound`. An empty `type_` keeps the column's transformer.
+func NewRemapOperation(outbound, inbound, type_ string) scoop_protocol.Operation {
+	return scoop_protocol.Operation{
+		Action: Remap,
+		Name:   outbound,
+		ActionMetadata: map[string]string{
+			"inbound":     inbound,
+			"column_type": type_,
+		},
+	}
+}
+
+// RenameEvent is the action of an operation that renames the event, and its
+// table, keeping its history. The operation's name is the old event name.
+const RenameEvent scoop_protocol.Action = "rename_event"
+
+// NewRenameEventOperation returns the operation renaming the event `oldName`
+// to `newName`.
+func NewRenameEventOperation(oldName, newName string) scoop_protocol.Operation {
+	return scoop_protocol.Operation{
+		Action: RenameEvent,
+		Name:   oldName,
+		ActionMetadata: map[string]string{
+			"new_name": newName,
+		},
+	}
+}
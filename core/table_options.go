@@ -0,0 +1,114 @@
+package core
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// SetTableOptions is the action of an operation that replaces the table-level
+// options of a schema. It names no column.
+const SetTableOptions scoop_protocol.Action = "table_options"
+
+// Dist styles of a table.
+const (
+	DistStyleKey  = "key"
+	DistStyleEven = "even"
+	DistStyleAll  = "all"
+)
+
+// Sort styles of a table's sort keys.
+const (
+	SortStyleCompound    = "compound"
+	SortStyleInterleaved = "interleaved"
+)
+
+// maxInterleavedSortKeys is the most columns Redshift allows in an interleaved sort key.
+const maxInterleavedSortKeys = 8
+
+// TableOptions are the table-level settings of a schema.
+type TableOptions struct {
+	// DistStyle is how rows are distributed, or empty for the Redshift
+	// default. With DistStyleKey, exactly one column must be a distkey.
+	DistStyle string `json:",omitempty"`
+
+	// SortStyle is how the SortKeys are combined, compound if empty.
+	SortStyle string `json:",omitempty"`
+
+	// SortKeys are the outbound names of the sort key columns, in order.
+	SortKeys []string `json:",omitempty"`
+
+	// Backup is whether the table is included in snapshots, or nil for the default.
+	Backup *bool `json:",omitempty"`
+}
+
+// Validate checks the options without regard to the columns of the schema.
+func (o TableOptions) Validate() error {
+	switch o.DistStyle {
+	case "", DistStyleKey, DistStyleEven, DistStyleAll:
+	default:
+		return fmt.Errorf("dist style must be %s, %s or %s, given %q", DistStyleKey, DistStyleEven, DistStyleAll, o.DistStyle)
+	}
+	switch o.SortStyle {
+	case "", SortStyleCompound:
+	case SortStyleInterleaved:
+		if len(o.SortKeys) > maxInterleavedSortKeys {
+			return fmt.Errorf("interleaved sort keys can have at most %d columns, given %d", maxInterleavedSortKeys, len(o.SortKeys))
+		}
+	default:
+		return fmt.Errorf("sort style must be %s or %s, given %q", SortStyleCompound, SortStyleInterleaved, o.SortStyle)
+	}
+	if o.SortStyle != "" && len(o.SortKeys) == 0 {
+		return fmt.Errorf("sort style given without sort keys")
+	}
+	seen := make(map[string]bool)
+	for _, key := range o.SortKeys {
+		if key == "" || strings.Contains(key, ",") {
+			return fmt.Errorf("invalid sort key %q", key)
+		}
+		if seen[key] {
+			return fmt.Errorf("sort key %s given twice", key)
+		}
+		seen[key] = true
+	}
+	return nil
+}
+
+// NewTableOptionsOperation returns the operation setting the table options.
+func NewTableOptionsOperation(opts TableOptions) scoop_protocol.Operation {
+	metadata := map[string]string{
+		"dist_style": opts.DistStyle,
+		"sort_style": opts.SortStyle,
+		"sort_keys":  strings.Join(opts.SortKeys, ","),
+		"backup":     "",
+	}
+	if opts.Backup != nil {
+		metadata["backup"] = strconv.FormatBool(*opts.Backup)
+	}
+	return scoop_protocol.Operation{
+		Action:         SetTableOptions,
+		Name:           "",
+		ActionMetadata: metadata,
+	}
+}
+
+// OperationTableOptions returns the table options set by a SetTableOptions operation.
+func OperationTableOptions(op scoop_protocol.Operation) (TableOptions, error) {
+	opts := TableOptions{
+		DistStyle: op.ActionMetadata["dist_style"],
+		SortStyle: op.ActionMetadata["sort_style"],
+	}
+	if keys := op.ActionMetadata["sort_keys"]; keys != "" {
+		opts.SortKeys = strings.Split(keys, ",")
+	}
+	if backup := op.ActionMetadata["backup"]; backup != "" {
+		b, err := strconv.ParseBool(backup)
+		if err != nil {
+			return TableOptions{}, fmt.Errorf("invalid backup setting %q", backup)
+		}
+		opts.Backup = &b
+	}
+	return opts, opts.Validate()
+}
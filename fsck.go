@@ -0,0 +1,123 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/twitchscience/blueprint/bpdb"
+)
+
+// fsck checks the operation log of the bpdb backend and makes the repairs
+// asked for on the command line. It returns the exit status, which is 1 if
+// any event that isn't quarantined has problems.
+func fsck(args []string) int {
+	flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+	quarantine := flags.String("quarantine", "", "comma separated events to hide from every read, so the others are still served")
+	quarantineBroken := flags.Bool("quarantineBroken", false, "quarantine every event with problems")
+	release := flags.String("release", "", "comma separated quarantined events to serve again once fixed")
+	reason := flags.String("reason", "quarantined by fsck", "why the events are quarantined")
+	seal := flags.Bool("seal", false, "extend the hash chain over unsealed operations, so later changes to them are detected")
+	_ = flags.Parse(args)
+
+	backend, err := newBpdbBackend(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up blueprint db backend: %v\n", err)
+		return 2
+	}
+	for _, event := range splitEvents(*release) {
+		err = bpdb.Release(backend, event)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error releasing %s: %v\n", event, err)
+			return 2
+		}
+		fmt.Printf("Released %s\n", event)
+	}
+
+	report, err := bpdb.Fsck(backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error checking operation log: %v\n", err)
+		return 2
+	}
+	toQuarantine := splitEvents(*quarantine)
+	if *quarantineBroken {
+		toQuarantine = append(toQuarantine, report.Broken()...)
+	}
+	for _, event := range toQuarantine {
+		err = bpdb.Quarantine(backend, event, *reason)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error quarantining %s: %v\n", event, err)
+			return 2
+		}
+		report.Quarantined[event] = *reason
+		fmt.Printf("Quarantined %s\n", event)
+	}
+	if *seal {
+		sealed, err := bpdb.SealHashChain(backend)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error sealing operation log: %v\n", err)
+			return 2
+		}
+		report.Sealed += sealed
+		fmt.Printf("Sealed %d operations\n", sealed)
+	}
+
+	printFsckReport(report)
+	if len(report.Broken()) > 0 {
+		return 1
+	}
+	return 0
+}
+
+// splitEvents splits a comma separated list of events
+func splitEvents(events string) []string {
+	if events == "" {
+		return nil
+	}
+	return strings.Split(events, ",")
+}
+
+// printFsckReport prints the problems found, and how to keep broken events
+// from affecting the others
+func printFsckReport(report *bpdb.FsckReport) {
+	fmt.Printf("Checked %d operations of %d events, %d sealed\n", report.Rows, report.Events, report.Sealed)
+	for _, problem := range report.Problems {
+		if _, ok := report.Quarantined[problem.Event]; ok {
+			fmt.Printf("%s (quarantined)\n", problem)
+		} else {
+			fmt.Println(problem)
+		}
+	}
+	quarantined := make([]string, 0, len(report.Quarantined))
+	for event := range report.Quarantined {
+		quarantined = append(quarantined, event)
+	}
+	sort.Strings(quarantined)
+	for _, event := range quarantined {
+		fmt.Printf("%s is quarantined: %s. Once fixed, serve it again with `fsck -release %s`.\n", event, report.Quarantined[event], event)
+	}
+	broken := report.Broken()
+	if len(broken) > 0 {
+		fmt.Printf("Schema and AllSchemas may fail while these events are broken: %s\n", strings.Join(broken, ", "))
+		fmt.Printf("Serve the other events by quarantining them with `fsck -quarantine %s`, or `fsck -quarantineBroken`.\n", strings.Join(broken, ","))
+	}
+}
+
+// rebuildSchemas rebuilds the materialized current schemas from the operation
+// log and returns the exit status
+func rebuildSchemas() int {
+	backend, err := newBpdbBackend(false)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error setting up blueprint db backend: %v\n", err)
+		return 2
+	}
+	rebuilt, err := bpdb.RebuildSchemas(backend)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error rebuilding current schemas: %v\n", err)
+		return 2
+	}
+	fmt.Printf("Rebuilt current schemas of %d events\n", rebuilt)
+	return 0
+}
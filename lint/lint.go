@@ -0,0 +1,220 @@
+// Package lint checks schemas against a configurable set of rules, such as
+// avoiding Redshift reserved words or overly wide rows. Each rule has a stable
+// ID and reports its findings as errors, which reject a schema change, or
+// warnings.
+package lint
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// Severity is how a finding affects the schema change it was found in
+type Severity string
+
+// Severities of findings
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// Finding is a problem a rule found in a schema
+type Finding struct {
+	Rule     string
+	Severity Severity
+	Column   string `json:",omitempty"` // empty for findings about the whole schema
+	Message  string
+}
+
+// Findings is the list of problems found in a schema. It is an error so the
+// errors found can be returned by validation.
+type Findings []Finding
+
+// RuleConfig enables and tunes a rule in the config file
+type RuleConfig struct {
+	Disabled bool     `json:",omitempty"`
+	Severity Severity `json:",omitempty"` // the rule's default if empty
+	Max      int      `json:",omitempty"` // the rule's default if 0
+}
+
+// Config maps rule IDs to their config. Rules not in the config are enabled
+// with their defaults.
+type Config map[string]RuleConfig
+
+// Linter runs the enabled rules
+type Linter struct {
+	rules []configuredRule
+}
+
+type configuredRule struct {
+	rule
+	severity Severity
+	max      int
+}
+
+var (
+	currentLock sync.RWMutex
+	current     = Default()
+)
+
+// New creates a linter with the rules tuned by config
+func New(config Config) (*Linter, error) {
+	known := make(map[string]bool, len(rules))
+	for _, r := range rules {
+		known[r.id] = true
+	}
+	for id, rc := range config {
+		if !known[id] {
+			return nil, fmt.Errorf("unknown lint rule %q", id)
+		}
+		if rc.Severity != "" && rc.Severity != SeverityError && rc.Severity != SeverityWarning {
+			return nil, fmt.Errorf("lint rule %s has unknown severity %q", id, rc.Severity)
+		}
+		if rc.Max < 0 {
+			return nil, fmt.Errorf("lint rule %s max must be positive, given %d", id, rc.Max)
+		}
+	}
+
+	l := &Linter{}
+	for _, r := range rules {
+		rc := config[r.id]
+		if rc.Disabled {
+			continue
+		}
+		configured := configuredRule{rule: r, severity: r.severity, max: r.max}
+		if rc.Severity != "" {
+			configured.severity = rc.Severity
+		}
+		if rc.Max != 0 {
+			configured.max = rc.Max
+		}
+		l.rules = append(l.rules, configured)
+	}
+	return l, nil
+}
+
+// Default returns a linter running every rule with its defaults
+func Default() *Linter {
+	l, err := New(nil)
+	if err != nil {
+		panic(err)
+	}
+	return l
+}
+
+// Current returns the linter in use.
+func Current() *Linter {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
+
+// Use sets the linter in use.
+func Use(l *Linter) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = l
+}
+
+// Rules returns the IDs of the enabled rules
+func (l *Linter) Rules() []string {
+	ids := make([]string, 0, len(l.rules))
+	for _, r := range l.rules {
+		ids = append(ids, r.id)
+	}
+	return ids
+}
+
+// Lint runs every enabled rule against the schema with the table options,
+// which may be nil.
+func (l *Linter) Lint(cfg *scoop_protocol.Config, opts *core.TableOptions) Findings {
+	if opts == nil {
+		opts = &core.TableOptions{}
+	}
+	findings := Findings{}
+	for _, r := range l.rules {
+		if r.check == nil {
+			continue
+		}
+		for _, f := range r.check(cfg, *opts, r.max) {
+			f.Rule = r.id
+			f.Severity = r.severity
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// LintDeprecations runs every enabled rule about deprecated columns, given
+// the deprecation of each column and the current time.
+func (l *Linter) LintDeprecations(deprecations map[string]core.ColumnDeprecation, now time.Time) Findings {
+	findings := Findings{}
+	for _, r := range l.rules {
+		if r.checkDeprecations == nil {
+			continue
+		}
+		for _, f := range r.checkDeprecations(deprecations, now) {
+			f.Rule = r.id
+			f.Severity = r.severity
+			findings = append(findings, f)
+		}
+	}
+	return findings
+}
+
+// Errors returns the findings with error severity
+func (f Findings) Errors() Findings {
+	return f.filter(SeverityError)
+}
+
+// Warnings returns the findings with warning severity
+func (f Findings) Warnings() Findings {
+	return f.filter(SeverityWarning)
+}
+
+func (f Findings) filter(severity Severity) Findings {
+	filtered := Findings{}
+	for _, finding := range f {
+		if finding.Severity == severity {
+			filtered = append(filtered, finding)
+		}
+	}
+	return filtered
+}
+
+// Since returns the findings that weren't already found by the same rule for
+// the same column in `before`, so that a schema change is only held to
+// account for the problems it introduces.
+func (f Findings) Since(before Findings) Findings {
+	existing := make(map[[2]string]bool, len(before))
+	for _, finding := range before {
+		existing[[2]string{finding.Rule, finding.Column}] = true
+	}
+	introduced := Findings{}
+	for _, finding := range f {
+		if !existing[[2]string{finding.Rule, finding.Column}] {
+			introduced = append(introduced, finding)
+		}
+	}
+	return introduced
+}
+
+func (f Finding) String() string {
+	if f.Column == "" {
+		return fmt.Sprintf("%s: %s", f.Rule, f.Message)
+	}
+	return fmt.Sprintf("%s: column %s %s", f.Rule, f.Column, f.Message)
+}
+
+func (f Findings) Error() string {
+	messages := make([]string, 0, len(f))
+	for _, finding := range f {
+		messages = append(messages, finding.String())
+	}
+	return strings.Join(messages, "; ")
+}
@@ -0,0 +1,158 @@
+package lint
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func testSchema() *scoop_protocol.Config {
+	return &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+			{InboundName: "minutes", OutboundName: "minutes", Transformer: "bigint", ColumnCreationOptions: ""},
+		},
+	}
+}
+
+// rulesFound returns the rule and column of each finding
+func rulesFound(findings Findings) [][2]string {
+	found := [][2]string{}
+	for _, f := range findings {
+		found = append(found, [2]string{f.Rule, f.Column})
+	}
+	return found
+}
+
+func TestLintClean(t *testing.T) {
+	findings := Default().Lint(testSchema(), nil)
+	if len(findings) != 0 {
+		t.Errorf("Expected no findings, got %v.", findings)
+	}
+}
+
+func TestLintRules(t *testing.T) {
+	cfg := testSchema()
+	cfg.Columns[0].ColumnCreationOptions = ""
+	cfg.Columns = append(cfg.Columns,
+		scoop_protocol.ColumnDefinition{InboundName: "user", OutboundName: "user", Transformer: "bigint"},
+		scoop_protocol.ColumnDefinition{InboundName: "Channel", OutboundName: "Channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+		scoop_protocol.ColumnDefinition{InboundName: "page url", OutboundName: "page_url", Transformer: "varchar", ColumnCreationOptions: "(8192)"},
+		scoop_protocol.ColumnDefinition{InboundName: "referrer", OutboundName: "referrer", Transformer: "varchar", ColumnCreationOptions: "(9000)"},
+	)
+	expected := [][2]string{
+		{RuleReservedWord, "user"},
+		{RuleDuplicateColumn, "Channel"},
+		{RuleTimeSortKey, "time"},
+		{RuleSnakeCase, "Channel"},
+		{RuleVarcharLength, "page_url"},
+		{RuleVarcharLength, "referrer"},
+		{RuleInboundName, "page_url"},
+		{RuleRowWidth, ""},
+	}
+	findings := Default().Lint(cfg, nil)
+	if !reflect.DeepEqual(rulesFound(findings), expected) {
+		t.Errorf("Findings differ from expected:\n%v\nvs\n%v.", rulesFound(findings), expected)
+	}
+	if len(findings.Errors()) != 3 || len(findings.Warnings()) != 5 {
+		t.Errorf("Expected 3 errors and 5 warnings, got %v and %v.", findings.Errors(), findings.Warnings())
+	}
+
+	findings = Default().Lint(cfg, &core.TableOptions{SortKeys: []string{"time"}})
+	for _, f := range findings {
+		if f.Rule == RuleTimeSortKey {
+			t.Errorf("Expected time in table sort keys to satisfy %s, got %v.", RuleTimeSortKey, f)
+		}
+	}
+}
+
+func TestLintConfig(t *testing.T) {
+	cfg := testSchema()
+	cfg.Columns[1].OutboundName = "channelName"
+
+	l, err := New(Config{
+		RuleSnakeCase:     {Severity: SeverityError},
+		RuleVarcharLength: {Max: 16},
+		RuleTimeSortKey:   {Disabled: true},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating linter: %v", err)
+	}
+	cfg.Columns = cfg.Columns[1:]
+	expected := [][2]string{{RuleSnakeCase, "channelName"}, {RuleVarcharLength, "channelName"}}
+	findings := l.Lint(cfg, nil)
+	if !reflect.DeepEqual(rulesFound(findings), expected) {
+		t.Errorf("Findings differ from expected:\n%v\nvs\n%v.", rulesFound(findings), expected)
+	}
+	if len(findings.Errors()) != 1 || findings.Errors()[0].Rule != RuleSnakeCase {
+		t.Errorf("Expected configured severity for %s, got %v.", RuleSnakeCase, findings)
+	}
+
+	_, err = New(Config{"no-such-rule": {}})
+	if err == nil {
+		t.Error("Expected error for unknown rule.")
+	}
+	_, err = New(Config{RuleSnakeCase: {Severity: "fatal"}})
+	if err == nil {
+		t.Error("Expected error for unknown severity.")
+	}
+}
+
+func TestLintDeprecations(t *testing.T) {
+	now := time.Date(2017, 3, 1, 0, 0, 0, 0, time.UTC)
+	deprecations := map[string]core.ColumnDeprecation{
+		"minutes": {Until: now.Add(24 * time.Hour)},
+		"channel": {Until: now.Add(-time.Hour)},
+	}
+	expected := [][2]string{{RuleDeprecated, "channel"}, {RuleDeprecated, "minutes"}}
+	findings := Default().LintDeprecations(deprecations, now)
+	if !reflect.DeepEqual(rulesFound(findings), expected) || len(findings.Warnings()) != 2 {
+		t.Errorf("Findings differ from expected:\n%v\nvs\n%v.", findings, expected)
+	}
+	if len(Default().Lint(testSchema(), nil)) != 0 {
+		t.Errorf("Expected deprecation rules to only run on deprecations.")
+	}
+
+	l, err := New(Config{RuleDeprecated: {Disabled: true}})
+	if err != nil {
+		t.Fatalf("Unexpected error creating linter: %v", err)
+	}
+	if findings := l.LintDeprecations(deprecations, now); len(findings) != 0 {
+		t.Errorf("Expected no findings with %s disabled, got %v.", RuleDeprecated, findings)
+	}
+}
+
+func TestFindingsSince(t *testing.T) {
+	before := Findings{{Rule: RuleReservedWord, Column: "user"}, {Rule: RuleRowWidth}}
+	after := Findings{{Rule: RuleReservedWord, Column: "user"}, {Rule: RuleReservedWord, Column: "group"}, {Rule: RuleRowWidth}}
+	expected := Findings{{Rule: RuleReservedWord, Column: "group"}}
+	if !reflect.DeepEqual(after.Since(before), expected) {
+		t.Errorf("Expected only new findings %v, got %v.", expected, after.Since(before))
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	var config Config
+	err := json.Unmarshal([]byte(`{"row-width": {"Disabled": true}}`), &config)
+	if err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+	l, err := New(config)
+	if err != nil {
+		t.Fatalf("Unexpected error creating linter from config: %v", err)
+	}
+	for _, id := range l.Rules() {
+		if id == RuleRowWidth {
+			t.Errorf("Expected %s to be disabled.", RuleRowWidth)
+		}
+	}
+	if len(l.Rules()) != len(rules)-1 {
+		t.Errorf("Expected every other rule enabled, got %v.", l.Rules())
+	}
+}
@@ -0,0 +1,250 @@
+package lint
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/redshift"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// Rule IDs
+const (
+	RuleReservedWord    = "reserved-word"
+	RuleDuplicateColumn = "duplicate-column"
+	RuleTimeSortKey     = "time-sortkey"
+	RuleSnakeCase       = "snake-case"
+	RuleVarcharLength   = "varchar-length"
+	RuleInboundName     = "inbound-name"
+	RuleRowWidth        = "row-width"
+	RuleDeprecated      = "deprecated-column"
+)
+
+// rule checks a schema and returns its findings, without the rule ID or
+// severity. `max` is the configured threshold for rules that take one. Rules
+// about the deprecated columns of a schema set checkDeprecations instead.
+type rule struct {
+	id                string
+	severity          Severity
+	max               int
+	check             func(cfg *scoop_protocol.Config, opts core.TableOptions, max int) []Finding
+	checkDeprecations func(deprecations map[string]core.ColumnDeprecation, now time.Time) []Finding
+}
+
+var rules = []rule{
+	{id: RuleReservedWord, severity: SeverityError, check: checkReservedWords},
+	{id: RuleDuplicateColumn, severity: SeverityError, check: checkDuplicateColumns},
+	{id: RuleTimeSortKey, severity: SeverityWarning, check: checkTimeSortKey},
+	{id: RuleSnakeCase, severity: SeverityWarning, check: checkSnakeCase},
+	{id: RuleVarcharLength, severity: SeverityWarning, max: 4096, check: checkVarcharLength},
+	{id: RuleInboundName, severity: SeverityError, max: 127, check: checkInboundNames},
+	{id: RuleRowWidth, severity: SeverityWarning, max: 16384, check: checkRowWidth},
+	{id: RuleDeprecated, severity: SeverityWarning, checkDeprecations: checkDeprecated},
+}
+
+// reservedWords are the Redshift reserved words, which can't be used as
+// table or column names without quoting
+var reservedWords = map[string]bool{}
+
+func init() {
+	for _, word := range strings.Fields(`
+		aes128 aes256 all allowoverwrite analyse analyze and any array as asc
+		authorization az64 backup between binary blanksasnull both bytedict
+		bzip2 case cast check collate column constraint create credentials
+		cross current_date current_time current_timestamp current_user
+		current_user_id default deferrable deflate defrag delta delta32k desc
+		disable distinct do else emptyasnull enable encode encrypt encryption
+		end except explicit false for foreign freeze from full globaldict256
+		globaldict64k grant group gzip having identity ignore ilike in
+		initially inner intersect interval into is isnull join language
+		leading left like limit localtime localtimestamp lun luns lzo lzop
+		minus mostly16 mostly32 mostly8 natural new not notnull null nulls off
+		offline offset oid old on only open or order outer overlaps parallel
+		partition percent permissions pivot placing primary raw readratio
+		recover references rejectlog resort respect restore right select
+		session_user similar snapshot some sysdate system table tag tdes
+		text255 text32k then timestamp to top trailing true truncatecolumns
+		union unique unnest unpivot user using verbose wallet when where with
+		without`) {
+		reservedWords[word] = true
+	}
+}
+
+var snakeCaseRe = regexp.MustCompile(`^[a-z_][a-z0-9_]*$`)
+
+func checkReservedWords(cfg *scoop_protocol.Config, _ core.TableOptions, _ int) []Finding {
+	findings := []Finding{}
+	if reservedWords[strings.ToLower(cfg.EventName)] {
+		findings = append(findings, Finding{Message: fmt.Sprintf("event name %s is a Redshift reserved word", cfg.EventName)})
+	}
+	for _, col := range cfg.Columns {
+		if reservedWords[strings.ToLower(col.OutboundName)] {
+			findings = append(findings, Finding{Column: col.OutboundName, Message: "is a Redshift reserved word"})
+		}
+	}
+	return findings
+}
+
+// checkDuplicateColumns finds outbound names used more than once. Redshift
+// identifiers are case insensitive, so names differing only by case collide.
+func checkDuplicateColumns(cfg *scoop_protocol.Config, _ core.TableOptions, _ int) []Finding {
+	findings := []Finding{}
+	seen := make(map[string]string, len(cfg.Columns))
+	for _, col := range cfg.Columns {
+		name := strings.ToLower(col.OutboundName)
+		if first, ok := seen[name]; ok {
+			findings = append(findings, Finding{Column: col.OutboundName, Message: fmt.Sprintf("has the same name as column %s", first)})
+			continue
+		}
+		seen[name] = col.OutboundName
+	}
+	return findings
+}
+
+// checkTimeSortKey requires a `time` timestamp column that the table is
+// sorted by, which every ingested event should have.
+func checkTimeSortKey(cfg *scoop_protocol.Config, opts core.TableOptions, _ int) []Finding {
+	for _, col := range cfg.Columns {
+		if col.OutboundName != "time" {
+			continue
+		}
+		if col.Transformer != "f@timestamp@unix" {
+			return []Finding{{Column: col.OutboundName, Message: "should use the f@timestamp@unix transformer"}}
+		}
+		colOpts, err := core.ParseColumnOptions(col.ColumnCreationOptions)
+		if err != nil {
+			return nil
+		}
+		sorted := colOpts.SortKey
+		for _, key := range opts.SortKeys {
+			sorted = sorted || key == col.OutboundName
+		}
+		if !sorted {
+			return []Finding{{Column: col.OutboundName, Message: "should be a sortkey"}}
+		}
+		return nil
+	}
+	return []Finding{{Message: "schema should have a time column that is a sortkey"}}
+}
+
+func checkSnakeCase(cfg *scoop_protocol.Config, _ core.TableOptions, _ int) []Finding {
+	findings := []Finding{}
+	if !snakeCaseRe.MatchString(cfg.EventName) {
+		findings = append(findings, Finding{Message: fmt.Sprintf("event name %s should be snake_case", cfg.EventName)})
+	}
+	for _, col := range cfg.Columns {
+		if !snakeCaseRe.MatchString(col.OutboundName) {
+			findings = append(findings, Finding{Column: col.OutboundName, Message: "should be snake_case"})
+		}
+	}
+	return findings
+}
+
+func checkVarcharLength(cfg *scoop_protocol.Config, _ core.TableOptions, max int) []Finding {
+	findings := []Finding{}
+	for _, col := range cfg.Columns {
+		sqlType, err := redshift.ColumnType(col.Transformer, col.ColumnCreationOptions)
+		if err != nil {
+			continue
+		}
+		if length, ok := varcharLength(sqlType); ok && length > max {
+			findings = append(findings, Finding{Column: col.OutboundName, Message: fmt.Sprintf("is %s, longer than the max of %d", sqlType, max)})
+		}
+	}
+	return findings
+}
+
+// checkInboundNames checks that each inbound name is a property name an
+// event could have: not empty, not too long, and without whitespace or
+// control characters.
+func checkInboundNames(cfg *scoop_protocol.Config, _ core.TableOptions, max int) []Finding {
+	findings := []Finding{}
+	for _, col := range cfg.Columns {
+		var problem string
+		switch {
+		case col.InboundName == "":
+			problem = "has no inbound name"
+		case len(col.InboundName) > max:
+			problem = fmt.Sprintf("inbound name is longer than %d characters", max)
+		case strings.IndexFunc(col.InboundName, func(r rune) bool { return unicode.IsSpace(r) || unicode.IsControl(r) }) >= 0:
+			problem = fmt.Sprintf("inbound name %q has whitespace or control characters", col.InboundName)
+		default:
+			continue
+		}
+		findings = append(findings, Finding{Column: col.OutboundName, Message: problem})
+	}
+	return findings
+}
+
+// checkRowWidth estimates the maximum width of a row in bytes from the
+// column types.
+func checkRowWidth(cfg *scoop_protocol.Config, _ core.TableOptions, max int) []Finding {
+	width := 0
+	for _, col := range cfg.Columns {
+		sqlType, err := redshift.ColumnType(col.Transformer, col.ColumnCreationOptions)
+		if err != nil {
+			continue
+		}
+		width += columnWidth(sqlType)
+	}
+	if width > max {
+		return []Finding{{Message: fmt.Sprintf("rows are estimated to be up to %d bytes wide, more than the max of %d", width, max)}}
+	}
+	return nil
+}
+
+// checkDeprecated finds deprecated columns, which will be deleted at the end
+// of their grace period or can be deleted already
+func checkDeprecated(deprecations map[string]core.ColumnDeprecation, now time.Time) []Finding {
+	findings := []Finding{}
+	for name, d := range deprecations {
+		until := d.Until.UTC().Format(time.RFC3339)
+		if d.Expired(now) {
+			findings = append(findings, Finding{Column: name, Message: fmt.Sprintf("was deprecated until %s and can be deleted", until)})
+		} else {
+			findings = append(findings, Finding{Column: name, Message: fmt.Sprintf("is deprecated until %s", until)})
+		}
+	}
+	sort.Sort(byColumn(findings))
+	return findings
+}
+
+// byColumn sorts findings by the name of their column
+type byColumn []Finding
+
+func (f byColumn) Len() int           { return len(f) }
+func (f byColumn) Swap(i, j int)      { f[i], f[j] = f[j], f[i] }
+func (f byColumn) Less(i, j int) bool { return f[i].Column < f[j].Column }
+
+// varcharLength returns the length of a VARCHAR(n) type
+func varcharLength(sqlType string) (int, bool) {
+	if !strings.HasPrefix(sqlType, "VARCHAR(") || !strings.HasSuffix(sqlType, ")") {
+		return 0, false
+	}
+	length, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(sqlType, "VARCHAR("), ")"))
+	return length, err == nil
+}
+
+// columnWidth returns the maximum width in bytes of a value of the SQL type
+func columnWidth(sqlType string) int {
+	if length, ok := varcharLength(sqlType); ok {
+		return length + 4
+	}
+	switch {
+	case sqlType == "BOOLEAN":
+		return 1
+	case sqlType == "SMALLINT":
+		return 2
+	case sqlType == "INT", sqlType == "REAL":
+		return 4
+	case strings.HasPrefix(sqlType, "DECIMAL("):
+		return 16
+	}
+	return 8
+}
@@ -4,24 +4,88 @@ import (
 	"flag"
 	"os"
 	"os/signal"
+	"strings"
 
 	"github.com/twitchscience/aws_utils/logger"
 	"github.com/twitchscience/blueprint/api"
 	"github.com/twitchscience/blueprint/bpdb"
+	"github.com/twitchscience/blueprint/compatibility"
 	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/lint"
+	"github.com/twitchscience/blueprint/transformers"
 )
 
 var (
-	bpdbConnection = flag.String("bpdbConnection", "", "The connection string for blueprintdb")
+	bpdbConnection = flag.String("bpdbConnection", "", "The connection string for blueprintdb, or file:///path/to/dir for a local file store")
+	bpdbReplica    = flag.String("bpdbReplicaConnection", "", "The connection string for a read replica of blueprintdb to serve schemas and migrations from")
 	staticFileDir  = flag.String("staticfiles", "./static", "the location to serve static files from")
 	configFilename = flag.String("config", "conf.json", "Blueprint config file")
+	inMemoryBpdb   = flag.Bool("inMemoryBpdb", false, "keep schemas in memory instead of blueprintdb; nothing is persisted")
+	cacheSchemas   = flag.Bool("cacheSchemas", false, "cache schemas from blueprintdb, invalidated by postgres notifications")
+	migrateOnStart = flag.Bool("migrate", false, "apply pending blueprintdb migrations before starting")
 )
 
+// newBpdbBackend picks the bpdb backend from the flags and the scheme of the
+// connection string. Postgres backends are wrapped with a schema cache if
+// `cache` is set.
+func newBpdbBackend(cache bool) (bpdb.Bpdb, error) {
+	if *inMemoryBpdb {
+		logger.Warn("Using in-memory blueprint db backend, schemas will be lost on exit")
+		return bpdb.NewMemoryBackend(), nil
+	}
+	if strings.HasPrefix(*bpdbConnection, "file://") {
+		return bpdb.NewFileBackend(strings.TrimPrefix(*bpdbConnection, "file://"))
+	}
+	if *migrateOnStart {
+		_, err := bpdb.MigrateUp(*bpdbConnection)
+		if err != nil {
+			return nil, err
+		}
+	}
+	backend, err := bpdb.NewPostgresBackendWithReplica(*bpdbConnection, *bpdbReplica)
+	if err != nil || !cache {
+		return backend, err
+	}
+	return bpdb.NewCachingBackend(backend, *bpdbConnection)
+}
+
 func main() {
 	logger.Init("info")
 	flag.Parse()
 
-	bpdbBackend, err := bpdb.NewPostgresBackend(*bpdbConnection)
+	switch flag.Arg(0) {
+	case "fsck":
+		os.Exit(fsck(flag.Args()[1:]))
+	case "rebuild-schemas":
+		os.Exit(rebuildSchemas())
+	case "migrate":
+		os.Exit(migrate(flag.Args()[1:]))
+	}
+
+	config, err := loadConfig(*configFilename)
+	if err != nil {
+		logger.WithError(err).Fatal("Error loading config")
+	}
+
+	registry, err := transformers.FromConfig(config.Transformers)
+	if err != nil {
+		logger.WithError(err).Fatal("Error loading transformer registry")
+	}
+	transformers.Use(registry)
+
+	linter, err := lint.New(config.Lint)
+	if err != nil {
+		logger.WithError(err).Fatal("Error loading lint config")
+	}
+	lint.Use(linter)
+
+	err = config.Compatibility.Validate()
+	if err != nil {
+		logger.WithError(err).Fatal("Error loading compatibility policy")
+	}
+	compatibility.Use(&config.Compatibility)
+
+	bpdbBackend, err := newBpdbBackend(*cacheSchemas)
 	if err != nil {
 		logger.WithError(err).Fatal("Error setting up blueprint db backend")
 	}
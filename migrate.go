@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/twitchscience/blueprint/bpdb"
+)
+
+// migrate applies the pending blueprintdb migrations with `up`, or lists
+// every migration and when it was applied with `status`. It returns the exit
+// status, which is 1 for `status` if migrations are pending.
+func migrate(args []string) int {
+	if *inMemoryBpdb || strings.HasPrefix(*bpdbConnection, "file://") {
+		fmt.Fprintln(os.Stderr, "Migrations only apply to the postgres blueprintdb")
+		return 2
+	}
+	command := ""
+	if len(args) == 1 {
+		command = args[0]
+	}
+	switch command {
+	case "up":
+		migrated, err := bpdb.MigrateUp(*bpdbConnection)
+		for _, m := range migrated {
+			fmt.Printf("Applied %d: %s\n", m.Version, m.Description)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error migrating blueprintdb: %v\n", err)
+			return 2
+		}
+		fmt.Printf("Applied %d migrations\n", len(migrated))
+		return 0
+	case "status":
+		status, err := bpdb.SchemaMigrationStatus(*bpdbConnection)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error getting blueprintdb migrations: %v\n", err)
+			return 2
+		}
+		exit := 0
+		for _, m := range status {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format(time.RFC3339)
+			} else {
+				exit = 1
+			}
+			fmt.Printf("%3d  %-45s %s\n", m.Version, m.Description, applied)
+		}
+		return exit
+	default:
+		fmt.Fprintln(os.Stderr, "Usage: blueprint [flags] migrate up|status")
+		return 2
+	}
+}
@@ -0,0 +1,322 @@
+// Package redshift generates the Redshift DDL for blueprint schemas and
+// migrations, so that the statements an ingester will run can be reviewed.
+package redshift
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/blueprint/transformers"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+// quoteIdentifier quotes a table or column name
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// columnType returns the Redshift type of a column with the given transformer
+// and creation options, and the attributes the options give it
+func columnType(name string, transformer string, options string) (string, []string, error) {
+	t, ok := transformers.Current().Get(transformer)
+	if !ok {
+		return "", nil, fmt.Errorf("column %s has unknown transformer %s", name, transformer)
+	}
+	opts, err := core.ParseColumnOptions(options)
+	if err != nil {
+		return "", nil, fmt.Errorf("column %s has invalid options: %v", name, err)
+	}
+	redshiftType, err := t.SQLType(opts)
+	if err != nil {
+		return "", nil, fmt.Errorf("column %s: %v", name, err)
+	}
+
+	attributes := []string{}
+	if opts.Encoding != "" {
+		attributes = append(attributes, "ENCODE "+strings.ToUpper(opts.Encoding))
+	}
+	if opts.DistKey {
+		attributes = append(attributes, "DISTKEY")
+	}
+	if opts.SortKey {
+		attributes = append(attributes, "SORTKEY")
+	}
+	if opts.NotNull {
+		attributes = append(attributes, "NOT NULL")
+	}
+	return redshiftType, attributes, nil
+}
+
+// ColumnType returns the Redshift type of a column with the given transformer
+// and creation options, e.g. `VARCHAR(32)`
+func ColumnType(transformer string, options string) (string, error) {
+	redshiftType, _, err := columnType("", transformer, options)
+	return redshiftType, err
+}
+
+// columnDefinition returns the Redshift definition of a column with the given
+// transformer and creation options, e.g. `"channel" VARCHAR(32) DISTKEY`
+func columnDefinition(name string, transformer string, options string) (string, error) {
+	redshiftType, attributes, err := columnType(name, transformer, options)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(append([]string{quoteIdentifier(name), redshiftType}, attributes...), " "), nil
+}
+
+// CreateTable returns the statement creating the table for a schema with
+// the given table options, which may be nil
+func CreateTable(cfg *scoop_protocol.Config, opts *core.TableOptions) (string, error) {
+	if len(cfg.Columns) == 0 {
+		return "", fmt.Errorf("schema %s has no columns", cfg.EventName)
+	}
+	if opts == nil {
+		opts = &core.TableOptions{}
+	}
+	columns := make([]string, 0, len(cfg.Columns))
+	for _, col := range cfg.Columns {
+		redshiftType, attributes, err := columnType(col.OutboundName, col.Transformer, col.ColumnCreationOptions)
+		if err != nil {
+			return "", err
+		}
+		def := []string{quoteIdentifier(col.OutboundName), redshiftType}
+		for _, attribute := range attributes {
+			// table sort keys replace column sortkeys
+			if attribute != "SORTKEY" || len(opts.SortKeys) == 0 {
+				def = append(def, attribute)
+			}
+		}
+		columns = append(columns, "    "+strings.Join(def, " "))
+	}
+
+	tableAttributes := ""
+	if opts.Backup != nil && !*opts.Backup {
+		tableAttributes += "\nBACKUP NO"
+	}
+	if opts.DistStyle != "" {
+		tableAttributes += "\nDISTSTYLE " + strings.ToUpper(opts.DistStyle)
+	}
+	if len(opts.SortKeys) > 0 {
+		tableAttributes += "\n" + sortKey(opts)
+	}
+	return fmt.Sprintf("CREATE TABLE %s (\n%s\n)%s;", quoteIdentifier(cfg.EventName), strings.Join(columns, ",\n"), tableAttributes), nil
+}
+
+// sortKey returns the table sort key clause for the table options
+func sortKey(opts *core.TableOptions) string {
+	style := core.SortStyleCompound
+	if opts.SortStyle != "" {
+		style = opts.SortStyle
+	}
+	keys := make([]string, 0, len(opts.SortKeys))
+	for _, key := range opts.SortKeys {
+		keys = append(keys, quoteIdentifier(key))
+	}
+	return fmt.Sprintf("%s SORTKEY (%s)", strings.ToUpper(style), strings.Join(keys, ", "))
+}
+
+// distStyle returns the dist style clause of a table with the columns of the
+// schema and the table options
+func distStyle(schema *scoop_protocol.Config, opts core.TableOptions) string {
+	distKey := ""
+	for _, col := range schema.Columns {
+		if colDistKey, _ := core.ColumnKeys(col.ColumnCreationOptions); colDistKey {
+			distKey = col.OutboundName
+		}
+	}
+	switch {
+	case opts.DistStyle == core.DistStyleKey || (opts.DistStyle == "" && distKey != ""):
+		return "DISTSTYLE KEY DISTKEY " + quoteIdentifier(distKey)
+	case opts.DistStyle == "":
+		return "DISTSTYLE AUTO"
+	}
+	return "DISTSTYLE " + strings.ToUpper(opts.DistStyle)
+}
+
+// tableSortKey returns the sort key clause of a table with the columns of the
+// schema and the table options
+func tableSortKey(schema *scoop_protocol.Config, opts core.TableOptions) string {
+	if len(opts.SortKeys) > 0 {
+		return sortKey(&opts)
+	}
+	columnSortKeys := []string{}
+	for _, col := range schema.Columns {
+		if _, colSortKey := core.ColumnKeys(col.ColumnCreationOptions); colSortKey {
+			columnSortKeys = append(columnSortKeys, col.OutboundName)
+		}
+	}
+	if len(columnSortKeys) > 0 {
+		return sortKey(&core.TableOptions{SortKeys: columnSortKeys})
+	}
+	return "SORTKEY NONE"
+}
+
+// alterTableOptions returns the statements changing the dist style and sort
+// keys of an existing table with the columns of the schema from those of the
+// `from` table options to those of `to`. Nothing is emitted for what is
+// unchanged.
+func alterTableOptions(schema *scoop_protocol.Config, from core.TableOptions, to core.TableOptions) []string {
+	prefix := "ALTER TABLE " + quoteIdentifier(schema.EventName)
+	statements := []string{}
+	if style := distStyle(schema, to); style != distStyle(schema, from) {
+		statements = append(statements, fmt.Sprintf("%s ALTER %s;", prefix, style))
+	}
+	// interleaved sort keys can't be altered, and validation keeps them unchanged
+	if key := tableSortKey(schema, to); to.SortStyle != core.SortStyleInterleaved && key != tableSortKey(schema, from) {
+		statements = append(statements, fmt.Sprintf("%s ALTER %s;", prefix, key))
+	}
+	return statements
+}
+
+// isVarcharGrowth returns whether a column of the old type and attributes can
+// be altered in place to the new ones, which Redshift only allows for growing
+// the length of a varchar
+func isVarcharGrowth(oldType string, oldAttributes []string, newType string, newAttributes []string) bool {
+	var oldLength, newLength int
+	_, err := fmt.Sscanf(oldType, "VARCHAR(%d)", &oldLength)
+	if err != nil {
+		return false
+	}
+	_, err = fmt.Sscanf(newType, "VARCHAR(%d)", &newLength)
+	if err != nil {
+		return false
+	}
+	return newLength > oldLength && strings.Join(oldAttributes, " ") == strings.Join(newAttributes, " ")
+}
+
+// changeColumnType returns the statements changing the type of a column.
+// Other than growing a varchar, Redshift can't alter the type of a column, so
+// a column of the new type is added, filled from the old one, and renamed to
+// replace it. The column moves to the end of the table.
+func changeColumnType(table string, old scoop_protocol.ColumnDefinition, transformer string, options string) ([]string, error) {
+	name := old.OutboundName
+	oldType, oldAttributes, err := columnType(name, old.Transformer, old.ColumnCreationOptions)
+	if err != nil {
+		return nil, err
+	}
+	newType, attributes, err := columnType(name, transformer, options)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "ALTER TABLE " + table
+	if oldType == newType && strings.Join(oldAttributes, " ") == strings.Join(attributes, " ") {
+		// e.g. a transformer with the same SQL type
+		return []string{}, nil
+	}
+	if isVarcharGrowth(oldType, oldAttributes, newType, attributes) {
+		return []string{fmt.Sprintf("%s ALTER COLUMN %s TYPE %s;", prefix, quoteIdentifier(name), newType)}, nil
+	}
+	for _, attribute := range append(oldAttributes, attributes...) {
+		if attribute == "DISTKEY" || attribute == "SORTKEY" || attribute == "NOT NULL" {
+			return nil, fmt.Errorf("column %s is %s, so its type can only be changed by growing its varchar length", name, attribute)
+		}
+	}
+	// validated identifiers can't contain $, so this never names another column
+	replacement := name + "$new"
+	return []string{
+		fmt.Sprintf("%s ADD COLUMN %s;", prefix, strings.Join(append([]string{quoteIdentifier(replacement), newType}, attributes...), " ")),
+		fmt.Sprintf("UPDATE %s SET %s = CAST(%s AS %s);", table, quoteIdentifier(replacement), quoteIdentifier(name), newType),
+		fmt.Sprintf("%s DROP COLUMN %s;", prefix, quoteIdentifier(name)),
+		fmt.Sprintf("%s RENAME COLUMN %s TO %s;", prefix, quoteIdentifier(replacement), quoteIdentifier(name)),
+	}, nil
+}
+
+// AlterTable returns the statements migrating a table with the operations of
+// a migration, in order, given the schema and table options before the
+// migration (nil for the defaults) and the schema after it. Changes to the
+// table options are applied after every column change.
+func AlterTable(before *scoop_protocol.Config, beforeOpts *core.TableOptions, schema *scoop_protocol.Config, ops []*scoop_protocol.Operation) ([]string, error) {
+	statements := make([]string, 0, len(ops))
+	prefix := "ALTER TABLE " + quoteIdentifier(schema.EventName)
+	var tableOpts *core.TableOptions
+	// previousOpts holds the table options before the migration, under the
+	// column names the operations give the sort keys
+	previousOpts := core.TableOptions{}
+	if beforeOpts != nil {
+		previousOpts = *beforeOpts
+		previousOpts.SortKeys = append([]string(nil), beforeOpts.SortKeys...)
+	}
+	// columns holds each column as the operations change it
+	columns := make(map[string]scoop_protocol.ColumnDefinition, len(before.Columns))
+	for _, col := range before.Columns {
+		columns[col.OutboundName] = col
+	}
+	for _, op := range ops {
+		switch op.Action {
+		case scoop_protocol.ADD:
+			_, attributes, err := columnType(op.Name, op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
+			if err != nil {
+				return nil, err
+			}
+			for _, attribute := range attributes {
+				if attribute == "DISTKEY" || attribute == "SORTKEY" || attribute == "NOT NULL" {
+					return nil, fmt.Errorf("column %s can't be added as %s to an existing table", op.Name, attribute)
+				}
+			}
+			def, err := columnDefinition(op.Name, op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, fmt.Sprintf("%s ADD COLUMN %s;", prefix, def))
+			columns[op.Name] = scoop_protocol.ColumnDefinition{
+				OutboundName:          op.Name,
+				Transformer:           op.ActionMetadata["column_type"],
+				ColumnCreationOptions: op.ActionMetadata["column_options"],
+			}
+		case scoop_protocol.DELETE:
+			statements = append(statements, fmt.Sprintf("%s DROP COLUMN %s;", prefix, quoteIdentifier(op.Name)))
+			delete(columns, op.Name)
+		case scoop_protocol.RENAME:
+			statements = append(statements, fmt.Sprintf("%s RENAME COLUMN %s TO %s;",
+				prefix, quoteIdentifier(op.Name), quoteIdentifier(op.ActionMetadata["new_outbound"])))
+			col := columns[op.Name]
+			col.OutboundName = op.ActionMetadata["new_outbound"]
+			columns[col.OutboundName] = col
+			delete(columns, op.Name)
+			for i := 0; tableOpts != nil && i < len(tableOpts.SortKeys); i++ {
+				if tableOpts.SortKeys[i] == op.Name {
+					tableOpts.SortKeys[i] = op.ActionMetadata["new_outbound"]
+				}
+			}
+			for i, key := range previousOpts.SortKeys {
+				if key == op.Name {
+					previousOpts.SortKeys[i] = op.ActionMetadata["new_outbound"]
+				}
+			}
+		case core.ChangeType:
+			col, ok := columns[op.Name]
+			if !ok {
+				return nil, fmt.Errorf("column %s does not exist, cannot change its type", op.Name)
+			}
+			changes, err := changeColumnType(quoteIdentifier(schema.EventName), col, op.ActionMetadata["column_type"], op.ActionMetadata["column_options"])
+			if err != nil {
+				return nil, err
+			}
+			statements = append(statements, changes...)
+			col.Transformer, col.ColumnCreationOptions = op.ActionMetadata["column_type"], op.ActionMetadata["column_options"]
+			columns[op.Name] = col
+		case core.Remap:
+			// only changes how ingesters populate the column
+		case core.SetState:
+			// only changes whether ingesters see the table
+		case core.DeprecateColumn:
+			// the column is kept until it is deleted
+		case core.RenameEvent:
+			statements = append(statements, fmt.Sprintf("ALTER TABLE %s RENAME TO %s;",
+				quoteIdentifier(op.Name), quoteIdentifier(op.ActionMetadata["new_name"])))
+		case core.SetTableOptions:
+			opts, err := core.OperationTableOptions(*op)
+			if err != nil {
+				return nil, err
+			}
+			tableOpts = &opts
+		default:
+			return nil, fmt.Errorf("unsupported operation action %s", op.Action)
+		}
+	}
+	if tableOpts != nil {
+		statements = append(statements, alterTableOptions(schema, previousOpts, *tableOpts)...)
+	}
+	return statements, nil
+}
@@ -0,0 +1,145 @@
+package redshift
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/scoop_protocol"
+)
+
+func TestCreateTable(t *testing.T) {
+	cfg := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "device_id", OutboundName: "device_id", Transformer: "varchar", ColumnCreationOptions: "(32) distkey"},
+			{InboundName: "ip", OutboundName: "country", Transformer: "ipCountry", ColumnCreationOptions: ""},
+		},
+	}
+	expected := `CREATE TABLE "video_play" (
+    "time" TIMESTAMP WITHOUT TIME ZONE SORTKEY,
+    "device_id" VARCHAR(32) DISTKEY,
+    "country" VARCHAR(2)
+);`
+	ddl, err := CreateTable(cfg, nil)
+	if err != nil || ddl != expected {
+		t.Errorf("CREATE TABLE differs from expected (err %v):\n%s\nvs\n%s", err, ddl, expected)
+	}
+
+	cfg.Columns[1].ColumnCreationOptions = " distkey"
+	_, err = CreateTable(cfg, nil)
+	if err == nil {
+		t.Error("Expected error for varchar column without length.")
+	}
+}
+
+func TestAlterTable(t *testing.T) {
+	add := scoop_protocol.NewAddOperation("os", "os", "varchar", "(16)")
+	del := scoop_protocol.NewDeleteOperation("minutes")
+	rename := scoop_protocol.NewRenameOperation("channel", "channel_name")
+	widen := core.NewChangeTypeOperation("channel", "varchar", "(255)")
+	before := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "channel", OutboundName: "channel", Transformer: "varchar", ColumnCreationOptions: "(32)"},
+			{InboundName: "minutes", OutboundName: "minutes", Transformer: "int", ColumnCreationOptions: ""},
+		},
+	}
+	statements, err := AlterTable(before, nil, &scoop_protocol.Config{EventName: "video_play"}, []*scoop_protocol.Operation{&del, &widen, &add, &rename})
+	expected := []string{
+		`ALTER TABLE "video_play" DROP COLUMN "minutes";`,
+		`ALTER TABLE "video_play" ALTER COLUMN "channel" TYPE VARCHAR(255);`,
+		`ALTER TABLE "video_play" ADD COLUMN "os" VARCHAR(16);`,
+		`ALTER TABLE "video_play" RENAME COLUMN "channel" TO "channel_name";`,
+	}
+	if err != nil || !reflect.DeepEqual(expected, statements) {
+		t.Errorf("ALTER TABLE statements differ from expected (err %v):\n%v\nvs\n%v", err, statements, expected)
+	}
+
+	key := scoop_protocol.NewAddOperation("user_id", "user_id", "bigint", " distkey")
+	_, err = AlterTable(before, nil, &scoop_protocol.Config{EventName: "video_play"}, []*scoop_protocol.Operation{&key})
+	if err == nil {
+		t.Error("Expected error adding a key column to an existing table.")
+	}
+
+	renameEvent := core.NewRenameEventOperation("video_play", "playback")
+	statements, err = AlterTable(before, nil, &scoop_protocol.Config{EventName: "playback"}, []*scoop_protocol.Operation{&renameEvent})
+	expected = []string{`ALTER TABLE "video_play" RENAME TO "playback";`}
+	if err != nil || !reflect.DeepEqual(expected, statements) {
+		t.Errorf("ALTER TABLE statements differ from expected (err %v):\n%v\nvs\n%v", err, statements, expected)
+	}
+}
+
+func TestAlterTableChangeType(t *testing.T) {
+	before := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "minutes", OutboundName: "minutes", Transformer: "int", ColumnCreationOptions: " encode az64"},
+		},
+	}
+	widen := core.NewChangeTypeOperation("minutes", "bigint", " encode az64")
+	statements, err := AlterTable(before, nil, before, []*scoop_protocol.Operation{&widen})
+	expected := []string{
+		`ALTER TABLE "video_play" ADD COLUMN "minutes$new" BIGINT ENCODE AZ64;`,
+		`UPDATE "video_play" SET "minutes$new" = CAST("minutes" AS BIGINT);`,
+		`ALTER TABLE "video_play" DROP COLUMN "minutes";`,
+		`ALTER TABLE "video_play" RENAME COLUMN "minutes$new" TO "minutes";`,
+	}
+	if err != nil || !reflect.DeepEqual(expected, statements) {
+		t.Errorf("int to bigint statements differ from expected (err %v):\n%v\nvs\n%v", err, statements, expected)
+	}
+
+	key := core.NewChangeTypeOperation("time", "bigint", " sortkey")
+	_, err = AlterTable(before, nil, before, []*scoop_protocol.Operation{&key})
+	if err == nil {
+		t.Error("Expected error replacing a sortkey column.")
+	}
+}
+
+func TestTableOptions(t *testing.T) {
+	cfg := &scoop_protocol.Config{
+		EventName: "video_play",
+		Columns: []scoop_protocol.ColumnDefinition{
+			{InboundName: "time", OutboundName: "time", Transformer: "f@timestamp@unix", ColumnCreationOptions: " sortkey"},
+			{InboundName: "device_id", OutboundName: "device_id", Transformer: "varchar", ColumnCreationOptions: "(32) distkey"},
+		},
+	}
+	backup := false
+	opts := core.TableOptions{DistStyle: core.DistStyleKey, SortKeys: []string{"time", "device_id"}, Backup: &backup}
+	expected := `CREATE TABLE "video_play" (
+    "time" TIMESTAMP WITHOUT TIME ZONE,
+    "device_id" VARCHAR(32) DISTKEY
+)
+BACKUP NO
+DISTSTYLE KEY
+COMPOUND SORTKEY ("time", "device_id");`
+	ddl, err := CreateTable(cfg, &opts)
+	if err != nil || ddl != expected {
+		t.Errorf("CREATE TABLE differs from expected (err %v):\n%s\nvs\n%s", err, ddl, expected)
+	}
+
+	// the distkey column already makes the table DISTSTYLE KEY
+	op := core.NewTableOptionsOperation(opts)
+	statements, err := AlterTable(cfg, nil, cfg, []*scoop_protocol.Operation{&op})
+	expectedStatements := []string{
+		`ALTER TABLE "video_play" ALTER COMPOUND SORTKEY ("time", "device_id");`,
+	}
+	if err != nil || !reflect.DeepEqual(expectedStatements, statements) {
+		t.Errorf("ALTER TABLE statements differ from expected (err %v):\n%v\nvs\n%v", err, statements, expectedStatements)
+	}
+
+	statements, err = AlterTable(cfg, &opts, cfg, []*scoop_protocol.Operation{&op})
+	if err != nil || len(statements) != 0 {
+		t.Errorf("Expected no statements for unchanged table options, got %v (err %v).", statements, err)
+	}
+
+	even := core.NewTableOptionsOperation(core.TableOptions{DistStyle: core.DistStyleEven, SortKeys: []string{"time", "device_id"}})
+	evenCfg := &scoop_protocol.Config{EventName: "video_play", Columns: cfg.Columns[:1]}
+	statements, err = AlterTable(evenCfg, &opts, evenCfg, []*scoop_protocol.Operation{&even})
+	expectedStatements = []string{`ALTER TABLE "video_play" ALTER DISTSTYLE EVEN;`}
+	if err != nil || !reflect.DeepEqual(expectedStatements, statements) {
+		t.Errorf("ALTER TABLE statements differ from expected (err %v):\n%v\nvs\n%v", err, statements, expectedStatements)
+	}
+}
@@ -27,7 +27,9 @@ import (
 var (
 	staticFileDir   = flag.String("staticfiles", "./static/events", "the location to serve static files from")
 	bpdbConnection  = flag.String("bpdbConnection", "", "The connection string for blueprintdb")
+	bpdbReplica     = flag.String("bpdbReplicaConnection", "", "The connection string for a read replica of blueprintdb to read schemas from")
 	nonTrackedQueue = flag.String("nonTrackedQueue", "", "SQS Queue name to listen to for nontracked events.")
+	cacheSchemas    = flag.Bool("cacheSchemas", false, "cache schemas from blueprintdb, invalidated by postgres notifications")
 )
 
 // BPHandler listens to SQS for new messages describing freshly uploaded event data in S3.
@@ -90,10 +92,16 @@ func main() {
 		logger.Fatal("Missing required flag: --nonTrackedQueue")
 	}
 
-	bpdb, err := bpdb.NewPostgresBackend(*bpdbConnection)
+	backend, err := bpdb.NewPostgresBackendWithReplica(*bpdbConnection, *bpdbReplica)
 	if err != nil {
 		log.Fatalf("Error creating bpdb backend: %v", err)
 	}
+	if *cacheSchemas {
+		backend, err = bpdb.NewCachingBackend(backend, *bpdbConnection)
+		if err != nil {
+			log.Fatalf("Error creating bpdb schema cache: %v", err)
+		}
+	}
 	// SQS listener pools SQS queue and then kicks off a jobs to
 	// suggest the schemas.
 
@@ -105,7 +113,7 @@ func main() {
 			Router: processor.NewRouter(
 				*staticFileDir,
 				5*time.Minute,
-				bpdb,
+				backend,
 			),
 			Downloader: s3manager.NewDownloader(session),
 		},
@@ -119,9 +119,10 @@ func (e *EventRouter) ReadFile(filename string) error {
 	return nil
 }
 
-// UpdateCurrentTables talks to bpdb and updates the list of tables that have been created.
+// UpdateCurrentTables talks to bpdb and updates the list of tables that have been created,
+// including drafts and retired events.
 func (e *EventRouter) UpdateCurrentTables() {
-	configs, err := e.bpdb.AllSchemas()
+	configs, err := e.bpdb.EventSchemas()
 	if err != nil {
 		logger.WithError(err).Error("Failed to fetch schemas from bpdb")
 		return
@@ -3,7 +3,7 @@ angular.module('blueprint', ['ngResource', 'ngRoute'])
     return $resource(
       '/event/:scope', null,
       {all: {url: '/events/all', method: 'GET', isArray: true},
-       published: {url: '/events/published', method: 'GET'},
+       published: {url: '/schemas', params: {state: 'published'}, method: 'GET', isArray: true},
        history: {url: '/events/:scope/history', method: 'GET'}}
     );
   })
@@ -0,0 +1,208 @@
+// Package transformers describes the transformers ingesters can apply to an
+// event property: the arguments each takes, the inbound value it expects and
+// the SQL type it outputs. The registry in use can be created from the
+// "transformers" section of the blueprint config.
+package transformers
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/twitchscience/blueprint/core"
+)
+
+// Arguments a transformer can take in the column options: the length of a
+// variable length type, or the precision of a fixed point type.
+const (
+	ArgumentLength    = "length"
+	ArgumentPrecision = "precision"
+)
+
+// Argument describes an argument a transformer takes in the column options,
+// and the bounds of its value
+type Argument struct {
+	Name     string
+	Required bool `json:",omitempty"`
+	Min      int  `json:",omitempty"`
+	Max      int  `json:",omitempty"`
+}
+
+// Transformer describes a transformer ingesters can apply to an event property
+type Transformer struct {
+	Name        string
+	Description string `json:",omitempty"`
+
+	// InboundType is the kind of value the event property must hold, e.g.
+	// "string", "ip" or "unix_timestamp".
+	InboundType string
+
+	// OutputType is the Redshift type of the column, without any arguments.
+	OutputType string
+
+	Arguments []Argument `json:",omitempty"`
+
+	// Deprecated transformers can't be used for new columns.
+	Deprecated bool `json:",omitempty"`
+}
+
+// Registry is a set of transformers
+type Registry struct {
+	transformers []Transformer
+	byName       map[string]Transformer
+}
+
+var defaultTransformers = []Transformer{
+	{Name: "bigint", InboundType: "int", OutputType: "BIGINT"},
+	{Name: "bool", InboundType: "bool", OutputType: "BOOLEAN"},
+	{Name: "float", InboundType: "float", OutputType: "FLOAT"},
+	{Name: "int", InboundType: "int", OutputType: "INT"},
+	{Name: "ipAsn", InboundType: "ip", OutputType: "VARCHAR(128)"},
+	{Name: "ipAsnInteger", InboundType: "ip", OutputType: "INT"},
+	{Name: "ipCity", InboundType: "ip", OutputType: "VARCHAR(64)"},
+	{Name: "ipCountry", InboundType: "ip", OutputType: "VARCHAR(2)"},
+	{Name: "ipRegion", InboundType: "ip", OutputType: "VARCHAR(64)"},
+	{Name: "stringToIntegerMD5", InboundType: "string", OutputType: "BIGINT"},
+	{
+		Name:        "varchar",
+		InboundType: "string",
+		OutputType:  "VARCHAR",
+		Arguments:   []Argument{{Name: ArgumentLength, Required: true, Min: 1, Max: 65535}},
+	},
+	{Name: "f@timestamp@unix", InboundType: "unix_timestamp", OutputType: "TIMESTAMP WITHOUT TIME ZONE"},
+}
+
+var (
+	currentLock sync.RWMutex
+	current     = Default()
+)
+
+// NewRegistry creates a registry of the transformers, which must have unique
+// names and an output type.
+func NewRegistry(transformers []Transformer) (*Registry, error) {
+	r := &Registry{byName: make(map[string]Transformer, len(transformers))}
+	for _, t := range transformers {
+		if t.Name == "" || t.OutputType == "" {
+			return nil, fmt.Errorf("transformer %q must have a name and output type", t.Name)
+		}
+		if _, exists := r.byName[t.Name]; exists {
+			return nil, fmt.Errorf("transformer %s defined twice", t.Name)
+		}
+		for _, arg := range t.Arguments {
+			if arg.Name != ArgumentLength && arg.Name != ArgumentPrecision {
+				return nil, fmt.Errorf("transformer %s has unknown argument %q", t.Name, arg.Name)
+			}
+		}
+		r.transformers = append(r.transformers, t)
+		r.byName[t.Name] = t
+	}
+	return r, nil
+}
+
+// Default returns the registry of the transformers built into the ingesters.
+func Default() *Registry {
+	r, err := NewRegistry(defaultTransformers)
+	if err != nil {
+		panic(err)
+	}
+	return r
+}
+
+// FromConfig creates the registry of the transformers configured in the
+// "transformers" section of the config, or returns the default registry if
+// the section is missing.
+func FromConfig(transformers []Transformer) (*Registry, error) {
+	if transformers == nil {
+		return Default(), nil
+	}
+	return NewRegistry(transformers)
+}
+
+// Current returns the registry in use.
+func Current() *Registry {
+	currentLock.RLock()
+	defer currentLock.RUnlock()
+	return current
+}
+
+// Use sets the registry in use.
+func Use(r *Registry) {
+	currentLock.Lock()
+	defer currentLock.Unlock()
+	current = r
+}
+
+// Get returns the transformer named `name`.
+func (r *Registry) Get(name string) (Transformer, bool) {
+	t, ok := r.byName[name]
+	return t, ok
+}
+
+// All returns every transformer in the registry, in the order defined.
+func (r *Registry) All() []Transformer {
+	return append([]Transformer(nil), r.transformers...)
+}
+
+// Names returns the names of the transformers that can be used for new columns.
+func (r *Registry) Names() []string {
+	names := []string{}
+	for _, t := range r.transformers {
+		if !t.Deprecated {
+			names = append(names, t.Name)
+		}
+	}
+	return names
+}
+
+// argument returns the argument of the transformer named `name`, if it takes one.
+func (t Transformer) argument(name string) (Argument, bool) {
+	for _, arg := range t.Arguments {
+		if arg.Name == name {
+			return arg, true
+		}
+	}
+	return Argument{}, false
+}
+
+// ValidateArguments checks the length and precision in the column options
+// against the arguments the transformer takes. Either given to a transformer
+// that doesn't take it is an error.
+func (t Transformer) ValidateArguments(opts core.ColumnOptions) error {
+	givens := []struct {
+		name  string
+		value int
+	}{{ArgumentLength, opts.Length}, {ArgumentPrecision, opts.Precision}}
+	for _, given := range givens {
+		if _, takes := t.argument(given.name); !takes && given.value != 0 {
+			return fmt.Errorf("transformer %s doesn't take a %s", t.Name, given.name)
+		}
+	}
+	for _, given := range givens {
+		arg, takes := t.argument(given.name)
+		switch {
+		case !takes:
+		case arg.Required && given.value == 0:
+			return fmt.Errorf("transformer %s needs a %s", t.Name, given.name)
+		case given.value != 0 && arg.Min != 0 && given.value < arg.Min:
+			return fmt.Errorf("%s of %s must be at least %d, given %d", given.name, t.Name, arg.Min, given.value)
+		case given.value != 0 && arg.Max != 0 && given.value > arg.Max:
+			return fmt.Errorf("%s of %s must be at most %d, given %d", given.name, t.Name, arg.Max, given.value)
+		}
+	}
+	return nil
+}
+
+// SQLType returns the Redshift type of a column using the transformer with
+// the column options, e.g. `VARCHAR(32)`.
+func (t Transformer) SQLType(opts core.ColumnOptions) (string, error) {
+	err := t.ValidateArguments(opts)
+	if err != nil {
+		return "", err
+	}
+	switch {
+	case opts.Length > 0:
+		return fmt.Sprintf("%s(%d)", t.OutputType, opts.Length), nil
+	case opts.Precision > 0:
+		return fmt.Sprintf("%s(%d,%d)", t.OutputType, opts.Precision, opts.Scale), nil
+	}
+	return t.OutputType, nil
+}
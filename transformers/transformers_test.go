@@ -0,0 +1,118 @@
+package transformers
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/twitchscience/blueprint/core"
+	"github.com/twitchscience/scoop_protocol/transformer"
+)
+
+func TestDefaultHasEveryTransformer(t *testing.T) {
+	r := Default()
+	for _, tr := range transformer.ValidTransforms {
+		if _, ok := r.Get(tr); !ok {
+			t.Errorf("No default registry entry for transformer %s.", tr)
+		}
+	}
+	if !reflect.DeepEqual(r.Names(), transformer.ValidTransforms) {
+		t.Errorf("Default transformers %v differ from %v.", r.Names(), transformer.ValidTransforms)
+	}
+}
+
+func TestValidateArguments(t *testing.T) {
+	r := Default()
+	varchar, _ := r.Get("varchar")
+	intType, _ := r.Get("int")
+
+	if err := varchar.ValidateArguments(core.ColumnOptions{Length: 32}); err != nil {
+		t.Errorf("Unexpected error for varchar(32): %v", err)
+	}
+	if err := varchar.ValidateArguments(core.ColumnOptions{}); err == nil {
+		t.Error("Expected error for varchar without length.")
+	}
+	if err := varchar.ValidateArguments(core.ColumnOptions{Length: 65536}); err == nil {
+		t.Error("Expected error for varchar longer than the max length.")
+	}
+	if err := intType.ValidateArguments(core.ColumnOptions{Length: 32}); err == nil {
+		t.Error("Expected error for int with length.")
+	}
+	if err := intType.ValidateArguments(core.ColumnOptions{Precision: 10, Scale: 2}); err == nil {
+		t.Error("Expected error for int with precision.")
+	}
+
+	sqlType, err := varchar.SQLType(core.ColumnOptions{Length: 32, DistKey: true})
+	if err != nil || sqlType != "VARCHAR(32)" {
+		t.Errorf("Expected VARCHAR(32), got %s (err %v).", sqlType, err)
+	}
+}
+
+func TestNewRegistry(t *testing.T) {
+	_, err := NewRegistry([]Transformer{{Name: "int", OutputType: "INT"}, {Name: "int", OutputType: "BIGINT"}})
+	if err == nil {
+		t.Error("Expected error for duplicate transformer.")
+	}
+	_, err = NewRegistry([]Transformer{{Name: "int"}})
+	if err == nil {
+		t.Error("Expected error for transformer without output type.")
+	}
+	_, err = NewRegistry([]Transformer{{Name: "decimal", OutputType: "DECIMAL", Arguments: []Argument{{Name: "size"}}}})
+	if err == nil {
+		t.Error("Expected error for unknown argument.")
+	}
+
+	r, err := NewRegistry([]Transformer{
+		{Name: "int", OutputType: "INT", Deprecated: true},
+		{Name: "char", OutputType: "CHAR", Arguments: []Argument{{Name: ArgumentLength, Required: true, Max: 4096}}},
+		{Name: "decimal", OutputType: "DECIMAL", Arguments: []Argument{{Name: ArgumentPrecision, Required: true, Max: 38}}},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error creating registry: %v", err)
+	}
+	if !reflect.DeepEqual(r.Names(), []string{"char", "decimal"}) {
+		t.Errorf("Expected only non-deprecated transformers in names, got %v.", r.Names())
+	}
+	char, _ := r.Get("char")
+	sqlType, err := char.SQLType(core.ColumnOptions{Length: 2})
+	if err != nil || sqlType != "CHAR(2)" {
+		t.Errorf("Expected CHAR(2), got %s (err %v).", sqlType, err)
+	}
+	decimal, _ := r.Get("decimal")
+	sqlType, err = decimal.SQLType(core.ColumnOptions{Precision: 10, Scale: 2})
+	if err != nil || sqlType != "DECIMAL(10,2)" {
+		t.Errorf("Expected DECIMAL(10,2), got %s (err %v).", sqlType, err)
+	}
+	if _, err = decimal.SQLType(core.ColumnOptions{Precision: 39}); err == nil {
+		t.Error("Expected error for decimal beyond the max precision.")
+	}
+	if _, err = char.SQLType(core.ColumnOptions{Precision: 10, Scale: 2}); err == nil {
+		t.Error("Expected error for char with precision.")
+	}
+}
+
+func TestFromConfig(t *testing.T) {
+	r, err := FromConfig(nil)
+	if err != nil || len(r.All()) != len(Default().All()) {
+		t.Errorf("Expected default registry for missing config, got %v (err %v).", r, err)
+	}
+
+	var config []Transformer
+	err = json.Unmarshal([]byte(`[
+		{"Name": "varchar", "InboundType": "string", "OutputType": "VARCHAR",
+		 "Arguments": [{"Name": "length", "Required": true, "Min": 1, "Max": 256}]}]`), &config)
+	if err != nil {
+		t.Fatalf("Error parsing config: %v", err)
+	}
+	r, err = FromConfig(config)
+	if err != nil {
+		t.Fatalf("Unexpected error creating registry from config: %v", err)
+	}
+	varchar, ok := r.Get("varchar")
+	if !ok || len(r.All()) != 1 {
+		t.Fatalf("Expected only varchar in registry, got %v.", r.All())
+	}
+	if err = varchar.ValidateArguments(core.ColumnOptions{Length: 512}); err == nil {
+		t.Error("Expected configured max length to be enforced.")
+	}
+}